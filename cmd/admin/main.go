@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/services/auth"
+	"github.com/JSONStatham/sso/internal/storage/postgres"
+	"github.com/JSONStatham/sso/internal/storage/sqlite"
+)
+
+// roleStorage is what the command needs. Admin RPCs require an admin, so
+// the first one is granted directly in storage.
+type roleStorage interface {
+	User(ctx context.Context, email string) (model.User, error)
+	GrantRole(ctx context.Context, uid, appID int64, role string) error
+	RevokeRole(ctx context.Context, uid, appID int64, role string) error
+	Close() error
+}
+
+const usage = `usage: admin --storage-path=PATH|--dsn=DSN <command> [flags]
+
+commands:
+  grant  --email=EMAIL
+  revoke --email=EMAIL`
+
+func main() {
+	var storagePath, dsn string
+
+	flag.StringVar(&storagePath, "storage-path", "", "path to sqlite storage")
+	flag.StringVar(&dsn, "dsn", "", "postgres connection string")
+	flag.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	flag.Parse()
+
+	if storagePath == "" && dsn == "" {
+		panic("storage-path or dsn is required")
+	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	storage, err := openStorage(storagePath, dsn)
+	if err != nil {
+		panic(err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	email := fs.String("email", "", "email of the user")
+	fs.Parse(args)
+
+	if *email == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	user, err := storage.User(ctx, *email)
+	if err != nil {
+		panic(err)
+	}
+
+	switch cmd {
+	case "grant":
+		if err := storage.GrantRole(ctx, int64(user.ID), auth.GlobalAppID, "admin"); err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("user %d is now an admin\n", user.ID)
+	case "revoke":
+		if err := storage.RevokeRole(ctx, int64(user.ID), auth.GlobalAppID, "admin"); err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("user %d is no longer an admin\n", user.ID)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func openStorage(storagePath, dsn string) (roleStorage, error) {
+	if dsn != "" {
+		return postgres.New(dsn)
+	}
+
+	return sqlite.New(storagePath)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/services/keyring"
+	"github.com/JSONStatham/sso/internal/storage/postgres"
+	"github.com/JSONStatham/sso/internal/storage/sqlite"
+	"github.com/JSONStatham/sso/internal/utils/jwt"
+	slogdiscard "github.com/JSONStatham/sso/internal/utils/logger/sl/handlers"
+)
+
+type keyStorage interface {
+	keyring.Storage
+	Close() error
+}
+
+const usage = `usage: keys --storage-path=PATH|--dsn=DSN --kek-path=PATH <command> [flags]
+
+commands:
+  generate --alg=EdDSA|ES256|RS256 [--activate-at=now|RFC3339]
+  import   --path=KEY.pem [--kid=ID] [--activate-at=now|RFC3339]
+  activate --kid=ID [--at=now|RFC3339]
+  retire   --kid=ID [--after=DURATION]
+  seal     encrypt keys stored before private keys were encrypted
+  list`
+
+func main() {
+	var storagePath, dsn, kekPath string
+
+	flag.StringVar(&storagePath, "storage-path", "", "path to sqlite storage")
+	flag.StringVar(&dsn, "dsn", "", "postgres connection string")
+	flag.StringVar(&kekPath, "kek-path", os.Getenv("JWT_KEY_ENCRYPTION_KEY_PATH"), "path to the key encryption key")
+	flag.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	flag.Parse()
+
+	if storagePath == "" && dsn == "" {
+		panic("storage-path or dsn is required")
+	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var kek *keyring.KEK
+	if kekPath != "" {
+		var err error
+		if kek, err = keyring.LoadKEK(kekPath); err != nil {
+			panic(err)
+		}
+	}
+
+	storage, err := openStorage(storagePath, dsn)
+	if err != nil {
+		panic(err)
+	}
+	defer storage.Close()
+
+	ring := keyring.New(slogdiscard.NewDiscardLogger(), storage, kek, nil, 0)
+	ctx := context.Background()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+
+	switch cmd {
+	case "generate":
+		alg := fs.String("alg", jwt.AlgEdDSA, "signing algorithm")
+		activateAt := fs.String("activate-at", "", "activation time, the key is only published if empty")
+		fs.Parse(args)
+
+		key, err := ring.Generate(ctx, *alg, mustParseTime(*activateAt))
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("generated %s key %s\n", key.Algorithm, key.ID)
+	case "import":
+		path := fs.String("path", "", "path to a PEM encoded private key")
+		kid := fs.String("kid", "", "key id, defaults to the JWK thumbprint")
+		activateAt := fs.String("activate-at", "", "activation time, the key is only published if empty")
+		fs.Parse(args)
+
+		key, err := jwt.LoadKey(*kid, *path)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := ring.Import(ctx, key, mustParseTime(*activateAt)); err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("imported %s key %s\n", key.Algorithm, key.ID)
+	case "activate":
+		kid := fs.String("kid", "", "key id")
+		at := fs.String("at", "now", "activation time")
+		fs.Parse(args)
+
+		if err := ring.Activate(ctx, *kid, mustParseTime(*at)); err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("key %s activated\n", *kid)
+	case "retire":
+		kid := fs.String("kid", "", "key id")
+		after := fs.Duration("after", 0, "keep verifying tokens signed by the key for this long")
+		fs.Parse(args)
+
+		if err := ring.Retire(ctx, *kid, time.Now().Add(*after)); err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("key %s retired\n", *kid)
+	case "seal":
+		sealed, err := ring.Seal(ctx)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("sealed %d keys\n", sealed)
+	case "list":
+		keys, err := ring.Keys(ctx)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, key := range keys {
+			fmt.Printf("%s\t%s\tactivates_at=%s\tretires_at=%s\n",
+				key.ID, key.Algorithm, formatTime(key.ActivatesAt), formatTime(key.RetiresAt))
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func mustParseTime(value string) time.Time {
+	switch value {
+	case "":
+		return time.Time{}
+	case "now":
+		return time.Now()
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func openStorage(storagePath, dsn string) (keyStorage, error) {
+	if dsn != "" {
+		return postgres.New(dsn)
+	}
+
+	return sqlite.New(storagePath)
+}
@@ -4,32 +4,48 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net/url"
 
 	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/pgx/v5"
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
 func main() {
-	var storagePath, migrationsPath, migrationsTable string
+	var storagePath, dsn, migrationsPath, migrationsTable string
 
-	flag.StringVar(&storagePath, "storage-path", "", "path to storage")
+	flag.StringVar(&storagePath, "storage-path", "", "path to sqlite storage")
+	flag.StringVar(&dsn, "dsn", "", "postgres connection string")
 	flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations")
 	flag.StringVar(&migrationsTable, "migrations-table", "migrations", "table name for migrations")
 	flag.Parse()
 
-	if storagePath == "" {
-		panic("storage-path is required")
+	if storagePath == "" && dsn == "" {
+		panic("storage-path or dsn is required")
 	}
 
 	if migrationsPath == "" {
 		panic("migrations-path is required")
 	}
 
-	m, err := migrate.New(
-		"file://"+migrationsPath,
-		fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable),
-	)
+	databaseURL := fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable)
+	if dsn != "" {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			panic(err)
+		}
+
+		// The pgx migrate driver is registered under its own scheme.
+		u.Scheme = "pgx5"
+		q := u.Query()
+		q.Set("x-migrations-table", migrationsTable)
+		u.RawQuery = q.Encode()
+
+		databaseURL = u.String()
+	}
+
+	m, err := migrate.New("file://"+migrationsPath, databaseURL)
 	if err != nil {
 		panic(err)
 	}
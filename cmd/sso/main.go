@@ -31,6 +31,8 @@ func main() {
 	app := app.New(log, cfg)
 
 	go app.GRPCSrv.MustRun()
+	go app.HTTPSrv.MustRun()
+	go app.MetricsSrv.MustRun()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
@@ -39,8 +41,13 @@ func main() {
 
 	log.Info("received signal", slog.String("signal", s.String()))
 
+	app.Health.Stop()
 	app.GRPCSrv.Stop()
+	app.HTTPSrv.Stop()
+	app.MetricsSrv.Stop()
+	app.Sweeper.Stop()
 	app.Storage.Close()
+	app.Tracing.Stop()
 
 	log.Info("application stopped")
 }
@@ -1,27 +1,163 @@
 package app
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"log/slog"
 
 	grpcapp "github.com/JSONStatham/sso/internal/app/grpc"
+	healthapp "github.com/JSONStatham/sso/internal/app/health"
+	httpapp "github.com/JSONStatham/sso/internal/app/http"
+	metricsapp "github.com/JSONStatham/sso/internal/app/metrics"
+	sweeperapp "github.com/JSONStatham/sso/internal/app/sweeper"
+	"github.com/JSONStatham/sso/internal/certs"
 	"github.com/JSONStatham/sso/internal/config"
+	"github.com/JSONStatham/sso/internal/mailer"
+	"github.com/JSONStatham/sso/internal/metrics"
+	"github.com/JSONStatham/sso/internal/password"
+	"github.com/JSONStatham/sso/internal/services/apps"
+	"github.com/JSONStatham/sso/internal/services/audit"
 	"github.com/JSONStatham/sso/internal/services/auth"
+	"github.com/JSONStatham/sso/internal/services/keyring"
+	"github.com/JSONStatham/sso/internal/storage/postgres"
 	"github.com/JSONStatham/sso/internal/storage/sqlite"
+	"github.com/JSONStatham/sso/internal/tracing"
+	"github.com/JSONStatham/sso/internal/utils/jwt"
+	"github.com/JSONStatham/sso/migrations"
 )
 
+// Storage is implemented by every storage backend.
+type Storage interface {
+	auth.Storage
+	apps.Storage
+	audit.Storage
+	keyring.Storage
+	sweeperapp.Storage
+	healthapp.Storage
+	metrics.DBStatter
+	CreateApp(ctx context.Context, name string) (int64, error)
+	SetAppSecret(ctx context.Context, appID int64, secretHash string) error
+	SetAppRequireMFA(ctx context.Context, appID int64, require bool) error
+	SetAppRequireVerifiedEmail(ctx context.Context, appID int64, require bool) error
+	SetAppClientType(ctx context.Context, appID int64, clientType string) error
+	AddAppRedirectURI(ctx context.Context, appID int64, redirectURI string) error
+	Close() error
+}
+
 type App struct {
-	GRPCSrv *grpcapp.App
-	Storage *sqlite.Storage
+	GRPCSrv    *grpcapp.App
+	HTTPSrv    *httpapp.App
+	MetricsSrv *metricsapp.App
+	Sweeper    *sweeperapp.App
+	Health     *healthapp.App
+	Tracing    *tracing.Tracing
+	Storage    Storage
 }
 
 func New(log *slog.Logger, cfg *config.Config) *App {
-	storage, err := sqlite.New(cfg.StoragePath)
+	// Spans are created with the global tracer provider, so it has to be
+	// installed before anything else.
+	tracer, err := tracing.New(context.Background(), log, cfg.Tracing)
+	if err != nil {
+		panic(err)
+	}
+
+	storage, err := NewStorage(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	var fallbackKey *jwt.Key
+	if cfg.JWT.PrivateKeyPath != "" {
+		key, err := jwt.LoadKey(cfg.JWT.KeyID, cfg.JWT.PrivateKeyPath)
+		if err != nil {
+			panic(err)
+		}
+
+		fallbackKey = &key
+	}
+
+	var kek *keyring.KEK
+	if cfg.JWT.KeyEncryptionKeyPath != "" {
+		kek, err = keyring.LoadKEK(cfg.JWT.KeyEncryptionKeyPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	keys := keyring.New(log, storage, kek, fallbackKey, cfg.JWT.KeyRefreshInterval)
+
+	mail, err := mailer.New(log, cfg.Mailer)
+	if err != nil {
+		panic(err)
+	}
+
+	policy, err := password.NewPolicy(cfg.PasswordPolicy)
 	if err != nil {
 		panic(err)
 	}
 
-	authService := auth.New(log, cfg, storage)
-	grpcApp := grpcapp.New(log, authService, cfg.GRPC.Port)
+	hasher, err := password.NewHasher(cfg.PasswordHasher)
+	if err != nil {
+		panic(err)
+	}
+
+	m := metrics.New()
+	m.RegisterDB(cfg.Storage.Driver, storage)
+
+	auditService := audit.New(log, storage)
+	authService := auth.New(log, cfg, storage, keys, mail, policy,
+		m.Hasher(hasher, cfg.PasswordHasher.Algorithm), m.Auditor(auditService))
+	appsService := apps.New(log, storage)
+
+	var tlsConfig *tls.Config
+	if cfg.GRPC.TLS.CertPath != "" {
+		reloader, err := certs.NewReloader(log, cfg.GRPC.TLS)
+		if err != nil {
+			panic(err)
+		}
+
+		tlsConfig = reloader.TLSConfig()
+	}
+
+	grpcApp := grpcapp.New(log, authService, appsService, auditService, m, tlsConfig, cfg.GRPC.TLS.ClientApps, cfg.GRPC.Port)
+	httpApp := httpapp.New(log, authService, cfg.JWT.Issuer, cfg.HTTP.Port, cfg.HTTP.Timeout)
+	metricsApp := metricsapp.New(log, m.Handler(), cfg.Metrics.Addr, cfg.Metrics.Timeout)
+
+	sweeper := sweeperapp.New(log, storage, cfg.SweepInterval)
+	go sweeper.Run()
 
-	return &App{GRPCSrv: grpcApp, Storage: storage}
+	schemaVersion, err := migrations.Latest(cfg.Storage.Driver)
+	if err != nil {
+		panic(err)
+	}
+
+	healthChecks := healthapp.New(log, storage, grpcApp, cfg.Storage.MigrationsTable, schemaVersion,
+		cfg.Health.Interval, cfg.Health.Timeout)
+	go healthChecks.Run()
+
+	return &App{
+		GRPCSrv:    grpcApp,
+		HTTPSrv:    httpApp,
+		MetricsSrv: metricsApp,
+		Sweeper:    sweeper,
+		Health:     healthChecks,
+		Tracing:    tracer,
+		Storage:    storage,
+	}
+}
+
+// NewStorage opens the storage backend selected by cfg.Storage.Driver.
+func NewStorage(cfg *config.Config) (Storage, error) {
+	const op = "app.NewStorage"
+
+	switch cfg.Storage.Driver {
+	case config.DriverSQLite:
+		return sqlite.New(cfg.StoragePath)
+	case config.DriverPostgres:
+		return postgres.New(cfg.Storage.DSN)
+	default:
+		return nil, fmt.Errorf("%s: unknown storage driver %q", op, cfg.Storage.Driver)
+	}
 }
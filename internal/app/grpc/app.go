@@ -1,25 +1,88 @@
 package grpcapp
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net"
+	"time"
 
+	"github.com/JSONStatham/sso/internal/grpc/access"
+	appsgrpc "github.com/JSONStatham/sso/internal/grpc/apps"
+	auditgrpc "github.com/JSONStatham/sso/internal/grpc/audit"
 	authgrpc "github.com/JSONStatham/sso/internal/grpc/auth"
+	"github.com/JSONStatham/sso/internal/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// stopTimeout bounds how long Stop waits for calls to finish. Health
+// watches never finish on their own.
+const stopTimeout = 10 * time.Second
+
 type App struct {
 	log    *slog.Logger
 	server *grpc.Server
+	health *health.Server
+	tls    bool
 	port   int
 }
 
-func New(log *slog.Logger, authService authgrpc.Auth, port int) *App {
-	server := grpc.NewServer()
+// AuthService is the auth service, which also authenticates the callers
+// of every method.
+type AuthService interface {
+	authgrpc.Auth
+	access.Authenticator
+}
+
+// New serves plaintext if tlsConfig is nil. clientApps maps the subjects of
+// verified client certificates to the apps they authenticate as.
+func New(log *slog.Logger, authService AuthService, appsService appsgrpc.Apps, auditService auditgrpc.Audit, m *metrics.Metrics, tlsConfig *tls.Config, clientApps map[string]int64, port int) *App {
+	interceptor := access.New(log, authService, access.Policies, clientApps)
+
+	opts := []grpc.ServerOption{
+		// Starts the span of every call, continuing the trace context of
+		// the incoming metadata.
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+		grpc.ChainUnaryInterceptor(m.UnaryServerInterceptor(), interceptor.Unary(), auditgrpc.RequestInterceptor()),
+		grpc.ChainStreamInterceptor(m.StreamServerInterceptor(), interceptor.Stream()),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(opts...)
 	authgrpc.Register(server, authService)
+	appsgrpc.Register(server, appsService)
+	auditgrpc.Register(server, auditService)
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	a := &App{log: log, server: server, health: healthServer, tls: tlsConfig != nil, port: port}
+	a.SetServing(false)
 
-	return &App{log: log, server: server, port: port}
+	return a
+}
+
+// SetServing reports the server and each of its services as serving or
+// not serving to health checks.
+func (a *App) SetServing(serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+
+	a.health.SetServingStatus("", status)
+	for service := range a.server.GetServiceInfo() {
+		if service != healthpb.Health_ServiceDesc.ServiceName {
+			a.health.SetServingStatus(service, status)
+		}
+	}
 }
 
 func (a *App) MustRun() {
@@ -41,7 +104,7 @@ func (a *App) Run() error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("gRPC server is running", slog.With("addr", lis.Addr().String()))
+	log.Info("gRPC server is running", slog.String("addr", lis.Addr().String()), slog.Bool("tls", a.tls))
 
 	if err := a.server.Serve(lis); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
@@ -53,7 +116,23 @@ func (a *App) Run() error {
 func (a *App) Stop() {
 	const op = "grpcapp.Stop"
 
-	a.log.With(slog.String("op", op)).Info("stopping gRPC server")
+	log := a.log.With(slog.String("op", op))
+	log.Info("stopping gRPC server")
+
+	// Health checks fail from now on, so no new calls are routed here
+	// while the pending ones finish.
+	a.health.Shutdown()
 
-	a.server.GracefulStop()
+	done := make(chan struct{})
+	go func() {
+		a.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		log.Warn("pending calls did not finish in time, closing them")
+		a.server.Stop()
+	}
 }
@@ -0,0 +1,137 @@
+package healthapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+var (
+	ErrDirtyMigration = errors.New("last migration failed")
+	ErrOutdatedSchema = errors.New("schema is not up to date")
+)
+
+// Storage reports whether the database is reachable and migrated.
+type Storage interface {
+	Ping(ctx context.Context) error
+	MigrationVersion(ctx context.Context, table string) (version uint, dirty bool, err error)
+}
+
+// Reporter publishes the outcome of the checks, e.g. the gRPC health
+// service.
+type Reporter interface {
+	SetServing(serving bool)
+}
+
+// App periodically checks that the storage is reachable and its schema is
+// migrated to at least the latest version known to the service. The
+// service is reported as not serving until the first check passes.
+type App struct {
+	log      *slog.Logger
+	st       Storage
+	reporter Reporter
+	table    string
+	version  uint
+	interval time.Duration
+	timeout  time.Duration
+	serving  bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New checks the schema version recorded in table against version.
+func New(log *slog.Logger, st Storage, reporter Reporter, table string, version uint, interval, timeout time.Duration) *App {
+	return &App{
+		log:      log,
+		st:       st,
+		reporter: reporter,
+		table:    table,
+		version:  version,
+		interval: interval,
+		timeout:  timeout,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (a *App) Run() {
+	const op = "healthapp.Run"
+
+	log := a.log.With(slog.String("op", op))
+
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	log.Info("health checks are running", slog.Duration("interval", a.interval))
+
+	a.update(log)
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.update(log)
+		}
+	}
+}
+
+func (a *App) Stop() {
+	const op = "healthapp.Stop"
+
+	a.log.With(slog.String("op", op)).Info("stopping health checks")
+
+	close(a.stop)
+	<-a.done
+}
+
+// update reports the outcome of a check and logs when it changes.
+func (a *App) update(log *slog.Logger) {
+	err := a.check()
+	serving := err == nil
+
+	a.reporter.SetServing(serving)
+
+	switch {
+	case serving && !a.serving:
+		log.Info("service is serving")
+	case !serving:
+		// Logged every time, the error may change while not serving.
+		log.Warn("service is not serving", sl.Err(err))
+	}
+
+	a.serving = serving
+}
+
+func (a *App) check() error {
+	const op = "healthapp.check"
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	if err := a.st.Ping(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	version, dirty, err := a.st.MigrationVersion(ctx, a.table)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if dirty {
+		return fmt.Errorf("%s: version %d: %w", op, version, ErrDirtyMigration)
+	}
+
+	// A newer schema is fine, it may belong to a release being rolled out.
+	if version < a.version {
+		return fmt.Errorf("%s: version %d, want %d: %w", op, version, a.version, ErrOutdatedSchema)
+	}
+
+	return nil
+}
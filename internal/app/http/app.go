@@ -0,0 +1,104 @@
+package httpapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/http/jwks"
+	"github.com/JSONStatham/sso/internal/http/oauth"
+	"github.com/JSONStatham/sso/internal/http/oidc"
+	"github.com/JSONStatham/sso/internal/services/audit"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+type App struct {
+	log    *slog.Logger
+	server *http.Server
+	port   int
+}
+
+// Service is what the HTTP endpoints are served by.
+type Service interface {
+	oauth.Service
+	oidc.Service
+}
+
+// New serves the endpoints on port. issuer is the public URL of the server,
+// which OpenID Connect discovery advertises the endpoints at.
+func New(log *slog.Logger, service Service, issuer string, port int, timeout time.Duration) *App {
+	mux := http.NewServeMux()
+	jwks.Register(mux, log, service)
+	oauth.Register(mux, log, service)
+	oidc.Register(mux, log, issuer, service)
+
+	server := &http.Server{
+		Handler:           auditRequest(mux),
+		ReadHeaderTimeout: timeout,
+		ReadTimeout:       timeout,
+		WriteTimeout:      timeout,
+	}
+
+	return &App{log: log, server: server, port: port}
+}
+
+// auditRequest attaches the peer and user agent of the request to the
+// context for the audit log.
+func auditRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			peer = r.RemoteAddr
+		}
+
+		ctx := audit.WithRequest(r.Context(), audit.Request{Peer: peer, UserAgent: r.UserAgent()})
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) Run() error {
+	const op = "httpapp.Run"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("port", a.port),
+	)
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", a.port))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("HTTP server is running", slog.String("addr", lis.Addr().String()))
+
+	if err := a.server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (a *App) Stop() {
+	const op = "httpapp.Stop"
+
+	log := a.log.With(slog.String("op", op))
+	log.Info("stopping HTTP server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.server.Shutdown(ctx); err != nil {
+		log.Error("failed to stop HTTP server gracefully", sl.Err(err))
+	}
+}
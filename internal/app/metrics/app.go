@@ -0,0 +1,79 @@
+package metricsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+type App struct {
+	log    *slog.Logger
+	server *http.Server
+	addr   string
+}
+
+// New serves the metrics handler at /metrics on addr. Nothing is served if
+// addr is empty.
+func New(log *slog.Logger, handler http.Handler, addr string, timeout time.Duration) *App {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", handler)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: timeout,
+		ReadTimeout:       timeout,
+		WriteTimeout:      timeout,
+	}
+
+	return &App{log: log, server: server, addr: addr}
+}
+
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) Run() error {
+	const op = "metricsapp.Run"
+
+	log := a.log.With(slog.String("op", op))
+
+	if a.addr == "" {
+		log.Info("metrics are disabled")
+		return nil
+	}
+
+	lis, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("metrics server is running", slog.String("addr", lis.Addr().String()))
+
+	if err := a.server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (a *App) Stop() {
+	const op = "metricsapp.Stop"
+
+	log := a.log.With(slog.String("op", op))
+	log.Info("stopping metrics server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.server.Shutdown(ctx); err != nil {
+		log.Error("failed to stop metrics server gracefully", sl.Err(err))
+	}
+}
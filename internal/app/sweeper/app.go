@@ -0,0 +1,136 @@
+package sweeperapp
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+// Storage removes rows that are no longer needed once they expire.
+type Storage interface {
+	DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredLoginAttempts(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredMFAChallenges(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredOneTimeTokens(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredAuthorizationCodes(ctx context.Context, now time.Time) (int64, error)
+}
+
+// App periodically garbage-collects expired entries of the token denylist,
+// expired refresh tokens, forgotten failed logins, expired MFA challenges,
+// expired one-time tokens and expired authorization codes.
+type App struct {
+	log      *slog.Logger
+	st       Storage
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func New(log *slog.Logger, st Storage, interval time.Duration) *App {
+	return &App{
+		log:      log,
+		st:       st,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (a *App) Run() {
+	const op = "sweeperapp.Run"
+
+	log := a.log.With(slog.String("op", op))
+
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	log.Info("sweeper is running", slog.Duration("interval", a.interval))
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-ticker.C:
+			a.sweep(log, now)
+		}
+	}
+}
+
+func (a *App) Stop() {
+	const op = "sweeperapp.Stop"
+
+	a.log.With(slog.String("op", op)).Info("stopping sweeper")
+
+	close(a.stop)
+	<-a.done
+}
+
+func (a *App) sweep(log *slog.Logger, now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.interval)
+	defer cancel()
+
+	deleted, err := a.st.DeleteExpiredRevokedTokens(ctx, now)
+	if err != nil {
+		log.Error("failed to delete expired revoked tokens", sl.Err(err))
+		return
+	}
+
+	if deleted > 0 {
+		log.Info("expired revoked tokens deleted", slog.Int64("count", deleted))
+	}
+
+	deleted, err = a.st.DeleteExpiredRefreshTokens(ctx, now)
+	if err != nil {
+		log.Error("failed to delete expired refresh tokens", sl.Err(err))
+		return
+	}
+
+	if deleted > 0 {
+		log.Info("expired refresh tokens deleted", slog.Int64("count", deleted))
+	}
+
+	deleted, err = a.st.DeleteExpiredLoginAttempts(ctx, now)
+	if err != nil {
+		log.Error("failed to delete expired login attempts", sl.Err(err))
+		return
+	}
+
+	if deleted > 0 {
+		log.Info("expired login attempts deleted", slog.Int64("count", deleted))
+	}
+
+	deleted, err = a.st.DeleteExpiredMFAChallenges(ctx, now)
+	if err != nil {
+		log.Error("failed to delete expired mfa challenges", sl.Err(err))
+		return
+	}
+
+	if deleted > 0 {
+		log.Info("expired mfa challenges deleted", slog.Int64("count", deleted))
+	}
+
+	deleted, err = a.st.DeleteExpiredOneTimeTokens(ctx, now)
+	if err != nil {
+		log.Error("failed to delete expired one-time tokens", sl.Err(err))
+		return
+	}
+
+	if deleted > 0 {
+		log.Info("expired one-time tokens deleted", slog.Int64("count", deleted))
+	}
+
+	deleted, err = a.st.DeleteExpiredAuthorizationCodes(ctx, now)
+	if err != nil {
+		log.Error("failed to delete expired authorization codes", sl.Err(err))
+		return
+	}
+
+	if deleted > 0 {
+		log.Info("expired authorization codes deleted", slog.Int64("count", deleted))
+	}
+}
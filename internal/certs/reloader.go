@@ -0,0 +1,181 @@
+// Package certs serves the TLS certificates of the gRPC listener from files
+// and picks up renewed certificates without a restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/config"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+var (
+	ErrNoCertificate = errors.New("certificate and key are required")
+	ErrNoClientCA    = errors.New("client CA is required to verify client certificates")
+	ErrInvalidCA     = errors.New("no certificate found in CA bundle")
+)
+
+// Reloader holds the certificate of the server and the CAs client
+// certificates are verified against. The files are checked for changes
+// during handshakes, at most once per reload interval, and loaded again
+// once they change. If they cannot be loaded, e.g. while a renewal is
+// written, the previous certificates are served until the next check.
+type Reloader struct {
+	log *slog.Logger
+	cfg config.TLSConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	config    *tls.Config
+	stamps    []stamp
+	checkedAt time.Time
+}
+
+// stamp identifies the version of a file.
+type stamp struct {
+	modTime int64
+	size    int64
+}
+
+// NewReloader loads the files of cfg. It fails if they are missing or
+// invalid.
+func NewReloader(log *slog.Logger, cfg config.TLSConfig) (*Reloader, error) {
+	const op = "certs.NewReloader"
+
+	if cfg.CertPath == "" || cfg.KeyPath == "" {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoCertificate)
+	}
+
+	if cfg.ClientCAPath == "" && (cfg.RequireClientCert || len(cfg.ClientApps) > 0) {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoClientCA)
+	}
+
+	r := &Reloader{log: log, cfg: cfg, now: time.Now}
+
+	config, stamps, err := r.load()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	r.config, r.stamps, r.checkedAt = config, stamps, r.now()
+
+	return r, nil
+}
+
+// TLSConfig returns the config of the listener. Every handshake uses the
+// certificates loaded last.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+// current reloads the files if they changed since they were loaded.
+func (r *Reloader) current() *tls.Config {
+	const op = "certs.current"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.checkedAt) < r.cfg.ReloadInterval {
+		return r.config
+	}
+	r.checkedAt = now
+
+	log := r.log.With(slog.String("op", op))
+
+	stamps, err := r.stat()
+	if err != nil {
+		log.Error("failed to check certificate files", sl.Err(err))
+		return r.config
+	}
+
+	if slices.Equal(stamps, r.stamps) {
+		return r.config
+	}
+
+	config, stamps, err := r.load()
+	if err != nil {
+		log.Error("failed to reload certificates, serving the previous ones", sl.Err(err))
+		return r.config
+	}
+
+	r.config, r.stamps = config, stamps
+
+	log.Info("certificates reloaded")
+
+	return r.config
+}
+
+func (r *Reloader) load() (*tls.Config, []stamp, error) {
+	// Files changing while they are read are loaded again on the next
+	// check, since they are stamped first.
+	stamps, err := r.stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertPath, r.cfg.KeyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// Configs returned by GetConfigForClient replace the one gRPC
+		// adds the protocol to.
+		NextProtos: []string{"h2"},
+	}
+
+	if r.cfg.ClientCAPath != "" {
+		bundle, err := os.ReadFile(r.cfg.ClientCAPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, nil, ErrInvalidCA
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.cfg.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return config, stamps, nil
+}
+
+func (r *Reloader) stat() ([]stamp, error) {
+	paths := []string{r.cfg.CertPath, r.cfg.KeyPath}
+	if r.cfg.ClientCAPath != "" {
+		paths = append(paths, r.cfg.ClientCAPath)
+	}
+
+	stamps := make([]stamp, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		stamps = append(stamps, stamp{modTime: info.ModTime().UnixNano(), size: info.Size()})
+	}
+
+	return stamps, nil
+}
@@ -0,0 +1,177 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/config"
+	slogdiscard "github.com/JSONStatham/sso/internal/utils/logger/sl/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloader_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		CertPath:       filepath.Join(dir, "server.pem"),
+		KeyPath:        filepath.Join(dir, "server.key"),
+		ReloadInterval: time.Minute,
+	}
+	writeCertificate(t, cfg.CertPath, cfg.KeyPath, "first", time.Now())
+
+	r, err := NewReloader(slogdiscard.NewDiscardLogger(), cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	assert.Equal(t, "first", servedName(t, r))
+
+	// Stamps are compared, so the renewal gets a later modification time
+	writeCertificate(t, cfg.CertPath, cfg.KeyPath, "second", time.Now().Add(time.Second))
+
+	// Files are only checked once per interval
+	assert.Equal(t, "first", servedName(t, r))
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, "second", servedName(t, r))
+}
+
+func TestReloader_KeepsCertificateWhileFilesAreInvalid(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		CertPath: filepath.Join(dir, "server.pem"),
+		KeyPath:  filepath.Join(dir, "server.key"),
+	}
+	writeCertificate(t, cfg.CertPath, cfg.KeyPath, "first", time.Now())
+
+	r, err := NewReloader(slogdiscard.NewDiscardLogger(), cfg)
+	require.NoError(t, err)
+
+	// The certificate of a renewal is written before its key
+	writeCertificate(t, cfg.CertPath, filepath.Join(dir, "next.key"), "second", time.Now().Add(time.Second))
+	assert.Equal(t, "first", servedName(t, r))
+
+	require.NoError(t, os.Rename(filepath.Join(dir, "next.key"), cfg.KeyPath))
+	assert.Equal(t, "second", servedName(t, r))
+}
+
+func TestReloader_ClientCA(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		CertPath:     filepath.Join(dir, "server.pem"),
+		KeyPath:      filepath.Join(dir, "server.key"),
+		ClientCAPath: filepath.Join(dir, "ca.pem"),
+	}
+	writeCertificate(t, cfg.CertPath, cfg.KeyPath, "server", time.Now())
+	writeCertificate(t, cfg.ClientCAPath, filepath.Join(dir, "ca.key"), "ca", time.Now())
+
+	r, err := NewReloader(slogdiscard.NewDiscardLogger(), cfg)
+	require.NoError(t, err)
+
+	served := r.current()
+	assert.Equal(t, tls.VerifyClientCertIfGiven, served.ClientAuth)
+	assert.NotNil(t, served.ClientCAs)
+
+	cfg.RequireClientCert = true
+	r, err = NewReloader(slogdiscard.NewDiscardLogger(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, r.current().ClientAuth)
+}
+
+func TestNewReloader_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	writeCertificate(t, certPath, keyPath, "server", time.Now())
+
+	notPEM := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+
+	tests := []struct {
+		name    string
+		cfg     config.TLSConfig
+		wantErr error
+	}{
+		{name: "No key", cfg: config.TLSConfig{CertPath: certPath}, wantErr: ErrNoCertificate},
+		{
+			name:    "Client certificate without CA",
+			cfg:     config.TLSConfig{CertPath: certPath, KeyPath: keyPath, RequireClientCert: true},
+			wantErr: ErrNoClientCA,
+		},
+		{
+			name:    "Client apps without CA",
+			cfg:     config.TLSConfig{CertPath: certPath, KeyPath: keyPath, ClientApps: map[string]int64{"CN=billing": 1}},
+			wantErr: ErrNoClientCA,
+		},
+		{
+			name:    "Invalid CA bundle",
+			cfg:     config.TLSConfig{CertPath: certPath, KeyPath: keyPath, ClientCAPath: notPEM},
+			wantErr: ErrInvalidCA,
+		},
+		{name: "Missing files", cfg: config.TLSConfig{CertPath: certPath, KeyPath: filepath.Join(dir, "missing.key")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReloader(slogdiscard.NewDiscardLogger(), tt.cfg)
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// servedName returns the common name of the certificate served to the next
+// client.
+func servedName(t *testing.T, r *Reloader) string {
+	t.Helper()
+
+	served, err := r.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.Len(t, served.Certificates, 1)
+
+	leaf, err := x509.ParseCertificate(served.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+
+	return leaf.Subject.CommonName
+}
+
+// writeCertificate writes a self-signed certificate and its key, both
+// modified at modTime.
+func writeCertificate(t *testing.T, certPath, keyPath, commonName string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	require.NoError(t, os.Chtimes(certPath, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyPath, modTime, modTime))
+}
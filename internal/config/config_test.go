@@ -0,0 +1,46 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustLoadByPath_MailerDriver(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string
+		driver string
+		panics bool
+	}{
+		{name: "smtp", env: "prod", driver: "smtp"},
+		{name: "missing", env: "prod", driver: "", panics: true},
+		{name: "unknown", env: "prod", driver: "sendmail", panics: true},
+		{name: "log outside tests", env: "prod", driver: "log", panics: true},
+		{name: "file outside tests", env: "local", driver: "file", panics: true},
+		{name: "log in tests", env: EnvTest, driver: "log"},
+		{name: "file in tests", env: EnvTest, driver: "file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			data := "env: " + tt.env + "\nstorage_path: sso.sqlite\ngrpc:\n  port: 4444\nhttp:\n  port: 4445\n"
+			if tt.driver != "" {
+				data += "mailer:\n  driver: " + tt.driver + "\n"
+			}
+			if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			load := func() { MustLoadByPath(path) }
+			if tt.panics {
+				assert.Panics(t, load)
+			} else {
+				assert.NotPanics(t, load)
+			}
+		})
+	}
+}
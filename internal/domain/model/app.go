@@ -1,9 +1,58 @@
 package model
 
-import "time"
+import (
+	"slices"
+	"strconv"
+	"time"
+)
+
+// OAuth 2.0 client types of apps. Confidential clients authenticate with
+// their secret, public clients such as browser and mobile apps cannot keep
+// a secret and have to use PKCE.
+const (
+	ClientConfidential = "confidential"
+	ClientPublic       = "public"
+)
 
 type App struct {
-	ID        int
-	Name      string
-	CreatedAt time.Time
+	ID                   int
+	Name                 string
+	SecretHash           string
+	RequireMFA           bool
+	RequireVerifiedEmail bool
+	ClientType           string
+	// AccessTokenTTL and RefreshTokenTTL override the global token
+	// lifetimes if set.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// GrantTypes lists the grants the app may use, every grant is allowed
+	// if it is empty.
+	GrantTypes []string
+	CreatedAt  time.Time
+}
+
+// Grant types an app can be restricted to. GrantPassword is the Login RPC.
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+)
+
+// AllowsGrant reports whether the app may use the grant type.
+func (a App) AllowsGrant(grant string) bool {
+	return len(a.GrantTypes) == 0 || slices.Contains(a.GrantTypes, grant)
+}
+
+// Audience returns the "aud" claim of tokens issued for the app, which is
+// its OAuth 2.0 client id.
+func (a App) Audience() string {
+	return strconv.Itoa(a.ID)
+}
+
+// AppConfig is an app together with its redirect URIs as managed by
+// admins.
+type AppConfig struct {
+	App          App
+	RedirectURIs []string
 }
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Types of audit events.
+const (
+	AuditUserRegistered  = "user.registered"
+	AuditLogin           = "user.login"
+	AuditMFA             = "user.mfa"
+	AuditLogout          = "user.logout"
+	AuditLockout         = "user.locked"
+	AuditPasswordChanged = "password.changed"
+	AuditPasswordReset   = "password.reset"
+	AuditRoleGranted     = "role.granted"
+	AuditRoleRevoked     = "role.revoked"
+)
+
+// Outcomes of audit events.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is an entry of the append-only audit log. Every entry is
+// chained to the one before it by PrevHash, so changing or removing an
+// entry breaks the chain.
+type AuditEvent struct {
+	ID   int64
+	Type string
+	// ActorID is the user who acted, TargetID the user acted upon. They
+	// are the same user unless e.g. an admin grants a role. Zero is unknown.
+	ActorID  int64
+	TargetID int64
+	AppID    int64
+	// Email identifies the target of failed logins of unknown users.
+	Email     string
+	Peer      string
+	UserAgent string
+	Outcome   string
+	// Reason is why the request failed, Detail adds to the type, e.g. the
+	// role granted.
+	Reason    string
+	Detail    string
+	CreatedAt time.Time
+	PrevHash  string
+	Hash      string
+}
+
+// ComputeHash returns the hash of the event chained to prevHash. CreatedAt
+// is hashed with microsecond precision, which every storage keeps.
+func (e AuditEvent) ComputeHash(prevHash string) string {
+	// The struct fixes the order of the fields and json escapes them, so
+	// different events cannot encode to the same bytes.
+	payload, _ := json.Marshal(struct {
+		Type      string `json:"type"`
+		ActorID   int64  `json:"actor_id"`
+		TargetID  int64  `json:"target_id"`
+		AppID     int64  `json:"app_id"`
+		Email     string `json:"email"`
+		Peer      string `json:"peer"`
+		UserAgent string `json:"user_agent"`
+		Outcome   string `json:"outcome"`
+		Reason    string `json:"reason"`
+		Detail    string `json:"detail"`
+		CreatedAt int64  `json:"created_at"`
+		PrevHash  string `json:"prev_hash"`
+	}{
+		Type:      e.Type,
+		ActorID:   e.ActorID,
+		TargetID:  e.TargetID,
+		AppID:     e.AppID,
+		Email:     e.Email,
+		Peer:      e.Peer,
+		UserAgent: e.UserAgent,
+		Outcome:   e.Outcome,
+		Reason:    e.Reason,
+		Detail:    e.Detail,
+		CreatedAt: e.CreatedAt.UnixMicro(),
+		PrevHash:  prevHash,
+	})
+
+	sum := sha256.Sum256(payload)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditFilter selects audit events. Zero fields match every event.
+type AuditFilter struct {
+	// UserID matches events the user acted in or was the target of.
+	UserID int64
+	AppID  int64
+	Type   string
+	Since  time.Time
+	Until  time.Time
+	// BeforeID pages through the events, which are returned newest first.
+	BeforeID int64
+	Limit    int
+}
+
+// AuditVerification is the result of checking the chain of the audit log.
+type AuditVerification struct {
+	Valid  bool
+	Events int64
+	// BrokenAt is the first event whose hash does not match.
+	BrokenAt int64
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// LoginAttempt tracks failed logins for a throttling key, e.g. an email or
+// a source address. It is forgotten once ExpiresAt passes.
+type LoginAttempt struct {
+	Key          string
+	Failures     int
+	BlockedUntil *time.Time
+	ExpiresAt    time.Time
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// TOTP is the authenticator app enrolled by a user. It only counts as a
+// second factor once it has been confirmed.
+type TOTP struct {
+	UserID       int64
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// MFAChallenge is issued by Login to users who have to pass a second factor
+// before they get tokens for the app.
+type MFAChallenge struct {
+	TokenHash string
+	UserID    int64
+	AppID     int64
+	ExpiresAt time.Time
+	// Enrollment challenges are issued to users of apps requiring MFA who
+	// have no second factor yet. They only let the user enroll one.
+	Enrollment bool
+}
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// AuthorizationRequest holds the parameters of an OAuth 2.0 authorization
+// request the authorization code is bound to.
+type AuthorizationRequest struct {
+	ClientID      int64
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	// Nonce is the OpenID Connect nonce put into the ID token.
+	Nonce string
+}
+
+// AuthorizationResult holds the authorization code, or the challenge token
+// to complete the authorization with if the user has to pass a second
+// factor first.
+type AuthorizationResult struct {
+	Code     string
+	MFAToken string
+}
+
+type AuthorizationCode struct {
+	CodeHash      string
+	AppID         int64
+	UserID        int64
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	Nonce         string
+	// AuthTime is when the user authenticated.
+	AuthTime  time.Time
+	ExpiresAt time.Time
+	// ConsumedAt is when the code was exchanged for tokens. The refresh
+	// token family issued for it is named after CodeHash.
+	ConsumedAt *time.Time
+}
+
+// UserInfo holds the OpenID Connect claims about a user.
+type UserInfo struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// SigningKey is a persisted token signing key. A key signs new tokens once
+// ActivatesAt has passed and is accepted for verification until RetiresAt.
+// PrivateKey is PEM encoded and encrypted by the key ring.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  []byte
+	ActivatesAt *time.Time
+	RetiresAt   *time.Time
+	CreatedAt   time.Time
+}
@@ -0,0 +1,55 @@
+package model
+
+import "time"
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	// IDToken is only issued for OpenID Connect requests.
+	IDToken string
+	// ExpiresIn is the lifetime of the access token.
+	ExpiresIn time.Duration
+}
+
+// LoginResult holds the tokens issued by Login, or the challenge token to
+// pass to VerifyMFA if the user has to pass a second factor first.
+type LoginResult struct {
+	Tokens   TokenPair
+	MFAToken string
+}
+
+// TokenInfo is the result of token introspection. Only Active is set for
+// tokens that are not active.
+type TokenInfo struct {
+	Active    bool
+	UID       int64
+	AppID     int64
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+type RefreshToken struct {
+	ID        int64
+	TokenHash string
+	FamilyID  string
+	UserID    int64
+	AppID     int64
+	ExpiresAt time.Time
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// Purposes of one-time tokens.
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+)
+
+// OneTimeToken is a single-use token sent to the user by email.
+type OneTimeToken struct {
+	TokenHash string
+	UserID    int64
+	Purpose   string
+	ExpiresAt time.Time
+}
@@ -3,8 +3,13 @@ package model
 import "time"
 
 type User struct {
-	ID        int
-	Email     string
-	Password  []byte
-	CreatedAt time.Time
+	ID            int
+	Email         string
+	Password      []byte
+	EmailVerified bool
+	// TokenVersion is bumped whenever the password changes. Access tokens
+	// carry the version they were issued for and are rejected once it is
+	// outdated.
+	TokenVersion int64
+	CreatedAt    time.Time
 }
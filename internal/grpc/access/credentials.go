@@ -0,0 +1,86 @@
+package access
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	errNoAppCredentials = errors.New("app credentials are required")
+	errNoAccessToken    = errors.New("access token is required")
+)
+
+// appCredentials extracts the app id and secret sent by the caller as
+// "authorization: Basic base64(app_id:secret)" metadata.
+func appCredentials(ctx context.Context) (int64, string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, "", errNoAppCredentials
+	}
+
+	for _, value := range md.Get("authorization") {
+		encoded, ok := strings.CutPrefix(value, "Basic ")
+		if !ok {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return 0, "", errNoAppCredentials
+		}
+
+		id, secret, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return 0, "", errNoAppCredentials
+		}
+
+		appID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || appID <= 0 {
+			return 0, "", errNoAppCredentials
+		}
+
+		return appID, secret, nil
+	}
+
+	return 0, "", errNoAppCredentials
+}
+
+// bearerToken extracts the access token sent by the caller as
+// "authorization: Bearer <token>" metadata.
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", errNoAccessToken
+	}
+
+	for _, value := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok && token != "" {
+			return token, nil
+		}
+	}
+
+	return "", errNoAccessToken
+}
+
+// certificateSubject returns the subject of the client certificate the
+// caller presented, if it was verified during the TLS handshake.
+func certificateSubject(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	return info.State.VerifiedChains[0][0].Subject.String(), true
+}
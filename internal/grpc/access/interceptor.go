@@ -0,0 +1,243 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/services/auth"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticator verifies the credentials of callers.
+type Authenticator interface {
+	VerifyAccessToken(ctx context.Context, accessToken string) (model.TokenInfo, error)
+	VerifyEnrollmentToken(ctx context.Context, mfaToken string) (model.TokenInfo, error)
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	AuthenticateApp(ctx context.Context, appID int64, secret string) (model.App, error)
+	AuthenticateAppCertificate(ctx context.Context, appID int64) (model.App, error)
+}
+
+// Caller is the identity of the caller of a method that is not public.
+type Caller struct {
+	// UserID and AppID are the user and app of the access token or
+	// enrollment token. For AppCredential methods AppID is the
+	// authenticated app.
+	UserID int64
+	AppID  int64
+	// Admin is set once the caller is known to be a global admin.
+	Admin bool
+	// App is the authenticated app of AppCredential methods.
+	App model.App
+}
+
+type callerKey struct{}
+
+// CallerFromContext returns the caller attached by the interceptors.
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(callerKey{}).(Caller)
+
+	return caller, ok
+}
+
+type Interceptor struct {
+	log        *slog.Logger
+	auth       Authenticator
+	policies   map[string]Policy
+	clientApps map[string]int64
+}
+
+// New enforces the policies of the methods. clientApps maps the subjects
+// of verified client certificates to the apps they authenticate as.
+func New(log *slog.Logger, auth Authenticator, policies map[string]Policy, clientApps map[string]int64) *Interceptor {
+	return &Interceptor{log: log, auth: auth, policies: policies, clientApps: clientApps}
+}
+
+// Unary authorizes unary calls before they reach the handler.
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := i.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// Stream authorizes streaming calls before they reach the handler.
+func (i *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := i.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorize enforces the policy of the method and returns the context with
+// the caller attached.
+func (i *Interceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+	const op = "grpc.access.authorize"
+
+	log := i.log.With(slog.String("op", op), slog.String("method", method))
+
+	policy, ok := i.policies[method]
+	if !ok {
+		log.Error("method has no access policy")
+
+		return nil, status.Error(codes.PermissionDenied, "method is not accessible")
+	}
+
+	var (
+		caller Caller
+		err    error
+	)
+	switch policy {
+	case Public:
+		return ctx, nil
+	case Authenticated, Admin:
+		caller, err = i.authenticateUser(ctx, policy == Admin)
+	case Enrollment:
+		caller, err = i.authenticateEnrollment(ctx)
+	case AppCredential:
+		caller, err = i.authenticateApp(ctx)
+	default:
+		err = fmt.Errorf("unknown policy %d", policy)
+	}
+
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			log.Warn("call rejected", slog.String("policy", policy.String()), sl.Err(err))
+			return nil, err
+		}
+
+		log.Error("failed to authorize call", sl.Err(err))
+		return nil, status.Error(codes.Internal, "failed to authorize call")
+	}
+
+	return context.WithValue(ctx, callerKey{}, caller), nil
+}
+
+func (i *Interceptor) authenticateUser(ctx context.Context, admin bool) (Caller, error) {
+	token, err := bearerToken(ctx)
+	if err != nil {
+		return Caller{}, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	info, err := i.auth.VerifyAccessToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return Caller{}, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		return Caller{}, err
+	}
+
+	caller := Caller{UserID: info.UID, AppID: info.AppID}
+
+	if admin {
+		isAdmin, err := i.auth.IsAdmin(ctx, info.UID)
+		if err != nil {
+			return Caller{}, err
+		}
+
+		if !isAdmin {
+			return Caller{}, status.Error(codes.PermissionDenied, "admin role is required")
+		}
+
+		caller.Admin = true
+	}
+
+	return caller, nil
+}
+
+// authenticateEnrollment accepts the access token of a user as well as the
+// enrollment token of a user who has to set up a second factor.
+func (i *Interceptor) authenticateEnrollment(ctx context.Context) (Caller, error) {
+	caller, err := i.authenticateUser(ctx, false)
+	if status.Code(err) != codes.Unauthenticated {
+		return caller, err
+	}
+
+	token, tokenErr := bearerToken(ctx)
+	if tokenErr != nil {
+		return Caller{}, err
+	}
+
+	info, err := i.auth.VerifyEnrollmentToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return Caller{}, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		return Caller{}, err
+	}
+
+	return Caller{UserID: info.UID, AppID: info.AppID}, nil
+}
+
+// authenticateApp checks the app credentials sent by the caller. Without
+// them, the client certificate of the caller may identify the app.
+func (i *Interceptor) authenticateApp(ctx context.Context) (Caller, error) {
+	appID, secret, err := appCredentials(ctx)
+	if err != nil {
+		if caller, ok, err := i.authenticateCertificate(ctx); ok || err != nil {
+			return caller, err
+		}
+
+		return Caller{}, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	app, err := i.auth.AuthenticateApp(ctx, appID, secret)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAppCredentials) {
+			return Caller{}, status.Error(codes.Unauthenticated, "invalid app credentials")
+		}
+
+		return Caller{}, err
+	}
+
+	return Caller{AppID: appID, App: app}, nil
+}
+
+// authenticateCertificate returns the app the client certificate of the
+// caller is mapped to. ok is false if there is no such app.
+func (i *Interceptor) authenticateCertificate(ctx context.Context) (_ Caller, ok bool, _ error) {
+	subject, ok := certificateSubject(ctx)
+	if !ok {
+		return Caller{}, false, nil
+	}
+
+	appID, ok := i.clientApps[subject]
+	if !ok {
+		return Caller{}, false, nil
+	}
+
+	app, err := i.auth.AuthenticateAppCertificate(ctx, appID)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAppCredentials) {
+			return Caller{}, true, status.Error(codes.Unauthenticated, "invalid app credentials")
+		}
+
+		return Caller{}, true, err
+	}
+
+	return Caller{AppID: appID, App: app}, true, nil
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
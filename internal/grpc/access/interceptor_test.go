@@ -0,0 +1,224 @@
+package access
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"testing"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/services/auth"
+	slogdiscard "github.com/JSONStatham/sso/internal/utils/logger/sl/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	userToken   = "user-token"
+	adminToken  = "admin-token"
+	enrollToken = "enroll-token"
+	appSecret   = "secret"
+	deletedApp  = 404
+)
+
+type fakeAuth struct{}
+
+func (fakeAuth) VerifyAccessToken(_ context.Context, token string) (model.TokenInfo, error) {
+	switch token {
+	case userToken:
+		return model.TokenInfo{UID: 1, AppID: 1}, nil
+	case adminToken:
+		return model.TokenInfo{UID: 2, AppID: 1}, nil
+	default:
+		return model.TokenInfo{}, auth.ErrInvalidToken
+	}
+}
+
+func (fakeAuth) VerifyEnrollmentToken(_ context.Context, token string) (model.TokenInfo, error) {
+	if token != enrollToken {
+		return model.TokenInfo{}, auth.ErrInvalidToken
+	}
+
+	return model.TokenInfo{UID: 3, AppID: 2}, nil
+}
+
+func (fakeAuth) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	return userID == 2, nil
+}
+
+func (fakeAuth) AuthenticateApp(_ context.Context, appID int64, secret string) (model.App, error) {
+	if secret != appSecret {
+		return model.App{}, auth.ErrInvalidAppCredentials
+	}
+
+	return model.App{ID: int(appID)}, nil
+}
+
+func (fakeAuth) AuthenticateAppCertificate(_ context.Context, appID int64) (model.App, error) {
+	if appID == deletedApp {
+		return model.App{}, auth.ErrInvalidAppCredentials
+	}
+
+	return model.App{ID: int(appID)}, nil
+}
+
+// withClientCertificate attaches a peer that presented a verified client
+// certificate with the common name to ctx.
+func withClientCertificate(ctx context.Context, commonName string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	info := credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+
+	return peer.NewContext(ctx, &peer.Peer{AuthInfo: info})
+}
+
+func TestInterceptor_Unary(t *testing.T) {
+	policies := map[string]Policy{
+		"/public":        Public,
+		"/authenticated": Authenticated,
+		"/admin":         Admin,
+		"/app":           AppCredential,
+		"/enrollment":    Enrollment,
+	}
+	clientApps := map[string]int64{"CN=billing": 9, "CN=deleted": deletedApp}
+	unary := New(slogdiscard.NewDiscardLogger(), fakeAuth{}, policies, clientApps).Unary()
+
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("7:"+appSecret))
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		clientCert    string
+		wantCode      codes.Code
+		wantCaller    Caller
+	}{
+		{name: "Public", method: "/public", wantCode: codes.OK},
+		{name: "Unknown method", method: "/unknown", wantCode: codes.PermissionDenied},
+		{name: "Missing token", method: "/authenticated", wantCode: codes.Unauthenticated},
+		{name: "Invalid token", method: "/authenticated", authorization: "Bearer invalid", wantCode: codes.Unauthenticated},
+		{name: "App credentials for user method", method: "/authenticated", authorization: basic, wantCode: codes.Unauthenticated},
+		{
+			name:          "Authenticated",
+			method:        "/authenticated",
+			authorization: "Bearer " + userToken,
+			wantCode:      codes.OK,
+			wantCaller:    Caller{UserID: 1, AppID: 1},
+		},
+		{name: "Not an admin", method: "/admin", authorization: "Bearer " + userToken, wantCode: codes.PermissionDenied},
+		{
+			name:          "Admin",
+			method:        "/admin",
+			authorization: "Bearer " + adminToken,
+			wantCode:      codes.OK,
+			wantCaller:    Caller{UserID: 2, AppID: 1, Admin: true},
+		},
+		{name: "Missing app credentials", method: "/app", authorization: "Bearer " + userToken, wantCode: codes.Unauthenticated},
+		{
+			name:          "Invalid app secret",
+			method:        "/app",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("7:wrong")),
+			wantCode:      codes.Unauthenticated,
+		},
+		{
+			name:          "App",
+			method:        "/app",
+			authorization: basic,
+			wantCode:      codes.OK,
+			wantCaller:    Caller{AppID: 7, App: model.App{ID: 7}},
+		},
+		{
+			name:       "Client certificate",
+			method:     "/app",
+			clientCert: "billing",
+			wantCode:   codes.OK,
+			wantCaller: Caller{AppID: 9, App: model.App{ID: 9}},
+		},
+		{
+			name:          "App credentials before client certificate",
+			method:        "/app",
+			authorization: basic,
+			clientCert:    "billing",
+			wantCode:      codes.OK,
+			wantCaller:    Caller{AppID: 7, App: model.App{ID: 7}},
+		},
+		{
+			name:          "Enrollment with access token",
+			method:        "/enrollment",
+			authorization: "Bearer " + userToken,
+			wantCode:      codes.OK,
+			wantCaller:    Caller{UserID: 1, AppID: 1},
+		},
+		{
+			name:          "Enrollment with enrollment token",
+			method:        "/enrollment",
+			authorization: "Bearer " + enrollToken,
+			wantCode:      codes.OK,
+			wantCaller:    Caller{UserID: 3, AppID: 2},
+		},
+		{name: "Enrollment without token", method: "/enrollment", wantCode: codes.Unauthenticated},
+		{name: "Enrollment with invalid token", method: "/enrollment", authorization: "Bearer invalid", wantCode: codes.Unauthenticated},
+		{name: "Enrollment token for user method", method: "/authenticated", authorization: "Bearer " + enrollToken, wantCode: codes.Unauthenticated},
+		{name: "Unmapped client certificate", method: "/app", clientCert: "other", wantCode: codes.Unauthenticated},
+		{name: "Client certificate of deleted app", method: "/app", clientCert: "deleted", wantCode: codes.Unauthenticated},
+		{name: "Client certificate for user method", method: "/authenticated", clientCert: "billing", wantCode: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+			if tt.clientCert != "" {
+				ctx = withClientCertificate(ctx, tt.clientCert)
+			}
+
+			called := false
+			handler := func(ctx context.Context, req any) (any, error) {
+				called = true
+
+				caller, ok := CallerFromContext(ctx)
+				assert.Equal(t, tt.method != "/public", ok)
+				assert.Equal(t, tt.wantCaller, caller)
+
+				return nil, nil
+			}
+
+			_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			require.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantCode == codes.OK, called)
+		})
+	}
+}
+
+func TestPolicies_SessionMethodsRequireToken(t *testing.T) {
+	unary := New(slogdiscard.NewDiscardLogger(), fakeAuth{}, Policies, nil).Unary()
+
+	methods := []string{
+		ssov1.Auth_EnrollTOTP_FullMethodName,
+		ssov1.Auth_ConfirmTOTP_FullMethodName,
+		ssov1.Auth_DisableTOTP_FullMethodName,
+		ssov1.Auth_ChangePassword_FullMethodName,
+	}
+
+	for _, method := range methods {
+		t.Run(method, func(t *testing.T) {
+			handler := func(ctx context.Context, req any) (any, error) {
+				t.Fatal("handler called without a token")
+				return nil, nil
+			}
+
+			_, err := unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Package access authenticates gRPC callers and enforces who may call which
+// method.
+package access
+
+import (
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Policy decides which credentials a method requires.
+type Policy int
+
+const (
+	// Public methods need no credentials. Some of them check a token sent
+	// in the request themselves.
+	Public Policy = iota + 1
+	// Authenticated methods need the access token of a user.
+	Authenticated
+	// Admin methods need the access token of a global admin.
+	Admin
+	// AppCredential methods need the id and secret of an app.
+	AppCredential
+	// Enrollment methods need the access token of a user or the challenge
+	// token Login returns to users who have to enroll a second factor.
+	Enrollment
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Public:
+		return "public"
+	case Authenticated:
+		return "authenticated"
+	case Admin:
+		return "admin"
+	case AppCredential:
+		return "app"
+	case Enrollment:
+		return "enrollment"
+	default:
+		return "unknown"
+	}
+}
+
+// Policies holds the policy of every method served. Calls of methods
+// missing from it are rejected.
+var Policies = map[string]Policy{
+	ssov1.Auth_Register_FullMethodName:             Public,
+	ssov1.Auth_Login_FullMethodName:                Public,
+	ssov1.Auth_VerifyMFA_FullMethodName:            Public,
+	ssov1.Auth_Refresh_FullMethodName:              Public,
+	ssov1.Auth_Logout_FullMethodName:               Public,
+	ssov1.Auth_JWKS_FullMethodName:                 Public,
+	ssov1.Auth_EnrollTOTP_FullMethodName:           Enrollment,
+	ssov1.Auth_ConfirmTOTP_FullMethodName:          Enrollment,
+	ssov1.Auth_DisableTOTP_FullMethodName:          Authenticated,
+	ssov1.Auth_SendVerification_FullMethodName:     Public,
+	ssov1.Auth_ConfirmEmail_FullMethodName:         Public,
+	ssov1.Auth_RequestPasswordReset_FullMethodName: Public,
+	ssov1.Auth_ResetPassword_FullMethodName:        Public,
+	ssov1.Auth_ChangePassword_FullMethodName:       Authenticated,
+	ssov1.Auth_IsAdmin_FullMethodName:              Authenticated,
+	ssov1.Auth_GrantRole_FullMethodName:            Admin,
+	ssov1.Auth_RevokeRole_FullMethodName:           Admin,
+	ssov1.Auth_CheckPermission_FullMethodName:      AppCredential,
+	ssov1.Auth_Introspect_FullMethodName:           AppCredential,
+
+	ssov1.AppService_CreateApp_FullMethodName:       Admin,
+	ssov1.AppService_GetApp_FullMethodName:          Admin,
+	ssov1.AppService_ListApps_FullMethodName:        Admin,
+	ssov1.AppService_UpdateApp_FullMethodName:       Admin,
+	ssov1.AppService_DeleteApp_FullMethodName:       Admin,
+	ssov1.AppService_RotateAppSecret_FullMethodName: Admin,
+
+	ssov1.AuditService_ListAuditEvents_FullMethodName: Admin,
+	ssov1.AuditService_VerifyAuditLog_FullMethodName:  Admin,
+
+	healthpb.Health_Check_FullMethodName: Public,
+	healthpb.Health_Watch_FullMethodName: Public,
+}
@@ -0,0 +1,214 @@
+// Package apps serves the admin-only AppService.
+package apps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/services/apps"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+var validate = validator.New()
+
+type Apps interface {
+	Create(ctx context.Context, config model.AppConfig) (model.AppConfig, string, error)
+	Get(ctx context.Context, appID int64) (model.AppConfig, error)
+	List(ctx context.Context) ([]model.AppConfig, error)
+	Update(ctx context.Context, config model.AppConfig) (model.AppConfig, error)
+	Delete(ctx context.Context, appID int64) error
+	RotateSecret(ctx context.Context, appID int64) (string, error)
+}
+
+type AppSettings struct {
+	Name                   string `validate:"required"`
+	AccessTokenTTLSeconds  int64  `validate:"gte=0"`
+	RefreshTokenTTLSeconds int64  `validate:"gte=0"`
+}
+
+type AppIDRequest struct {
+	AppID int64 `validate:"required"`
+}
+
+type serverAPI struct {
+	ssov1.UnimplementedAppServiceServer
+	apps Apps
+}
+
+// Register serves the AppService. Its methods are restricted to admins by
+// the access interceptor.
+func Register(gRPC *grpc.Server, apps Apps) {
+	ssov1.RegisterAppServiceServer(gRPC, &serverAPI{apps: apps})
+}
+
+func (s *serverAPI) CreateApp(ctx context.Context, req *ssov1.CreateAppRequest) (*ssov1.CreateAppResponse, error) {
+	settings := AppSettings{
+		Name:                   req.GetName(),
+		AccessTokenTTLSeconds:  req.GetAccessTokenTtlSeconds(),
+		RefreshTokenTTLSeconds: req.GetRefreshTokenTtlSeconds(),
+	}
+
+	if err := validate.Struct(settings); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	config, secret, err := s.apps.Create(ctx, model.AppConfig{
+		App: model.App{
+			Name:                 settings.Name,
+			ClientType:           req.GetClientType(),
+			RequireMFA:           req.GetRequireMfa(),
+			RequireVerifiedEmail: req.GetRequireVerifiedEmail(),
+			AccessTokenTTL:       time.Duration(settings.AccessTokenTTLSeconds) * time.Second,
+			RefreshTokenTTL:      time.Duration(settings.RefreshTokenTTLSeconds) * time.Second,
+			GrantTypes:           req.GetGrantTypes(),
+		},
+		RedirectURIs: req.GetRedirectUris(),
+	})
+	if err != nil {
+		return nil, appError(err, "failed to create app")
+	}
+
+	return &ssov1.CreateAppResponse{
+		App:          toProto(config),
+		ClientSecret: secret,
+	}, nil
+}
+
+func (s *serverAPI) GetApp(ctx context.Context, req *ssov1.GetAppRequest) (*ssov1.GetAppResponse, error) {
+	getReq := AppIDRequest{AppID: req.GetAppId()}
+
+	if err := validate.Struct(getReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	config, err := s.apps.Get(ctx, getReq.AppID)
+	if err != nil {
+		return nil, appError(err, "failed to get app")
+	}
+
+	return &ssov1.GetAppResponse{App: toProto(config)}, nil
+}
+
+func (s *serverAPI) ListApps(ctx context.Context, req *ssov1.ListAppsRequest) (*ssov1.ListAppsResponse, error) {
+	configs, err := s.apps.List(ctx)
+	if err != nil {
+		return nil, appError(err, "failed to list apps")
+	}
+
+	resp := &ssov1.ListAppsResponse{}
+	for _, config := range configs {
+		resp.Apps = append(resp.Apps, toProto(config))
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) UpdateApp(ctx context.Context, req *ssov1.UpdateAppRequest) (*ssov1.UpdateAppResponse, error) {
+	idReq := AppIDRequest{AppID: req.GetAppId()}
+	settings := AppSettings{
+		Name:                   req.GetName(),
+		AccessTokenTTLSeconds:  req.GetAccessTokenTtlSeconds(),
+		RefreshTokenTTLSeconds: req.GetRefreshTokenTtlSeconds(),
+	}
+
+	for _, v := range []any{idReq, settings} {
+		if err := validate.Struct(v); err != nil {
+			validationErr := err.(validator.ValidationErrors)
+			return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+		}
+	}
+
+	config, err := s.apps.Update(ctx, model.AppConfig{
+		App: model.App{
+			ID:                   int(idReq.AppID),
+			Name:                 settings.Name,
+			ClientType:           req.GetClientType(),
+			RequireMFA:           req.GetRequireMfa(),
+			RequireVerifiedEmail: req.GetRequireVerifiedEmail(),
+			AccessTokenTTL:       time.Duration(settings.AccessTokenTTLSeconds) * time.Second,
+			RefreshTokenTTL:      time.Duration(settings.RefreshTokenTTLSeconds) * time.Second,
+			GrantTypes:           req.GetGrantTypes(),
+		},
+		RedirectURIs: req.GetRedirectUris(),
+	})
+	if err != nil {
+		return nil, appError(err, "failed to update app")
+	}
+
+	return &ssov1.UpdateAppResponse{App: toProto(config)}, nil
+}
+
+func (s *serverAPI) DeleteApp(ctx context.Context, req *ssov1.DeleteAppRequest) (*emptypb.Empty, error) {
+	deleteReq := AppIDRequest{AppID: req.GetAppId()}
+
+	if err := validate.Struct(deleteReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	if err := s.apps.Delete(ctx, deleteReq.AppID); err != nil {
+		return nil, appError(err, "failed to delete app")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) RotateAppSecret(ctx context.Context, req *ssov1.RotateAppSecretRequest) (*ssov1.RotateAppSecretResponse, error) {
+	rotateReq := AppIDRequest{AppID: req.GetAppId()}
+
+	if err := validate.Struct(rotateReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	secret, err := s.apps.RotateSecret(ctx, rotateReq.AppID)
+	if err != nil {
+		return nil, appError(err, "failed to rotate secret")
+	}
+
+	return &ssov1.RotateAppSecretResponse{ClientSecret: secret}, nil
+}
+
+func appError(err error, msg string) error {
+	switch {
+	case errors.Is(err, apps.ErrInvalidSettings):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, apps.ErrPublicClient):
+		return status.Error(codes.FailedPrecondition, "public clients have no secret")
+	case errors.Is(err, storage.ErrAppNotFound):
+		return status.Error(codes.NotFound, "app not found")
+	case errors.Is(err, storage.ErrAppAlreadyExists):
+		return status.Error(codes.AlreadyExists, "app already exists")
+	default:
+		return status.Error(codes.Internal, fmt.Sprintf("%s: %v", msg, err))
+	}
+}
+
+func toProto(config model.AppConfig) *ssov1.App {
+	app := config.App
+
+	return &ssov1.App{
+		Id:                     int64(app.ID),
+		Name:                   app.Name,
+		ClientType:             app.ClientType,
+		RequireMfa:             app.RequireMFA,
+		RequireVerifiedEmail:   app.RequireVerifiedEmail,
+		RedirectUris:           config.RedirectURIs,
+		GrantTypes:             app.GrantTypes,
+		AccessTokenTtlSeconds:  int64(app.AccessTokenTTL.Seconds()),
+		RefreshTokenTtlSeconds: int64(app.RefreshTokenTTL.Seconds()),
+		HasSecret:              app.SecretHash != "",
+		CreatedAt:              app.CreatedAt.Unix(),
+	}
+}
@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"context"
+	"net"
+
+	"github.com/JSONStatham/sso/internal/grpc/access"
+	"github.com/JSONStatham/sso/internal/services/audit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// RequestInterceptor attaches the peer, user agent and authenticated user
+// of the call to the context for the audit log. It has to run after the
+// access interceptor to see the caller.
+func RequestInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		request := audit.Request{Peer: peerAddr(ctx)}
+
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if userAgent := md.Get("user-agent"); len(userAgent) > 0 {
+				request.UserAgent = userAgent[0]
+			}
+		}
+
+		if caller, ok := access.CallerFromContext(ctx); ok {
+			request.ActorID = caller.UserID
+		}
+
+		return handler(audit.WithRequest(ctx, request), req)
+	}
+}
+
+// peerAddr returns the IP address of the caller without its port.
+func peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
@@ -0,0 +1,133 @@
+// Package audit serves the admin-only AuditService and attaches the
+// request details recorded in the audit log to the context.
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/services/audit"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var validate = validator.New()
+
+type Audit interface {
+	Events(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, int64, error)
+	Verify(ctx context.Context) (model.AuditVerification, error)
+}
+
+type ListRequest struct {
+	UserID   int64 `validate:"gte=0"`
+	AppID    int64 `validate:"gte=0"`
+	Since    int64 `validate:"gte=0"`
+	Until    int64 `validate:"gte=0"`
+	PageSize int32 `validate:"gte=0,lte=500"`
+}
+
+type serverAPI struct {
+	ssov1.UnimplementedAuditServiceServer
+	audit Audit
+}
+
+// Register serves the AuditService. Its methods are restricted to admins by
+// the access interceptor.
+func Register(gRPC *grpc.Server, audit Audit) {
+	ssov1.RegisterAuditServiceServer(gRPC, &serverAPI{audit: audit})
+}
+
+func (s *serverAPI) ListAuditEvents(ctx context.Context, req *ssov1.ListAuditEventsRequest) (*ssov1.ListAuditEventsResponse, error) {
+	listReq := ListRequest{
+		UserID:   req.GetUserId(),
+		AppID:    req.GetAppId(),
+		Since:    req.GetSince(),
+		Until:    req.GetUntil(),
+		PageSize: req.GetPageSize(),
+	}
+
+	if err := validate.Struct(listReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	filter := model.AuditFilter{
+		UserID: listReq.UserID,
+		AppID:  listReq.AppID,
+		Type:   req.GetType(),
+		Limit:  int(listReq.PageSize),
+	}
+	if listReq.Since != 0 {
+		filter.Since = time.Unix(listReq.Since, 0)
+	}
+	if listReq.Until != 0 {
+		filter.Until = time.Unix(listReq.Until, 0)
+	}
+
+	if token := req.GetPageToken(); token != "" {
+		beforeID, err := strconv.ParseInt(token, 10, 64)
+		if err != nil || beforeID <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+
+		filter.BeforeID = beforeID
+	}
+
+	events, next, err := s.audit.Events(ctx, filter)
+	if err != nil {
+		if errors.Is(err, audit.ErrInvalidFilter) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to list audit events: %v", err))
+	}
+
+	resp := &ssov1.ListAuditEventsResponse{}
+	for _, event := range events {
+		resp.Events = append(resp.Events, toProto(event))
+	}
+	if next != 0 {
+		resp.NextPageToken = strconv.FormatInt(next, 10)
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) VerifyAuditLog(ctx context.Context, req *ssov1.VerifyAuditLogRequest) (*ssov1.VerifyAuditLogResponse, error) {
+	result, err := s.audit.Verify(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to verify audit log: %v", err))
+	}
+
+	return &ssov1.VerifyAuditLogResponse{
+		Valid:    result.Valid,
+		Events:   result.Events,
+		BrokenAt: result.BrokenAt,
+	}, nil
+}
+
+func toProto(event model.AuditEvent) *ssov1.AuditEvent {
+	return &ssov1.AuditEvent{
+		Id:        event.ID,
+		Type:      event.Type,
+		ActorId:   event.ActorID,
+		TargetId:  event.TargetID,
+		AppId:     event.AppID,
+		Email:     event.Email,
+		Peer:      event.Peer,
+		UserAgent: event.UserAgent,
+		Outcome:   event.Outcome,
+		Reason:    event.Reason,
+		Detail:    event.Detail,
+		CreatedAt: event.CreatedAt.Unix(),
+		PrevHash:  event.PrevHash,
+		Hash:      event.Hash,
+	}
+}
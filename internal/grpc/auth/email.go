@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/services/auth"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type SendVerificationRequest struct {
+	Email string `validate:"required,email"`
+}
+
+type ConfirmEmailRequest struct {
+	Token string `validate:"required"`
+}
+
+func (s *serverAPI) SendVerification(ctx context.Context, req *ssov1.SendVerificationRequest) (*emptypb.Empty, error) {
+	sendReq := SendVerificationRequest{
+		Email: req.GetEmail(),
+	}
+
+	if err := validate.Struct(sendReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	if err := s.auth.SendVerification(ctx, sendReq.Email); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to send verification: %v", err))
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ConfirmEmail(ctx context.Context, req *ssov1.ConfirmEmailRequest) (*emptypb.Empty, error) {
+	confirmReq := ConfirmEmailRequest{
+		Token: req.GetToken(),
+	}
+
+	if err := validate.Struct(confirmReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	if err := s.auth.ConfirmEmail(ctx, confirmReq.Token); err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid verification token")
+		}
+
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to confirm email: %v", err))
+	}
+
+	return &emptypb.Empty{}, nil
+}
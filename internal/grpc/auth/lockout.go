@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+
+	"github.com/JSONStatham/sso/internal/services/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// retryAfterHeader carries the number of seconds until a locked login may
+// be retried.
+const retryAfterHeader = "retry-after"
+
+// peerAddr returns the IP address of the caller without its port.
+func peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+func lockedStatus(ctx context.Context, err *auth.LockedError) error {
+	retryAfter := strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds())))
+
+	// The header is only a hint, the status is returned either way.
+	_ = grpc.SetHeader(ctx, metadata.Pairs(retryAfterHeader, retryAfter))
+
+	return status.Error(codes.ResourceExhausted, "too many failed login attempts, retry after "+retryAfter+"s")
+}
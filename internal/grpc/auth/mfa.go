@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/grpc/access"
+	"github.com/JSONStatham/sso/internal/services/auth"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// MFA enrollment errors of Login carry an ErrorInfo detail with this
+// reason. Its mfa_token metadata authorizes EnrollTOTP and ConfirmTOTP and
+// completes the login with VerifyMFA.
+const (
+	enrollmentReason   = "MFA_ENROLLMENT_REQUIRED"
+	enrollmentDomain   = "sso"
+	enrollmentTokenKey = "mfa_token"
+)
+
+type VerifyMFARequest struct {
+	MFAToken string `validate:"required"`
+	Code     string `validate:"required"`
+}
+
+// TOTPCodeRequest is sent by a user the interceptor authenticated with the
+// access token in the metadata. The token field of the message is ignored.
+type TOTPCodeRequest struct {
+	Code string `validate:"required"`
+}
+
+func (s *serverAPI) VerifyMFA(ctx context.Context, req *ssov1.VerifyMFARequest) (*ssov1.VerifyMFAResponse, error) {
+	verifyReq := VerifyMFARequest{
+		MFAToken: req.GetMfaToken(),
+		Code:     req.GetCode(),
+	}
+
+	if err := validate.Struct(verifyReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	tokens, err := s.auth.VerifyMFA(ctx, verifyReq.MFAToken, verifyReq.Code, peerAddr(ctx))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, status.Error(codes.Unauthenticated, "invalid mfa token")
+		}
+
+		return nil, mfaError(ctx, err, "failed to verify mfa")
+	}
+
+	return &ssov1.VerifyMFAResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) EnrollTOTP(ctx context.Context, req *ssov1.EnrollTOTPRequest) (*ssov1.EnrollTOTPResponse, error) {
+	// The interceptor authenticated the user.
+	caller, _ := access.CallerFromContext(ctx)
+
+	secret, uri, err := s.auth.EnrollTOTP(ctx, caller.UserID)
+	if err != nil {
+		return nil, mfaError(ctx, err, "failed to enroll totp")
+	}
+
+	return &ssov1.EnrollTOTPResponse{
+		Secret: secret,
+		Uri:    uri,
+	}, nil
+}
+
+func (s *serverAPI) ConfirmTOTP(ctx context.Context, req *ssov1.ConfirmTOTPRequest) (*ssov1.ConfirmTOTPResponse, error) {
+	caller, _ := access.CallerFromContext(ctx)
+
+	confirmReq := TOTPCodeRequest{
+		Code: req.GetCode(),
+	}
+
+	if err := validate.Struct(confirmReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	recoveryCodes, err := s.auth.ConfirmTOTP(ctx, caller.UserID, confirmReq.Code)
+	if err != nil {
+		return nil, mfaError(ctx, err, "failed to confirm totp")
+	}
+
+	return &ssov1.ConfirmTOTPResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *serverAPI) DisableTOTP(ctx context.Context, req *ssov1.DisableTOTPRequest) (*emptypb.Empty, error) {
+	caller, _ := access.CallerFromContext(ctx)
+
+	disableReq := TOTPCodeRequest{
+		Code: req.GetCode(),
+	}
+
+	if err := validate.Struct(disableReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	if err := s.auth.DisableTOTP(ctx, caller.UserID, disableReq.Code, peerAddr(ctx)); err != nil {
+		return nil, mfaError(ctx, err, "failed to disable totp")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func mfaError(ctx context.Context, err error, msg string) error {
+	var lockedErr *auth.LockedError
+
+	switch {
+	case errors.As(err, &lockedErr):
+		return lockedStatus(ctx, lockedErr)
+	case errors.Is(err, auth.ErrInvalidToken):
+		return status.Error(codes.Unauthenticated, "invalid token")
+	case errors.Is(err, auth.ErrInvalidMFACode):
+		return status.Error(codes.Unauthenticated, "invalid mfa code")
+	case errors.Is(err, auth.ErrMFAAlreadyEnabled):
+		return status.Error(codes.AlreadyExists, "mfa already enabled")
+	case errors.Is(err, auth.ErrMFANotEnrolled):
+		return status.Error(codes.FailedPrecondition, "mfa not enrolled")
+	default:
+		return status.Error(codes.Internal, fmt.Sprintf("%s: %v", msg, err))
+	}
+}
+
+// enrollmentStatus returns a FailedPrecondition status with the enrollment
+// token of the user in an ErrorInfo detail.
+func enrollmentStatus(err *auth.EnrollmentRequiredError) error {
+	st := status.New(codes.FailedPrecondition, "app requires mfa, enroll a second factor first")
+
+	detailed, detailsErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   enrollmentReason,
+		Domain:   enrollmentDomain,
+		Metadata: map[string]string{enrollmentTokenKey: err.MFAToken},
+	})
+	if detailsErr != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/grpc/access"
+	"github.com/JSONStatham/sso/internal/services/auth"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type RequestPasswordResetRequest struct {
+	Email string `validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `validate:"required"`
+	Password string `validate:"required"`
+}
+
+// ChangePasswordRequest is sent by a user the interceptor authenticated with
+// the access token in the metadata. The token field of the message is
+// ignored.
+type ChangePasswordRequest struct {
+	RefreshToken    string `validate:"required"`
+	CurrentPassword string `validate:"required"`
+	NewPassword     string `validate:"required"`
+}
+
+func (s *serverAPI) RequestPasswordReset(ctx context.Context, req *ssov1.RequestPasswordResetRequest) (*emptypb.Empty, error) {
+	resetReq := RequestPasswordResetRequest{
+		Email: req.GetEmail(),
+	}
+
+	if err := validate.Struct(resetReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	if err := s.auth.RequestPasswordReset(ctx, resetReq.Email); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to request password reset: %v", err))
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ResetPassword(ctx context.Context, req *ssov1.ResetPasswordRequest) (*emptypb.Empty, error) {
+	resetReq := ResetPasswordRequest{
+		Token:    req.GetToken(),
+		Password: req.GetPassword(),
+	}
+
+	if err := validate.Struct(resetReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	if err := s.auth.ResetPassword(ctx, resetReq.Token, resetReq.Password); err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid reset token")
+		}
+
+		var passwordErr *auth.PasswordError
+		if errors.As(err, &passwordErr) {
+			return nil, passwordStatus(passwordErr, "password")
+		}
+
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to reset password: %v", err))
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ChangePassword(ctx context.Context, req *ssov1.ChangePasswordRequest) (*ssov1.ChangePasswordResponse, error) {
+	caller, _ := access.CallerFromContext(ctx)
+
+	changeReq := ChangePasswordRequest{
+		RefreshToken:    req.GetRefreshToken(),
+		CurrentPassword: req.GetCurrentPassword(),
+		NewPassword:     req.GetNewPassword(),
+	}
+
+	if err := validate.Struct(changeReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	tokens, err := s.auth.ChangePassword(ctx, caller.UserID, changeReq.RefreshToken,
+		changeReq.CurrentPassword, changeReq.NewPassword, peerAddr(ctx))
+	if err != nil {
+		var (
+			lockedErr   *auth.LockedError
+			passwordErr *auth.PasswordError
+		)
+
+		switch {
+		case errors.As(err, &lockedErr):
+			return nil, lockedStatus(ctx, lockedErr)
+		case errors.As(err, &passwordErr):
+			return nil, passwordStatus(passwordErr, "new_password")
+		case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrRefreshTokenReused):
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			return nil, status.Error(codes.PermissionDenied, "invalid current password")
+		case errors.Is(err, auth.ErrSamePassword):
+			return nil, status.Error(codes.InvalidArgument, "new password must differ from the current one")
+		default:
+			return nil, status.Error(codes.Internal, fmt.Sprintf("failed to change password: %v", err))
+		}
+	}
+
+	return &ssov1.ChangePasswordResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+// passwordStatus returns an InvalidArgument status with a BadRequest detail
+// per violated rule of the password policy. The rule is the reason of the
+// field violation.
+func passwordStatus(err *auth.PasswordError, field string) error {
+	st := status.New(codes.InvalidArgument, "password violates the policy")
+
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range err.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: v.Description,
+			Reason:      v.Rule,
+		})
+	}
+
+	detailed, detailsErr := st.WithDetails(badRequest)
+	if detailsErr != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 
 	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/grpc/access"
 	"github.com/JSONStatham/sso/internal/services/auth"
 	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/jwt"
 	"github.com/go-playground/validator/v10"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -19,22 +22,63 @@ var validate = validator.New()
 
 type Auth interface {
 	RegisterUser(ctx context.Context, email, password string) (int64, error)
-	Login(ctx context.Context, email, password string, appID int64) (string, error)
+	Login(ctx context.Context, email, password string, appID int64, peer string) (model.LoginResult, error)
+	VerifyMFA(ctx context.Context, mfaToken, code, peer string) (model.TokenPair, error)
+	EnrollTOTP(ctx context.Context, uid int64) (secret, uri string, err error)
+	ConfirmTOTP(ctx context.Context, uid int64, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, uid int64, code, peer string) error
+	SendVerification(ctx context.Context, email string) error
+	ConfirmEmail(ctx context.Context, token string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, password string) error
+	ChangePassword(ctx context.Context, uid int64, refreshToken, currentPassword, newPassword, peer string) (model.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (model.TokenPair, error)
 	Logout(ctx context.Context, token string) error
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	GrantRole(ctx context.Context, userID, appID int64, role string) error
+	RevokeRole(ctx context.Context, userID, appID int64, role string) error
+	CheckPermission(ctx context.Context, userID, appID int64, permission string) (bool, error)
+	JWKS(ctx context.Context) (jwt.JWKS, error)
+	Introspect(ctx context.Context, caller model.App, token string) (model.TokenInfo, error)
 }
 
+// RegisterRequest only checks that a password is present, the password
+// policy is enforced by the auth service.
 type RegisterRequest struct {
 	Email    string `validate:"required,email"`
-	Password string `validate:"required,min=6"`
+	Password string `validate:"required"`
 }
 
 type LoginRequest struct {
 	Email    string `validate:"required,email"`
-	Password string `validate:"required,min=6"`
+	Password string `validate:"required"`
 	AppID    int64  `validate:"required"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `validate:"required"`
+}
+
+type IntrospectRequest struct {
+	Token string `validate:"required"`
+}
+
+type RoleRequest struct {
+	UserID int64  `validate:"required"`
+	AppID  int64  `validate:"gte=0"`
+	Role   string `validate:"required"`
+}
+
+type CheckPermissionRequest struct {
+	UserID     int64  `validate:"required"`
+	AppID      int64  `validate:"required"`
+	Permission string `validate:"required"`
+}
+
+type LogoutRequest struct {
+	Token string `validate:"required"`
+}
+
 type serverAPI struct {
 	ssov1.UnimplementedAuthServer
 	auth Auth
@@ -61,11 +105,16 @@ func (s *serverAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*
 			return nil, status.Error(codes.AlreadyExists, "user already exists")
 		}
 
+		var passwordErr *auth.PasswordError
+		if errors.As(err, &passwordErr) {
+			return nil, passwordStatus(passwordErr, "password")
+		}
+
 		return nil, status.Error(codes.Internal, "failed to register user")
 	}
 
 	return &ssov1.RegisterResponse{
-		UserId: 1,
+		UserId: userId,
 	}, nil
 }
 
@@ -81,22 +130,99 @@ func (s *serverAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.
 		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
 	}
 
-	token, err := s.auth.Login(ctx, loginReq.Email, loginReq.Password, loginReq.AppID)
+	result, err := s.auth.Login(ctx, loginReq.Email, loginReq.Password, loginReq.AppID, peerAddr(ctx))
 	if err != nil {
 		if errors.Is(err, auth.ErrInvalidCredentials) {
 			return nil, status.Error(codes.NotFound, "user not found")
 		}
 
+		if errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, status.Error(codes.NotFound, "app not found")
+		}
+
+		var enrollErr *auth.EnrollmentRequiredError
+		if errors.As(err, &enrollErr) {
+			return nil, enrollmentStatus(enrollErr)
+		}
+
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+
+		if errors.Is(err, auth.ErrUnauthorizedClient) {
+			return nil, status.Error(codes.PermissionDenied, "app does not allow password login")
+		}
+
+		var lockedErr *auth.LockedError
+		if errors.As(err, &lockedErr) {
+			return nil, lockedStatus(ctx, lockedErr)
+		}
+
 		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to login: %v", err))
 	}
 
+	if result.MFAToken != "" {
+		return &ssov1.LogingResponse{
+			MfaRequired: true,
+			MfaToken:    result.MFAToken,
+		}, nil
+	}
+
 	return &ssov1.LogingResponse{
-		Token: token,
+		Token:        result.Tokens.AccessToken,
+		RefreshToken: result.Tokens.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) Refresh(ctx context.Context, req *ssov1.RefreshRequest) (*ssov1.RefreshResponse, error) {
+	refreshReq := RefreshRequest{
+		RefreshToken: req.GetRefreshToken(),
+	}
+
+	if err := validate.Struct(refreshReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	tokens, err := s.auth.Refresh(ctx, refreshReq.RefreshToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrRefreshTokenReused) ||
+			errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+
+		if errors.Is(err, auth.ErrUnauthorizedClient) {
+			return nil, status.Error(codes.PermissionDenied, "app does not allow refresh tokens")
+		}
+
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to refresh token: %v", err))
+	}
+
+	return &ssov1.RefreshResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 	}, nil
 }
 
 func (s *serverAPI) Logout(ctx context.Context, req *ssov1.LogoutRequest) (*emptypb.Empty, error) {
-	return nil, nil
+	logoutReq := LogoutRequest{
+		Token: req.GetToken(),
+	}
+
+	if err := validate.Struct(logoutReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	if err := s.auth.Logout(ctx, logoutReq.Token); err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to logout: %v", err))
+	}
+
+	return &emptypb.Empty{}, nil
 }
 
 func (s *serverAPI) IsAdmin(ctx context.Context, req *ssov1.IsAdminRequest) (*ssov1.IsAdminResponse, error) {
@@ -104,6 +230,19 @@ func (s *serverAPI) IsAdmin(ctx context.Context, req *ssov1.IsAdminRequest) (*ss
 		return nil, status.Error(codes.InvalidArgument, "user id is required")
 	}
 
+	// Users may ask about themselves, only admins about others.
+	caller, _ := access.CallerFromContext(ctx)
+	if caller.UserID != req.GetUserId() {
+		callerIsAdmin, err := s.auth.IsAdmin(ctx, caller.UserID)
+		if err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("failed to check if caller is admin: %v", err))
+		}
+
+		if !callerIsAdmin {
+			return nil, status.Error(codes.PermissionDenied, "only admins may ask about other users")
+		}
+	}
+
 	isAdmin, err := s.auth.IsAdmin(ctx, req.GetUserId())
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
@@ -117,3 +256,136 @@ func (s *serverAPI) IsAdmin(ctx context.Context, req *ssov1.IsAdminRequest) (*ss
 		IsAdmin: isAdmin,
 	}, nil
 }
+
+func (s *serverAPI) JWKS(ctx context.Context, req *ssov1.JWKSRequest) (*ssov1.JWKSResponse, error) {
+	set, err := s.auth.JWKS(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to get jwks: %v", err))
+	}
+
+	keys := make([]*ssov1.JWK, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		keys = append(keys, &ssov1.JWK{
+			Kty: key.Kty,
+			Kid: key.Kid,
+			Use: key.Use,
+			Alg: key.Alg,
+			N:   key.N,
+			E:   key.E,
+			Crv: key.Crv,
+			X:   key.X,
+			Y:   key.Y,
+		})
+	}
+
+	return &ssov1.JWKSResponse{Keys: keys}, nil
+}
+
+func (s *serverAPI) Introspect(ctx context.Context, req *ssov1.IntrospectRequest) (*ssov1.IntrospectResponse, error) {
+	// The interceptor authenticated the calling app.
+	caller, _ := access.CallerFromContext(ctx)
+
+	introspectReq := IntrospectRequest{
+		Token: req.GetToken(),
+	}
+
+	if err := validate.Struct(introspectReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	info, err := s.auth.Introspect(ctx, caller.App, introspectReq.Token)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to introspect token: %v", err))
+	}
+
+	if !info.Active {
+		return &ssov1.IntrospectResponse{Active: false}, nil
+	}
+
+	return &ssov1.IntrospectResponse{
+		Active: true,
+		Uid:    info.UID,
+		AppId:  info.AppID,
+		Scopes: info.Scopes,
+		Exp:    info.ExpiresAt.Unix(),
+	}, nil
+}
+
+func (s *serverAPI) GrantRole(ctx context.Context, req *ssov1.GrantRoleRequest) (*emptypb.Empty, error) {
+	roleReq := RoleRequest{
+		UserID: req.GetUserId(),
+		AppID:  req.GetAppId(),
+		Role:   req.GetRole(),
+	}
+
+	if err := validate.Struct(roleReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	if err := s.auth.GrantRole(ctx, roleReq.UserID, roleReq.AppID, roleReq.Role); err != nil {
+		return nil, roleError(err, "failed to grant role")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) RevokeRole(ctx context.Context, req *ssov1.RevokeRoleRequest) (*emptypb.Empty, error) {
+	roleReq := RoleRequest{
+		UserID: req.GetUserId(),
+		AppID:  req.GetAppId(),
+		Role:   req.GetRole(),
+	}
+
+	if err := validate.Struct(roleReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	if err := s.auth.RevokeRole(ctx, roleReq.UserID, roleReq.AppID, roleReq.Role); err != nil {
+		return nil, roleError(err, "failed to revoke role")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) CheckPermission(ctx context.Context, req *ssov1.CheckPermissionRequest) (*ssov1.CheckPermissionResponse, error) {
+	checkReq := CheckPermissionRequest{
+		UserID:     req.GetUserId(),
+		AppID:      req.GetAppId(),
+		Permission: req.GetPermission(),
+	}
+
+	if err := validate.Struct(checkReq); err != nil {
+		validationErr := err.(validator.ValidationErrors)
+		return nil, status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+
+	// Apps may only check permissions in their own app.
+	if caller, _ := access.CallerFromContext(ctx); caller.AppID != checkReq.AppID {
+		return nil, status.Error(codes.PermissionDenied, "app may only check its own permissions")
+	}
+
+	allowed, err := s.auth.CheckPermission(ctx, checkReq.UserID, checkReq.AppID, checkReq.Permission)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to check permission: %v", err))
+	}
+
+	return &ssov1.CheckPermissionResponse{
+		Allowed: allowed,
+	}, nil
+}
+
+func roleError(err error, msg string) error {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, auth.ErrInvalidAppID):
+		return status.Error(codes.NotFound, "app not found")
+	case errors.Is(err, auth.ErrRoleNotFound):
+		return status.Error(codes.NotFound, "role not found")
+	default:
+		return status.Error(codes.Internal, fmt.Sprintf("%s: %v", msg, err))
+	}
+}
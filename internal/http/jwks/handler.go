@@ -0,0 +1,38 @@
+package jwks
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/JSONStatham/sso/internal/utils/jwt"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+const Path = "/.well-known/jwks.json"
+
+type Provider interface {
+	JWKS(ctx context.Context) (jwt.JWKS, error)
+}
+
+// Register serves the public signing keys as a JWKS document.
+func Register(mux *http.ServeMux, log *slog.Logger, provider Provider) {
+	mux.HandleFunc("GET "+Path, func(w http.ResponseWriter, r *http.Request) {
+		const op = "http.jwks"
+
+		set, err := provider.JWKS(r.Context())
+		if err != nil {
+			log.With(slog.String("op", op)).Error("failed to get jwks", sl.Err(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+
+		if err := json.NewEncoder(w).Encode(set); err != nil {
+			log.With(slog.String("op", op)).Error("failed to write jwks", sl.Err(err))
+		}
+	})
+}
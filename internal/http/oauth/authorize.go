@@ -0,0 +1,268 @@
+package oauth
+
+import (
+	_ "embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/services/auth"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+// codeChallengeS256 is the only PKCE method accepted, "plain" does not
+// protect against a leaked authorization request.
+const codeChallengeS256 = "S256"
+
+//go:embed authorize.html
+var authorizeHTML string
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(authorizeHTML))
+
+// authorizeParams are the parameters of an authorization request. They are
+// carried through the login form in hidden fields.
+type authorizeParams struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+func parseAuthorizeParams(values url.Values) authorizeParams {
+	return authorizeParams{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Nonce:               values.Get("nonce"),
+	}
+}
+
+type authorizePage struct {
+	Action    string
+	CSRFToken string
+	AppName   string
+	Request   authorizeParams
+	Email     string
+	MFAToken  string
+	Error     string
+	Fatal     string
+}
+
+// authorizeForm shows the login form for a valid authorization request.
+func (h *handler) authorizeForm(w http.ResponseWriter, r *http.Request) {
+	const op = "http.oauth.authorizeForm"
+
+	params := parseAuthorizeParams(r.URL.Query())
+
+	app, _, ok := h.checkAuthorizeRequest(w, r, params)
+	if !ok {
+		return
+	}
+
+	token, err := newCSRFToken(w, r)
+	if err != nil {
+		h.log.With(slog.String("op", op)).Error("failed to create csrf token", sl.Err(err))
+		h.render(w, http.StatusInternalServerError, authorizePage{Fatal: "Something went wrong, try again later."})
+		return
+	}
+
+	h.render(w, http.StatusOK, authorizePage{CSRFToken: token, AppName: app.Name, Request: params})
+}
+
+// authorize logs the user in with the submitted form and redirects back to
+// the client with an authorization code.
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+	const op = "http.oauth.authorize"
+
+	log := h.log.With(slog.String("op", op))
+
+	if err := r.ParseForm(); err != nil {
+		h.render(w, http.StatusBadRequest, authorizePage{Fatal: "The request is malformed."})
+		return
+	}
+
+	// Only the login form shown by authorizeForm may be submitted, other
+	// sites must not sign users in without their knowing.
+	token, ok := csrfToken(r)
+	if !ok {
+		log.Warn("csrf token missing or invalid", slog.String("peer", peerAddr(r)))
+		h.render(w, http.StatusForbidden, authorizePage{Fatal: "The sign in form has expired, go back to the app and try again."})
+		return
+	}
+
+	params := parseAuthorizeParams(r.PostForm)
+
+	app, req, ok := h.checkAuthorizeRequest(w, r, params)
+	if !ok {
+		return
+	}
+
+	page := authorizePage{CSRFToken: token, AppName: app.Name, Request: params, Email: r.PostForm.Get("email")}
+
+	var (
+		result model.AuthorizationResult
+		err    error
+	)
+	if mfaToken := r.PostForm.Get("mfa_token"); mfaToken != "" {
+		result, err = h.service.AuthorizeMFA(r.Context(), req, mfaToken, r.PostForm.Get("code"), peerAddr(r))
+		page.MFAToken = mfaToken
+	} else {
+		result, err = h.service.Authorize(r.Context(), req, page.Email, r.PostForm.Get("password"), peerAddr(r))
+	}
+
+	var locked *auth.LockedError
+
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		page.Error = "Invalid email or password."
+		h.render(w, http.StatusUnauthorized, page)
+		return
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		page.Error = "Too many failed attempts, try again later."
+		h.render(w, http.StatusTooManyRequests, page)
+		return
+	case errors.Is(err, auth.ErrInvalidMFACode):
+		page.Error = "Invalid authentication code."
+		h.render(w, http.StatusUnauthorized, page)
+		return
+	case errors.Is(err, auth.ErrInvalidToken):
+		page.MFAToken = ""
+		page.Error = "Your sign in has expired, sign in again."
+		h.render(w, http.StatusUnauthorized, page)
+		return
+	case errors.Is(err, auth.ErrEmailNotVerified):
+		h.redirectError(w, r, params, errAccessDenied, "email address is not verified")
+		return
+	case errors.Is(err, auth.ErrMFAEnrollmentRequired):
+		h.redirectError(w, r, params, errAccessDenied, "two-factor authentication is not set up")
+		return
+	default:
+		log.Error("failed to authorize", sl.Err(err))
+		h.redirectError(w, r, params, errServerError, "")
+		return
+	}
+
+	if result.MFAToken != "" {
+		page.MFAToken = result.MFAToken
+		h.render(w, http.StatusOK, page)
+		return
+	}
+
+	h.redirect(w, r, params.RedirectURI, url.Values{"code": {result.Code}, "state": {params.State}})
+}
+
+// checkAuthorizeRequest validates the authorization request. Errors of the
+// client or redirect URI are shown to the user, since the redirect URI
+// cannot be trusted, every other error is redirected back to the client.
+func (h *handler) checkAuthorizeRequest(w http.ResponseWriter, r *http.Request, params authorizeParams) (model.App, model.AuthorizationRequest, bool) {
+	const op = "http.oauth.checkAuthorizeRequest"
+
+	clientID, err := strconv.ParseInt(params.ClientID, 10, 64)
+	if err != nil || clientID <= 0 {
+		h.render(w, http.StatusBadRequest, authorizePage{Fatal: "The client_id is invalid."})
+		return model.App{}, model.AuthorizationRequest{}, false
+	}
+
+	req := model.AuthorizationRequest{
+		ClientID:      clientID,
+		RedirectURI:   params.RedirectURI,
+		Scope:         params.Scope,
+		CodeChallenge: params.CodeChallenge,
+		Nonce:         params.Nonce,
+	}
+
+	app, err := h.service.AuthorizationClient(r.Context(), req)
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrInvalidAppID):
+		h.render(w, http.StatusBadRequest, authorizePage{Fatal: "The client_id is invalid."})
+		return model.App{}, model.AuthorizationRequest{}, false
+	case errors.Is(err, auth.ErrInvalidRedirectURI):
+		h.render(w, http.StatusBadRequest, authorizePage{Fatal: "The redirect_uri is not registered for the client."})
+		return model.App{}, model.AuthorizationRequest{}, false
+	case errors.Is(err, auth.ErrUnauthorizedClient):
+		h.redirectError(w, r, params, errUnauthorizedClient, "client is not allowed to use the authorization code grant")
+		return model.App{}, model.AuthorizationRequest{}, false
+	case errors.Is(err, auth.ErrPKCERequired):
+		h.redirectError(w, r, params, errInvalidRequest, "code_challenge is required")
+		return model.App{}, model.AuthorizationRequest{}, false
+	default:
+		h.log.With(slog.String("op", op)).Error("failed to check client", sl.Err(err))
+		h.render(w, http.StatusInternalServerError, authorizePage{Fatal: "Something went wrong, try again later."})
+		return model.App{}, model.AuthorizationRequest{}, false
+	}
+
+	if params.ResponseType != "code" {
+		h.redirectError(w, r, params, errUnsupportedResponseType, "only the code response type is supported")
+		return model.App{}, model.AuthorizationRequest{}, false
+	}
+
+	if params.CodeChallenge != "" && params.CodeChallengeMethod != codeChallengeS256 {
+		h.redirectError(w, r, params, errInvalidRequest, "code_challenge_method must be S256")
+		return model.App{}, model.AuthorizationRequest{}, false
+	}
+
+	return app, req, true
+}
+
+func (h *handler) redirectError(w http.ResponseWriter, r *http.Request, params authorizeParams, code, description string) {
+	values := url.Values{"error": {code}}
+	if description != "" {
+		values.Set("error_description", description)
+	}
+	if params.State != "" {
+		values.Set("state", params.State)
+	}
+
+	h.redirect(w, r, params.RedirectURI, values)
+}
+
+// redirect sends the user back to the registered redirect URI with the
+// values added to its query.
+func (h *handler) redirect(w http.ResponseWriter, r *http.Request, redirectURI string, values url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		h.render(w, http.StatusBadRequest, authorizePage{Fatal: "The redirect_uri is invalid."})
+		return
+	}
+
+	query := u.Query()
+	for key, value := range values {
+		if value[0] != "" {
+			query[key] = value
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (h *handler) render(w http.ResponseWriter, status int, page authorizePage) {
+	page.Action = AuthorizePath
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// The login form must not be framed by another site.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	if err := authorizeTemplate.Execute(w, page); err != nil {
+		h.log.Error("failed to render authorize page", sl.Err(err))
+	}
+}
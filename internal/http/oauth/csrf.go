@@ -0,0 +1,53 @@
+package oauth
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/JSONStatham/sso/internal/utils/opaque"
+)
+
+const (
+	// csrfCookie holds the token of the authorize session of the browser.
+	csrfCookie = "sso_authorize_csrf"
+	// csrfField carries the token in the login form.
+	csrfField = "csrf_token"
+)
+
+// newCSRFToken starts an authorize session for a newly shown login form.
+// The token is sent in a cookie other sites cannot read and has to come
+// back in the form as well.
+func newCSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	token, _, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     AuthorizePath,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// The form is posted from the page of the service itself.
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token, nil
+}
+
+// csrfToken returns the token of the authorize session if the submitted
+// form carries it.
+func csrfToken(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+
+	submitted := r.PostForm.Get(csrfField)
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(submitted)) != 1 {
+		return "", false
+	}
+
+	return cookie.Value, true
+}
@@ -0,0 +1,90 @@
+// Package oauth serves the OAuth 2.0 authorization code flow with PKCE,
+// the refresh token and client credentials grants and token revocation on
+// top of the auth service.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+const (
+	AuthorizePath = "/authorize"
+	TokenPath     = "/token"
+	RevokePath    = "/revoke"
+)
+
+// Error codes of RFC 6749 section 4.1.2.1 and 5.2.
+const (
+	errInvalidRequest          = "invalid_request"
+	errInvalidClient           = "invalid_client"
+	errInvalidGrant            = "invalid_grant"
+	errUnauthorizedClient      = "unauthorized_client"
+	errUnsupportedGrantType    = "unsupported_grant_type"
+	errUnsupportedResponseType = "unsupported_response_type"
+	errAccessDenied            = "access_denied"
+	errServerError             = "server_error"
+)
+
+type Service interface {
+	AuthorizationClient(ctx context.Context, req model.AuthorizationRequest) (model.App, error)
+	Authorize(ctx context.Context, req model.AuthorizationRequest, email, password, peer string) (model.AuthorizationResult, error)
+	AuthorizeMFA(ctx context.Context, req model.AuthorizationRequest, mfaToken, code, peer string) (model.AuthorizationResult, error)
+	AuthenticateClient(ctx context.Context, clientID int64, secret string) (model.App, error)
+	ExchangeCode(ctx context.Context, client model.App, code, redirectURI, verifier string) (model.TokenPair, error)
+	RefreshClient(ctx context.Context, client model.App, refreshToken string) (model.TokenPair, error)
+	ClientCredentials(ctx context.Context, client model.App, scope string) (model.TokenPair, error)
+	RevokeClientToken(ctx context.Context, client model.App, token string) error
+}
+
+type handler struct {
+	log     *slog.Logger
+	service Service
+}
+
+// Register serves the authorization, token and revocation endpoints.
+func Register(mux *http.ServeMux, log *slog.Logger, service Service) {
+	h := &handler{log: log, service: service}
+
+	mux.HandleFunc("GET "+AuthorizePath, h.authorizeForm)
+	mux.HandleFunc("POST "+AuthorizePath, h.authorize)
+	mux.HandleFunc("POST "+TokenPath, h.token)
+	mux.HandleFunc("POST "+RevokePath, h.revoke)
+}
+
+// errorResponse is the error body of the token and revocation endpoints.
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func (h *handler) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		h.log.Error("failed to write response", sl.Err(err))
+	}
+}
+
+func (h *handler) writeError(w http.ResponseWriter, status int, code, description string) {
+	h.writeJSON(w, status, errorResponse{Error: code, ErrorDescription: description})
+}
+
+// peerAddr returns the IP address of the caller without its port.
+func peerAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,178 @@
+package oauth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/services/auth"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// token issues tokens for the authorization_code, refresh_token and
+// client_credentials grants as described in RFC 6749 section 4 and 6.
+func (h *handler) token(w http.ResponseWriter, r *http.Request) {
+	const op = "http.oauth.token"
+
+	log := h.log.With(slog.String("op", op))
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		tokens model.TokenPair
+		scope  string
+		err    error
+	)
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case model.GrantAuthorizationCode:
+		code := r.PostForm.Get("code")
+		if code == "" {
+			h.writeError(w, http.StatusBadRequest, errInvalidRequest, "code is required")
+			return
+		}
+
+		tokens, err = h.service.ExchangeCode(r.Context(), client, code,
+			r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	case model.GrantRefreshToken:
+		refreshToken := r.PostForm.Get("refresh_token")
+		if refreshToken == "" {
+			h.writeError(w, http.StatusBadRequest, errInvalidRequest, "refresh_token is required")
+			return
+		}
+
+		tokens, err = h.service.RefreshClient(r.Context(), client, refreshToken)
+	case model.GrantClientCredentials:
+		scope = r.PostForm.Get("scope")
+		tokens, err = h.service.ClientCredentials(r.Context(), client, scope)
+	case "":
+		h.writeError(w, http.StatusBadRequest, errInvalidRequest, "grant_type is required")
+		return
+	default:
+		h.writeError(w, http.StatusBadRequest, errUnsupportedGrantType, "unsupported grant type "+strconv.Quote(grantType))
+		return
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrInvalidGrant), errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrRefreshTokenReused):
+		h.writeError(w, http.StatusBadRequest, errInvalidGrant, "grant is invalid, expired or was issued to another client")
+		return
+	case errors.Is(err, auth.ErrUnauthorizedClient):
+		h.writeError(w, http.StatusBadRequest, errUnauthorizedClient, "client is not allowed to use the grant")
+		return
+	default:
+		log.Error("failed to issue tokens", sl.Err(err))
+		h.writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		IDToken:      tokens.IDToken,
+		Scope:        scope,
+	})
+}
+
+// revoke revokes an access or refresh token of the client as described in
+// RFC 7009. It succeeds for unknown tokens as well.
+func (h *handler) revoke(w http.ResponseWriter, r *http.Request) {
+	const op = "http.oauth.revoke"
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		h.writeError(w, http.StatusBadRequest, errInvalidRequest, "token is required")
+		return
+	}
+
+	if err := h.service.RevokeClientToken(r.Context(), client, token); err != nil {
+		h.log.With(slog.String("op", op)).Error("failed to revoke token", sl.Err(err))
+		h.writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticateClient parses the form and authenticates the client with
+// HTTP Basic authentication or the client_id and client_secret form
+// parameters. Public clients only send their client_id.
+func (h *handler) authenticateClient(w http.ResponseWriter, r *http.Request) (model.App, bool) {
+	const op = "http.oauth.authenticateClient"
+
+	if err := r.ParseForm(); err != nil {
+		h.writeError(w, http.StatusBadRequest, errInvalidRequest, "malformed form body")
+		return model.App{}, false
+	}
+
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		if r.PostForm.Has("client_secret") {
+			h.writeError(w, http.StatusBadRequest, errInvalidRequest, "multiple client authentication methods")
+			return model.App{}, false
+		}
+
+		// RFC 6749 section 2.3.1 form-encodes the credentials.
+		var errID, errSecret error
+		clientID, errID = url.QueryUnescape(clientID)
+		secret, errSecret = url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			h.invalidClient(w, basic)
+			return model.App{}, false
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	id, err := strconv.ParseInt(clientID, 10, 64)
+	if err != nil || id <= 0 {
+		h.invalidClient(w, basic)
+		return model.App{}, false
+	}
+
+	client, err := h.service.AuthenticateClient(r.Context(), id, secret)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAppCredentials) {
+			h.invalidClient(w, basic)
+			return model.App{}, false
+		}
+
+		h.log.With(slog.String("op", op)).Error("failed to authenticate client", sl.Err(err))
+		h.writeError(w, http.StatusInternalServerError, errServerError, "")
+		return model.App{}, false
+	}
+
+	return client, true
+}
+
+func (h *handler) invalidClient(w http.ResponseWriter, basic bool) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+	}
+
+	h.writeError(w, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+}
@@ -0,0 +1,154 @@
+// Package oidc serves the OpenID Connect discovery document and the
+// userinfo endpoint.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/http/jwks"
+	"github.com/JSONStatham/sso/internal/http/oauth"
+	"github.com/JSONStatham/sso/internal/services/auth"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	UserInfoPath  = "/userinfo"
+)
+
+type Service interface {
+	jwks.Provider
+	UserInfo(ctx context.Context, accessToken string) (model.UserInfo, error)
+}
+
+type handler struct {
+	log     *slog.Logger
+	issuer  string
+	service Service
+}
+
+// Register serves discovery for the issuer and the userinfo endpoint.
+func Register(mux *http.ServeMux, log *slog.Logger, issuer string, service Service) {
+	h := &handler{log: log, issuer: strings.TrimSuffix(issuer, "/"), service: service}
+
+	mux.HandleFunc("GET "+DiscoveryPath, h.discovery)
+	mux.HandleFunc("GET "+UserInfoPath, h.userInfo)
+	mux.HandleFunc("POST "+UserInfoPath, h.userInfo)
+}
+
+// configuration is the provider metadata of OpenID Connect Discovery 1.0.
+type configuration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+func (h *handler) discovery(w http.ResponseWriter, r *http.Request) {
+	const op = "http.oidc.discovery"
+
+	log := h.log.With(slog.String("op", op))
+
+	// The signing algorithms follow the keys in the key ring.
+	set, err := h.service.JWKS(r.Context())
+	if err != nil {
+		log.Error("failed to get jwks", sl.Err(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	algs := []string{}
+	for _, key := range set.Keys {
+		if !slices.Contains(algs, key.Alg) {
+			algs = append(algs, key.Alg)
+		}
+	}
+
+	config := configuration{
+		Issuer:                            h.issuer,
+		AuthorizationEndpoint:             h.issuer + oauth.AuthorizePath,
+		TokenEndpoint:                     h.issuer + oauth.TokenPath,
+		UserInfoEndpoint:                  h.issuer + UserInfoPath,
+		RevocationEndpoint:                h.issuer + oauth.RevokePath,
+		JWKSURI:                           h.issuer + jwks.Path,
+		ScopesSupported:                   []string{auth.ScopeOpenID, "email"},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{model.GrantAuthorizationCode, model.GrantRefreshToken, model.GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algs,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified",
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if err := json.NewEncoder(w).Encode(config); err != nil {
+		log.Error("failed to write configuration", sl.Err(err))
+	}
+}
+
+type userInfoResponse struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// userInfo returns the claims of the user the bearer token was issued to.
+func (h *handler) userInfo(w http.ResponseWriter, r *http.Request) {
+	const op = "http.oidc.userInfo"
+
+	log := h.log.With(slog.String("op", op))
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	info, err := h.service.UserInfo(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sso", error="invalid_token"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		log.Error("failed to get user info", sl.Err(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	err = json.NewEncoder(w).Encode(userInfoResponse{
+		Subject:       info.Subject,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+	})
+	if err != nil {
+		log.Error("failed to write user info", sl.Err(err))
+	}
+}
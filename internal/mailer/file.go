@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// File writes every message into its own file in a directory instead of
+// delivering it. It is meant for development and tests.
+type File struct {
+	dir  string
+	from string
+}
+
+func NewFile(dir, from string) (*File, error) {
+	const op = "mailer.NewFile"
+
+	if dir == "" {
+		return nil, fmt.Errorf("%s: directory is required", op)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &File{dir: dir, from: from}, nil
+}
+
+func (m *File) Send(_ context.Context, msg Message) error {
+	const op = "mailer.File.Send"
+
+	now := time.Now()
+	name := strconv.FormatInt(now.UnixNano(), 10) + ".eml"
+
+	if err := os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg, now), 0o600); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
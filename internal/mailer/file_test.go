@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+
+	m, err := NewFile(dir, "sso@example.com")
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), Message{
+		To:      "user@example.com",
+		Subject: "Hello",
+		Body:    "token=abc\n",
+	})
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	assert.Equal(t, "sso@example.com", msg.Header.Get("From"))
+	assert.Equal(t, "user@example.com", msg.Header.Get("To"))
+	assert.Equal(t, "Hello", msg.Header.Get("Subject"))
+
+	body, err := io.ReadAll(msg.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "token=abc\n", string(body))
+}
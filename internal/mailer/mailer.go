@@ -0,0 +1,56 @@
+// Package mailer delivers the emails sent by the auth service.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/config"
+)
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// New returns the mailer selected by cfg.Driver.
+func New(log *slog.Logger, cfg config.MailerConfig) (Mailer, error) {
+	const op = "mailer.New"
+
+	switch cfg.Driver {
+	case config.MailerSMTP:
+		return NewSMTP(cfg.SMTP, cfg.From), nil
+	case config.MailerFile:
+		return NewFile(cfg.Dir, cfg.From)
+	case config.MailerLog:
+		return NewLog(log), nil
+	default:
+		return nil, fmt.Errorf("%s: unknown mailer driver %q", op, cfg.Driver)
+	}
+}
+
+// format renders the message with the headers every mail server expects.
+func format(from string, msg Message, now time.Time) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+
+	return b.Bytes()
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+)
+
+// Auditor records audit events.
+type Auditor interface {
+	Record(ctx context.Context, event model.AuditEvent)
+}
+
+// Auditor counts logins, registrations and lockouts from the audit events
+// recorded by the auth service before passing them on to next.
+func (m *Metrics) Auditor(next Auditor) Auditor {
+	return &auditor{metrics: m, next: next}
+}
+
+type auditor struct {
+	metrics *Metrics
+	next    Auditor
+}
+
+func (a *auditor) Record(ctx context.Context, event model.AuditEvent) {
+	switch event.Type {
+	case model.AuditLogin:
+		a.metrics.logins.WithLabelValues(appLabel(event.AppID), event.Outcome, event.Reason).Inc()
+	case model.AuditUserRegistered:
+		a.metrics.registrations.WithLabelValues(event.Outcome, event.Reason).Inc()
+	case model.AuditLockout:
+		a.metrics.lockouts.WithLabelValues(event.Detail).Inc()
+	}
+
+	a.next.Record(ctx, event)
+}
+
+// appLabel bounds the app label to registered apps. Audit events carry no
+// app if the one requested does not exist.
+func appLabel(appID int64) string {
+	if appID == 0 {
+		return "unknown"
+	}
+
+	return strconv.FormatInt(appID, 10)
+}
+
+// PasswordHasher hashes and verifies passwords.
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	Compare(hash []byte, password string) error
+	NeedsRehash(hash []byte) bool
+}
+
+// Hasher observes how long next takes to hash and compare passwords.
+// algorithm is the one new hashes are created with.
+func (m *Metrics) Hasher(next PasswordHasher, algorithm string) PasswordHasher {
+	return &hasher{metrics: m, next: next, algorithm: algorithm}
+}
+
+type hasher struct {
+	metrics   *Metrics
+	next      PasswordHasher
+	algorithm string
+}
+
+func (h *hasher) Hash(password string) ([]byte, error) {
+	defer h.observe("hash", h.algorithm, time.Now())
+
+	return h.next.Hash(password)
+}
+
+func (h *hasher) Compare(hash []byte, password string) error {
+	defer h.observe("compare", hashAlgorithm(hash), time.Now())
+
+	return h.next.Compare(hash, password)
+}
+
+func (h *hasher) NeedsRehash(hash []byte) bool {
+	return h.next.NeedsRehash(hash)
+}
+
+func (h *hasher) observe(operation, algorithm string, start time.Time) {
+	h.metrics.hashDuration.WithLabelValues(operation, algorithm).Observe(time.Since(start).Seconds())
+}
+
+// hashAlgorithm returns the algorithm of a PHC string or bcrypt hash, e.g.
+// "argon2id" for "$argon2id$v=19$...".
+func hashAlgorithm(hash []byte) string {
+	if len(hash) > 2 && string(hash[:2]) == "$2" {
+		return "bcrypt"
+	}
+
+	if len(hash) > 1 && hash[0] == '$' {
+		for i := 1; i < len(hash); i++ {
+			if hash[i] == '$' {
+				return string(hash[1:i])
+			}
+		}
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DBStatter reports the stats of a connection pool, e.g. *sql.DB.
+type DBStatter interface {
+	Stats() sql.DBStats
+}
+
+// RegisterDB exports the connection pool stats of the storage. driver
+// labels the metrics, e.g. "sqlite".
+func (m *Metrics) RegisterDB(driver string, db DBStatter) {
+	m.registry.MustRegister(newDBCollector(driver, db))
+}
+
+type dbCollector struct {
+	db DBStatter
+
+	maxOpen      *prometheus.Desc
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+	closed       *prometheus.Desc
+}
+
+func newDBCollector(driver string, db DBStatter) *dbCollector {
+	labels := prometheus.Labels{"driver": driver}
+	desc := func(name, help string, variableLabels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db", name), help, variableLabels, labels)
+	}
+
+	return &dbCollector{
+		db:           db,
+		maxOpen:      desc("max_open_connections", "Maximum number of open connections, zero is unlimited."),
+		open:         desc("open_connections", "Number of established connections, in use or idle."),
+		inUse:        desc("in_use_connections", "Number of connections in use."),
+		idle:         desc("idle_connections", "Number of idle connections."),
+		waitCount:    desc("wait_count_total", "Number of times a connection had to be waited for."),
+		waitDuration: desc("wait_duration_seconds_total", "Time spent waiting for a connection."),
+		closed:       desc("closed_connections_total", "Number of connections closed by reason.", "reason"),
+	}
+}
+
+func (c *dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{c.maxOpen, c.open, c.inUse, c.idle, c.waitCount, c.waitDuration, c.closed} {
+		ch <- desc
+	}
+}
+
+func (c *dbCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxIdleClosed), "max_idle")
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed), "max_idle_time")
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), "max_lifetime")
+}
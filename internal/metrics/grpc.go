@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor observes the duration and status code of unary
+// calls. It has to come first in the chain to see calls rejected by the
+// other interceptors.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+		m.observeRPC(info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor observes the duration and status code of
+// streaming calls.
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+
+		err := handler(srv, ss)
+		m.observeRPC(info.FullMethod, start, err)
+
+		return err
+	}
+}
+
+func (m *Metrics) observeRPC(method string, start time.Time, err error) {
+	code := status.Code(err).String()
+
+	m.rpcDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+	m.rpcs.WithLabelValues(method, code).Inc()
+}
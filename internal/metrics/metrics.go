@@ -0,0 +1,89 @@
+// Package metrics collects the Prometheus metrics of the service: gRPC
+// calls, auth outcomes, password hashing and the storage connection pool.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "sso"
+
+// Metrics holds the collectors of one instance of the service. Every
+// instance has its own registry, so several can run in one process.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	rpcDuration   *prometheus.HistogramVec
+	rpcs          *prometheus.CounterVec
+	logins        *prometheus.CounterVec
+	registrations *prometheus.CounterVec
+	lockouts      *prometheus.CounterVec
+	hashDuration  *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of gRPC calls by method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+		rpcs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "requests_total",
+			Help:      "Number of gRPC calls by method and status code.",
+		}, []string{"method", "code"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "logins_total",
+			Help:      "Number of password logins by app, outcome and failure reason.",
+		}, []string{"app_id", "outcome", "reason"}),
+		registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "registrations_total",
+			Help:      "Number of user registrations by outcome and failure reason.",
+		}, []string{"outcome", "reason"}),
+		lockouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "lockouts_total",
+			Help:      "Number of logins locked after too many failures, by email or source address.",
+		}, []string{"scope"}),
+		hashDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "password",
+			Name:      "hash_duration_seconds",
+			Help:      "Duration of hashing and comparing passwords by algorithm.",
+			// Password hashing is slow on purpose.
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation", "algorithm"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.rpcDuration,
+		m.rpcs,
+		m.logins,
+		m.registrations,
+		m.lockouts,
+		m.hashDuration,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type discardAuditor struct {
+	events int
+}
+
+func (a *discardAuditor) Record(context.Context, model.AuditEvent) {
+	a.events++
+}
+
+func TestAuditor(t *testing.T) {
+	m := New()
+	next := &discardAuditor{}
+	auditor := m.Auditor(next)
+	ctx := context.Background()
+
+	auditor.Record(ctx, model.AuditEvent{Type: model.AuditLogin, AppID: 1, Outcome: model.AuditSuccess})
+	auditor.Record(ctx, model.AuditEvent{
+		Type:    model.AuditLogin,
+		AppID:   1,
+		Outcome: model.AuditFailure,
+		Reason:  "invalid_credentials",
+	})
+	auditor.Record(ctx, model.AuditEvent{Type: model.AuditLogin, Outcome: model.AuditFailure, Reason: "invalid_app"})
+	auditor.Record(ctx, model.AuditEvent{Type: model.AuditUserRegistered, Outcome: model.AuditSuccess})
+	auditor.Record(ctx, model.AuditEvent{Type: model.AuditLockout, Outcome: model.AuditSuccess, Detail: "email"})
+	auditor.Record(ctx, model.AuditEvent{Type: model.AuditLogout, Outcome: model.AuditSuccess})
+
+	assert.Equal(t, 6, next.events)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.logins.WithLabelValues("1", model.AuditSuccess, "")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.logins.WithLabelValues("1", model.AuditFailure, "invalid_credentials")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.logins.WithLabelValues("unknown", model.AuditFailure, "invalid_app")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.registrations.WithLabelValues(model.AuditSuccess, "")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.lockouts.WithLabelValues("email")))
+}
+
+func TestHashAlgorithm(t *testing.T) {
+	testCases := []struct {
+		hash string
+		want string
+	}{
+		{hash: "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA", want: "argon2id"},
+		{hash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", want: "bcrypt"},
+		{hash: "plain", want: "unknown"},
+		{hash: "$", want: "unknown"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			assert.Equal(t, tc.want, hashAlgorithm([]byte(tc.hash)))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixLen is the length of the hash prefix files are named by.
+const prefixLen = 5
+
+// RangeDir looks passwords up in an offline copy of a breached password
+// corpus in the k-anonymity range layout used by Have I Been Pwned: the
+// upper case hex SHA-1 hashes are split by their first 5 characters into
+// files named <PREFIX>.txt, each line holding the rest of a hash and how
+// often it was seen, e.g. "0018A45C4D1DEF81644B54AB7F969B88D65:10".
+//
+// Only the file of the hash prefix is read, so the corpus can be kept on
+// disk in full without being loaded into memory.
+type RangeDir struct {
+	dir string
+}
+
+func NewRangeDir(dir string) (*RangeDir, error) {
+	const op = "password.NewRangeDir"
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s: %s is not a directory", op, dir)
+	}
+
+	return &RangeDir{dir: dir}, nil
+}
+
+// Contains reports whether the password is in the corpus.
+func (d *RangeDir) Contains(ctx context.Context, password string) (bool, error) {
+	const op = "password.RangeDir.Contains"
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:prefixLen], hash[prefixLen:]
+
+	f, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return false, nil
+}
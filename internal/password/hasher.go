@@ -0,0 +1,280 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/JSONStatham/sso/internal/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashing algorithms supported by Hasher.
+const (
+	AlgArgon2id = "argon2id"
+	AlgBcrypt   = "bcrypt"
+)
+
+var (
+	ErrMismatch       = errors.New("password does not match the hash")
+	ErrMalformedHash  = errors.New("malformed password hash")
+	ErrUnknownPepper  = errors.New("hash was created with another pepper")
+	ErrUnsupportedAlg = errors.New("unsupported password hashing algorithm")
+)
+
+// b64 is the encoding of binary PHC string fields.
+var b64 = base64.RawStdEncoding
+
+// Hasher hashes passwords with the configured algorithm and verifies hashes
+// of every supported algorithm.
+//
+// Argon2id hashes are encoded as PHC strings, e.g.
+// "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>". bcrypt hashes use their
+// usual "$2a$<cost>$..." form.
+//
+// An optional pepper, a secret kept out of the database, is mixed into the
+// password with HMAC-SHA256 before Argon2id hashing. The "keyid" parameter
+// of the hash identifies the pepper, so hashes created before it was
+// introduced keep verifying and are rehashed on the next login. bcrypt
+// hashes cannot record a pepper and never use it.
+type Hasher struct {
+	cfg      config.HasherConfig
+	pepper   []byte
+	pepperID string
+}
+
+// NewHasher returns the hasher configured by cfg. The pepper is read from
+// cfg.PepperPath if set.
+func NewHasher(cfg config.HasherConfig) (*Hasher, error) {
+	const op = "password.NewHasher"
+
+	switch cfg.Algorithm {
+	case AlgArgon2id:
+		if cfg.Argon2.Memory == 0 || cfg.Argon2.Iterations == 0 || cfg.Argon2.Parallelism == 0 ||
+			cfg.Argon2.SaltLength == 0 || cfg.Argon2.KeyLength == 0 {
+			return nil, fmt.Errorf("%s: argon2 parameters must not be zero", op)
+		}
+	case AlgBcrypt:
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("%s: bcrypt cost must be between %d and %d", op, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		if cfg.PepperPath != "" {
+			return nil, fmt.Errorf("%s: a pepper requires the %s algorithm", op, AlgArgon2id)
+		}
+	default:
+		return nil, fmt.Errorf("%s: %w %q", op, ErrUnsupportedAlg, cfg.Algorithm)
+	}
+
+	h := &Hasher{cfg: cfg}
+
+	if cfg.PepperPath != "" {
+		pepper, err := os.ReadFile(cfg.PepperPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		pepper = []byte(strings.TrimSpace(string(pepper)))
+		if len(pepper) < 16 {
+			return nil, fmt.Errorf("%s: pepper must be at least 16 bytes long", op)
+		}
+
+		// The id is derived from the pepper so that it cannot get out of sync.
+		sum := sha256.Sum256(pepper)
+		h.pepper = pepper
+		h.pepperID = b64.EncodeToString(sum[:6])
+	}
+
+	return h, nil
+}
+
+// Hash returns the encoded hash of the password.
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	const op = "password.Hash"
+
+	if h.cfg.Algorithm == AlgBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return hash, nil
+	}
+
+	params := argon2Params{
+		Memory:      h.cfg.Argon2.Memory,
+		Iterations:  h.cfg.Argon2.Iterations,
+		Parallelism: h.cfg.Argon2.Parallelism,
+		KeyID:       h.pepperID,
+		Salt:        make([]byte, h.cfg.Argon2.SaltLength),
+	}
+	if _, err := rand.Read(params.Salt); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	params.Key = h.argon2Key(password, params, h.cfg.Argon2.KeyLength)
+
+	return []byte(params.encode()), nil
+}
+
+// Compare returns ErrMismatch if the password does not match the hash.
+func (h *Hasher) Compare(hash []byte, password string) error {
+	const op = "password.Compare"
+
+	if isBcrypt(hash) {
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return ErrMismatch
+			}
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		return nil
+	}
+
+	params, err := parseArgon2(string(hash))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Hashes without a key id predate the pepper.
+	if params.KeyID != "" && params.KeyID != h.pepperID {
+		return fmt.Errorf("%s: %w", op, ErrUnknownPepper)
+	}
+
+	key := h.argon2Key(password, params, uint32(len(params.Key)))
+	if subtle.ConstantTimeCompare(key, params.Key) != 1 {
+		return ErrMismatch
+	}
+
+	return nil
+}
+
+// NeedsRehash reports whether the hash was not created with the current
+// algorithm, parameters and pepper.
+func (h *Hasher) NeedsRehash(hash []byte) bool {
+	if isBcrypt(hash) {
+		if h.cfg.Algorithm != AlgBcrypt {
+			return true
+		}
+
+		cost, err := bcrypt.Cost(hash)
+		return err != nil || cost != h.cfg.BcryptCost
+	}
+
+	if h.cfg.Algorithm != AlgArgon2id {
+		return true
+	}
+
+	params, err := parseArgon2(string(hash))
+	if err != nil {
+		return true
+	}
+
+	cfg := h.cfg.Argon2
+
+	return params.Memory != cfg.Memory || params.Iterations != cfg.Iterations ||
+		params.Parallelism != cfg.Parallelism || uint32(len(params.Salt)) != cfg.SaltLength ||
+		uint32(len(params.Key)) != cfg.KeyLength || params.KeyID != h.pepperID
+}
+
+func (h *Hasher) argon2Key(password string, params argon2Params, keyLen uint32) []byte {
+	secret := []byte(password)
+	if params.KeyID != "" {
+		mac := hmac.New(sha256.New, h.pepper)
+		mac.Write(secret)
+		secret = mac.Sum(nil)
+	}
+
+	return argon2.IDKey(secret, params.Salt, params.Iterations, params.Memory, params.Parallelism, keyLen)
+}
+
+func isBcrypt(hash []byte) bool {
+	return len(hash) > 3 && hash[0] == '$' && hash[1] == '2'
+}
+
+type argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	KeyID       string
+	Salt        []byte
+	Key         []byte
+}
+
+func (p argon2Params) encode() string {
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+	if p.KeyID != "" {
+		params += ",keyid=" + p.KeyID
+	}
+
+	return fmt.Sprintf("$%s$v=%d$%s$%s$%s", AlgArgon2id, argon2.Version, params,
+		b64.EncodeToString(p.Salt), b64.EncodeToString(p.Key))
+}
+
+// parseArgon2 parses a PHC string created by argon2Params.encode.
+func parseArgon2(hash string) (argon2Params, error) {
+	fields := strings.Split(hash, "$")
+	if len(fields) != 6 || fields[0] != "" {
+		return argon2Params{}, ErrMalformedHash
+	}
+
+	if fields[1] != AlgArgon2id {
+		return argon2Params{}, fmt.Errorf("%w %q", ErrUnsupportedAlg, fields[1])
+	}
+
+	if fields[2] != "v="+strconv.Itoa(argon2.Version) {
+		return argon2Params{}, fmt.Errorf("%w: unsupported version %q", ErrMalformedHash, fields[2])
+	}
+
+	var p argon2Params
+	for _, param := range strings.Split(fields[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+
+		var err error
+		switch name {
+		case "m":
+			p.Memory, err = parseUint32(value)
+		case "t":
+			p.Iterations, err = parseUint32(value)
+		case "p":
+			var parallelism uint64
+			parallelism, err = strconv.ParseUint(value, 10, 8)
+			p.Parallelism = uint8(parallelism)
+		case "keyid":
+			p.KeyID = value
+		default:
+			err = fmt.Errorf("unknown parameter %q", name)
+		}
+		if err != nil {
+			return argon2Params{}, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+		}
+	}
+
+	var err error
+	if p.Salt, err = b64.DecodeString(fields[4]); err != nil {
+		return argon2Params{}, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+	if p.Key, err = b64.DecodeString(fields[5]); err != nil {
+		return argon2Params{}, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 || len(p.Key) == 0 {
+		return argon2Params{}, fmt.Errorf("%w: missing parameters", ErrMalformedHash)
+	}
+
+	return p, nil
+}
+
+func parseUint32(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	return uint32(n), err
+}
@@ -0,0 +1,150 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JSONStatham/sso/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasher_HashAndCompare(t *testing.T) {
+	for _, alg := range []string{AlgArgon2id, AlgBcrypt} {
+		t.Run(alg, func(t *testing.T) {
+			cfg := testHasherConfig()
+			cfg.Algorithm = alg
+
+			h, err := NewHasher(cfg)
+			require.NoError(t, err)
+
+			hash, err := h.Hash("secret password")
+			require.NoError(t, err)
+
+			require.NoError(t, h.Compare(hash, "secret password"))
+			require.ErrorIs(t, h.Compare(hash, "other password"), ErrMismatch)
+			assert.False(t, h.NeedsRehash(hash))
+
+			other, err := h.Hash("secret password")
+			require.NoError(t, err)
+			assert.NotEqual(t, hash, other, "Hashes should be salted")
+		})
+	}
+}
+
+func TestHasher_PHCFormat(t *testing.T) {
+	h, err := NewHasher(testHasherConfig())
+	require.NoError(t, err)
+
+	hash, err := h.Hash("secret password")
+	require.NoError(t, err)
+
+	fields := strings.Split(string(hash), "$")
+	require.Len(t, fields, 6)
+	assert.Equal(t, "argon2id", fields[1])
+	assert.Equal(t, "v=19", fields[2])
+	assert.Equal(t, "m=1024,t=1,p=1", fields[3])
+}
+
+func TestHasher_NeedsRehash(t *testing.T) {
+	cfg := testHasherConfig()
+	cfg.Algorithm = AlgBcrypt
+
+	bcryptHasher, err := NewHasher(cfg)
+	require.NoError(t, err)
+
+	bcryptHash, err := bcryptHasher.Hash("secret password")
+	require.NoError(t, err)
+
+	argon2Hasher, err := NewHasher(testHasherConfig())
+	require.NoError(t, err)
+
+	argon2Hash, err := argon2Hasher.Hash("secret password")
+	require.NoError(t, err)
+
+	// Hashes of other algorithms still verify but are outdated
+	require.NoError(t, argon2Hasher.Compare(bcryptHash, "secret password"))
+	assert.True(t, argon2Hasher.NeedsRehash(bcryptHash))
+	require.NoError(t, bcryptHasher.Compare(argon2Hash, "secret password"))
+	assert.True(t, bcryptHasher.NeedsRehash(argon2Hash))
+
+	cfg = testHasherConfig()
+	cfg.Argon2.Iterations++
+	stronger, err := NewHasher(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, stronger.Compare(argon2Hash, "secret password"))
+	assert.True(t, stronger.NeedsRehash(argon2Hash))
+}
+
+func TestHasher_Pepper(t *testing.T) {
+	plain, err := NewHasher(testHasherConfig())
+	require.NoError(t, err)
+
+	cfg := testHasherConfig()
+	cfg.PepperPath = writePepper(t, "0123456789abcdef0123456789abcdef")
+	peppered, err := NewHasher(cfg)
+	require.NoError(t, err)
+
+	hash, err := peppered.Hash("secret password")
+	require.NoError(t, err)
+	assert.Contains(t, string(hash), ",keyid=")
+
+	require.NoError(t, peppered.Compare(hash, "secret password"))
+	require.ErrorIs(t, plain.Compare(hash, "secret password"), ErrUnknownPepper)
+
+	// Hashes created before the pepper was introduced keep working
+	plainHash, err := plain.Hash("secret password")
+	require.NoError(t, err)
+	require.NoError(t, peppered.Compare(plainHash, "secret password"))
+	assert.True(t, peppered.NeedsRehash(plainHash))
+
+	cfg.PepperPath = writePepper(t, "fedcba9876543210fedcba9876543210")
+	rotated, err := NewHasher(cfg)
+	require.NoError(t, err)
+	require.ErrorIs(t, rotated.Compare(hash, "secret password"), ErrUnknownPepper)
+}
+
+func TestHasher_Malformed(t *testing.T) {
+	h, err := NewHasher(testHasherConfig())
+	require.NoError(t, err)
+
+	for _, hash := range []string{
+		"",
+		"plain",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$!$a2V5",
+	} {
+		err := h.Compare([]byte(hash), "secret password")
+		require.Error(t, err, hash)
+		assert.NotErrorIs(t, err, ErrMismatch, hash)
+		assert.True(t, h.NeedsRehash([]byte(hash)), hash)
+	}
+}
+
+func testHasherConfig() config.HasherConfig {
+	return config.HasherConfig{
+		Algorithm:  AlgArgon2id,
+		BcryptCost: 4,
+		Argon2: config.Argon2Config{
+			Memory:      1024,
+			Iterations:  1,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+	}
+}
+
+func writePepper(t *testing.T, pepper string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "pepper")
+	require.NoError(t, os.WriteFile(path, []byte(pepper+"\n"), 0o600))
+
+	return path
+}
@@ -0,0 +1,152 @@
+// Package password checks new passwords against the configured policy.
+package password
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/JSONStatham/sso/internal/config"
+)
+
+// Rules a password can violate.
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleLower     = "lowercase"
+	RuleUpper     = "uppercase"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+	RuleEmail     = "contains_email"
+	RuleStrength  = "strength"
+	RuleBreached  = "breached"
+)
+
+// Violation is a rule the password does not satisfy.
+type Violation struct {
+	Rule        string
+	Description string
+}
+
+// Policy checks passwords against the rules of config.PolicyConfig.
+type Policy struct {
+	cfg      config.PolicyConfig
+	breached *RangeDir
+}
+
+// NewPolicy returns the policy configured by cfg. The breached password
+// check is only enabled if cfg.BreachedPath is set.
+func NewPolicy(cfg config.PolicyConfig) (*Policy, error) {
+	const op = "password.NewPolicy"
+
+	p := &Policy{cfg: cfg}
+
+	if cfg.BreachedPath != "" {
+		breached, err := NewRangeDir(cfg.BreachedPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		p.breached = breached
+	}
+
+	return p, nil
+}
+
+// Check returns every rule the password violates. email is the address of
+// the account the password is set for. The error is only set if the check
+// itself failed.
+func (p *Policy) Check(ctx context.Context, password, email string) ([]Violation, error) {
+	const op = "password.Check"
+
+	var violations []Violation
+	violate := func(rule, format string, args ...any) {
+		violations = append(violations, Violation{Rule: rule, Description: fmt.Sprintf(format, args...)})
+	}
+
+	if n := utf8.RuneCountInString(password); n < p.cfg.MinLength {
+		violate(RuleMinLength, "must be at least %d characters long", p.cfg.MinLength)
+	}
+	if p.cfg.MaxLength > 0 && len(password) > p.cfg.MaxLength {
+		violate(RuleMaxLength, "must be at most %d bytes long", p.cfg.MaxLength)
+	}
+
+	classes := charClasses(password)
+	if p.cfg.RequireLower && !classes.lower {
+		violate(RuleLower, "must contain a lowercase letter")
+	}
+	if p.cfg.RequireUpper && !classes.upper {
+		violate(RuleUpper, "must contain an uppercase letter")
+	}
+	if p.cfg.RequireDigit && !classes.digit {
+		violate(RuleDigit, "must contain a digit")
+	}
+	if p.cfg.RequireSymbol && !classes.symbol {
+		violate(RuleSymbol, "must contain a symbol")
+	}
+
+	if p.cfg.DisallowEmail && containsEmail(password, email) {
+		violate(RuleEmail, "must not contain the email address")
+	}
+
+	if p.cfg.MinStrength > 0 {
+		if score := Strength(password); score < p.cfg.MinStrength {
+			violate(RuleStrength, "is too easy to guess, strength %d of at least %d", score, p.cfg.MinStrength)
+		}
+	}
+
+	if p.breached != nil {
+		breached, err := p.breached.Contains(ctx, password)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if breached {
+			violate(RuleBreached, "has appeared in a data breach")
+		}
+	}
+
+	return violations, nil
+}
+
+type classes struct {
+	lower, upper, digit, symbol bool
+}
+
+func charClasses(password string) classes {
+	var c classes
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			c.lower = true
+		case unicode.IsUpper(r):
+			c.upper = true
+		case unicode.IsDigit(r):
+			c.digit = true
+		default:
+			c.symbol = true
+		}
+	}
+
+	return c
+}
+
+// containsEmail reports whether the password contains the email or its
+// local part, ignoring case. Local parts shorter than 3 characters are too
+// common to be checked.
+func containsEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+
+	local, _, _ := strings.Cut(email, "@")
+	if len(local) < 3 {
+		return strings.Contains(password, email)
+	}
+
+	return strings.Contains(password, local)
+}
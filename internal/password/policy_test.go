@@ -0,0 +1,99 @@
+package password
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JSONStatham/sso/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	policy, err := NewPolicy(config.PolicyConfig{
+		MinLength:     8,
+		MaxLength:     72,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		DisallowEmail: true,
+		MinStrength:   3,
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		password string
+		rules    []string
+	}{
+		{name: "Valid", password: "Vy7#qLz9pT", rules: nil},
+		{name: "Short", password: "Vy7#q", rules: []string{RuleMinLength, RuleStrength}},
+		{name: "Long", password: strings.Repeat("Vy7#qLz9pT", 8), rules: []string{RuleMaxLength}},
+		{name: "Missing classes", password: "vy#qlzkptw", rules: []string{RuleUpper, RuleDigit}},
+		{name: "Contains email", password: "Jdoe7#Ltqz", rules: []string{RuleEmail}},
+		{name: "Guessable", password: "Password123", rules: []string{RuleStrength}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			violations, err := policy.Check(context.Background(), tc.password, "jdoe@example.com")
+			require.NoError(t, err)
+
+			var rules []string
+			for _, v := range violations {
+				assert.NotEmpty(t, v.Description)
+				rules = append(rules, v.Rule)
+			}
+			assert.Equal(t, tc.rules, rules)
+		})
+	}
+}
+
+func TestStrength(t *testing.T) {
+	testCases := []struct {
+		password string
+		min, max int
+	}{
+		{password: "password", min: 0, max: 0},
+		{password: "P@ssw0rd", min: 0, max: 1},
+		{password: "qwerty123", min: 0, max: 1},
+		{password: "aaaaaaaaaa", min: 0, max: 1},
+		{password: "Summer2024!", min: 0, max: 2},
+		{password: "abcdefgh12345678", min: 0, max: 2},
+		{password: "Vy7#qLz9pT", min: 4, max: 4},
+		{password: "correct horse battery staple", min: 4, max: 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.password, func(t *testing.T) {
+			score := Strength(tc.password)
+			assert.GreaterOrEqual(t, score, tc.min)
+			assert.LessOrEqual(t, score, tc.max)
+		})
+	}
+}
+
+func TestRangeDir_Contains(t *testing.T) {
+	dir := t.TempDir()
+
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	data := "003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(data), 0o600))
+
+	ranges, err := NewRangeDir(dir)
+	require.NoError(t, err)
+
+	breached, err := ranges.Contains(context.Background(), "password")
+	require.NoError(t, err)
+	assert.True(t, breached)
+
+	// Same prefix file, other suffix
+	breached, err = ranges.Contains(context.Background(), "Vy7#qLz9pT")
+	require.NoError(t, err)
+	assert.False(t, breached, "Passwords without a range file should not be breached")
+
+	_, err = NewRangeDir(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
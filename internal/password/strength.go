@@ -0,0 +1,217 @@
+package password
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Strength estimates how hard the password is to guess on the zxcvbn scale
+// from 0 (too guessable) to 4 (very unguessable).
+//
+// The password is split into the cheapest sequence of patterns an attacker
+// would try: common passwords and words, keyboard walks, character
+// sequences, repeats and years. Characters not covered by a pattern are
+// brute forced within their character class. The score is derived from the
+// resulting number of guesses with the zxcvbn thresholds.
+func Strength(password string) int {
+	bits := guessBits([]rune(password))
+
+	switch {
+	case bits < 10: // 10^3 guesses
+		return 0
+	case bits < 20: // 10^6
+		return 1
+	case bits < 26.6: // 10^8
+		return 2
+	case bits < 33.2: // 10^10
+		return 3
+	default:
+		return 4
+	}
+}
+
+// minMatch is the length a pattern has to have to be cheaper than brute force.
+const minMatch = 3
+
+//go:embed common.txt
+var commonList string
+
+// commonRanks maps common passwords and words to their rank in common.txt.
+var commonRanks = func() map[string]int {
+	ranks := make(map[string]int)
+	for i, word := range strings.Fields(commonList) {
+		if _, ok := ranks[word]; !ok {
+			ranks[word] = i + 1
+		}
+	}
+
+	return ranks
+}()
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+var leet = map[rune]rune{
+	'0': 'o', '1': 'l', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '+': 't',
+}
+
+// guessBits returns log2 of the number of guesses needed for the password.
+// best[i] is the cheapest cost of the first i runes.
+func guessBits(password []rune) float64 {
+	n := len(password)
+	best := make([]float64, n+1)
+
+	for i := 1; i <= n; i++ {
+		best[i] = best[i-1] + bruteForceBits(password[i-1])
+
+		for j := 0; j <= i-minMatch; j++ {
+			if bits, ok := patternBits(password[j:i]); ok && best[j]+bits < best[i] {
+				best[i] = best[j] + bits
+			}
+		}
+	}
+
+	return best[n]
+}
+
+func bruteForceBits(r rune) float64 {
+	switch {
+	case unicode.IsDigit(r):
+		return math.Log2(10)
+	case unicode.IsLower(r), unicode.IsUpper(r):
+		return math.Log2(26)
+	default:
+		return math.Log2(33)
+	}
+}
+
+// patternBits returns the cost of the runes if they form a pattern.
+func patternBits(s []rune) (float64, bool) {
+	var (
+		bits  float64
+		found bool
+	)
+	try := func(b float64, ok bool) {
+		if ok && (!found || b < bits) {
+			bits, found = b, true
+		}
+	}
+
+	try(dictionaryBits(s))
+	try(repeatBits(s))
+	try(sequenceBits(s))
+	try(keyboardBits(s))
+	try(yearBits(s))
+
+	return bits, found
+}
+
+// dictionaryBits matches common passwords and words, also spelled
+// backwards, capitalized or in leetspeak.
+func dictionaryBits(s []rune) (float64, bool) {
+	lower := strings.ToLower(string(s))
+
+	var variations float64
+	if lower != string(s) {
+		variations++
+	}
+
+	word := []rune(lower)
+	substituted := false
+	for i, r := range word {
+		if plain, ok := leet[r]; ok {
+			word[i] = plain
+			substituted = true
+		}
+	}
+	if substituted {
+		variations++
+	}
+
+	for _, candidate := range []string{string(word), reverse(string(word))} {
+		if rank, ok := commonRanks[candidate]; ok {
+			if candidate != string(word) {
+				variations++
+			}
+
+			return math.Log2(float64(rank)) + variations, true
+		}
+	}
+
+	return 0, false
+}
+
+func repeatBits(s []rune) (float64, bool) {
+	for _, r := range s[1:] {
+		if r != s[0] {
+			return 0, false
+		}
+	}
+
+	return bruteForceBits(s[0]) + math.Log2(float64(len(s))), true
+}
+
+// sequenceBits matches runs like "abcd" or "9876".
+func sequenceBits(s []rune) (float64, bool) {
+	delta := s[1] - s[0]
+	if delta != 1 && delta != -1 {
+		return 0, false
+	}
+
+	for i := 2; i < len(s); i++ {
+		if s[i]-s[i-1] != delta {
+			return 0, false
+		}
+	}
+
+	bits := bruteForceBits(s[0]) + math.Log2(float64(len(s)))
+	if delta < 0 {
+		bits++
+	}
+
+	return bits, true
+}
+
+// keyboardBits matches walks along a row of a QWERTY keyboard.
+func keyboardBits(s []rune) (float64, bool) {
+	lower := strings.ToLower(string(s))
+
+	for _, row := range keyboardRows {
+		if strings.Contains(row, lower) || strings.Contains(row, reverse(lower)) {
+			return math.Log2(float64(len(row))) + math.Log2(float64(len(s))) + 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// yearBits matches years from 1900 to 2099.
+func yearBits(s []rune) (float64, bool) {
+	if len(s) != 4 || !(string(s[:2]) == "19" || string(s[:2]) == "20") {
+		return 0, false
+	}
+
+	for _, r := range s[2:] {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+
+	return math.Log2(200), true
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+
+	return string(r)
+}
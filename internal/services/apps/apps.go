@@ -0,0 +1,314 @@
+// Package apps manages the apps registered with the service, their client
+// secrets, redirect URIs and token settings.
+package apps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+	"github.com/JSONStatham/sso/internal/utils/opaque"
+)
+
+var (
+	ErrInvalidSettings = errors.New("invalid app settings")
+	ErrPublicClient    = errors.New("public clients have no secret")
+)
+
+// grantTypes are the grants an app can be restricted to.
+var grantTypes = []string{
+	model.GrantAuthorizationCode,
+	model.GrantRefreshToken,
+	model.GrantClientCredentials,
+	model.GrantPassword,
+}
+
+type Apps struct {
+	log *slog.Logger
+	st  Storage
+}
+
+type Storage interface {
+	SaveApp(ctx context.Context, app model.App) (int64, error)
+	App(ctx context.Context, appID int64) (model.App, error)
+	Apps(ctx context.Context) ([]model.App, error)
+	UpdateApp(ctx context.Context, app model.App) error
+	DeleteApp(ctx context.Context, appID int64) error
+	SetAppSecret(ctx context.Context, appID int64, secretHash string) error
+	SetAppRedirectURIs(ctx context.Context, appID int64, redirectURIs []string) error
+	AppRedirectURIs(ctx context.Context, appID int64) ([]string, error)
+}
+
+func New(log *slog.Logger, st Storage) *Apps {
+	return &Apps{log: log, st: st}
+}
+
+// Create registers an app. Confidential clients get a client secret, which
+// is returned once and only stored as a hash.
+func (a *Apps) Create(ctx context.Context, config model.AppConfig) (model.AppConfig, string, error) {
+	const op = "apps.Create"
+
+	log := a.log.With(slog.String("op", op), slog.String("name", config.App.Name))
+
+	if config.App.ClientType == "" {
+		config.App.ClientType = model.ClientConfidential
+	}
+
+	if err := validate(config); err != nil {
+		log.Warn("invalid app settings", sl.Err(err))
+		return model.AppConfig{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := a.st.SaveApp(ctx, config.App)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppAlreadyExists) {
+			log.Warn("app already exists", sl.Err(err))
+		} else {
+			log.Error("failed to save app", sl.Err(err))
+		}
+
+		return model.AppConfig{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("app_id", id))
+
+	if err := a.st.SetAppRedirectURIs(ctx, id, config.RedirectURIs); err != nil {
+		log.Error("failed to save redirect uris", sl.Err(err))
+		return model.AppConfig{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var secret string
+	if config.App.ClientType == model.ClientConfidential {
+		secret, err = a.newSecret(ctx, id)
+		if err != nil {
+			log.Error("failed to set secret", sl.Err(err))
+			return model.AppConfig{}, "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	created, err := a.config(ctx, id)
+	if err != nil {
+		log.Error("failed to get app", sl.Err(err))
+		return model.AppConfig{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("app created")
+
+	return created, secret, nil
+}
+
+// Get returns the app with its redirect URIs.
+func (a *Apps) Get(ctx context.Context, appID int64) (model.AppConfig, error) {
+	const op = "apps.Get"
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", appID))
+
+	config, err := a.config(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+		} else {
+			log.Error("failed to get app", sl.Err(err))
+		}
+
+		return model.AppConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return config, nil
+}
+
+// List returns every app ordered by id.
+func (a *Apps) List(ctx context.Context) ([]model.AppConfig, error) {
+	const op = "apps.List"
+
+	log := a.log.With(slog.String("op", op))
+
+	apps, err := a.st.Apps(ctx)
+	if err != nil {
+		log.Error("failed to list apps", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	configs := make([]model.AppConfig, 0, len(apps))
+	for _, app := range apps {
+		uris, err := a.st.AppRedirectURIs(ctx, int64(app.ID))
+		if err != nil {
+			log.Error("failed to get redirect uris", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		configs = append(configs, model.AppConfig{App: app, RedirectURIs: uris})
+	}
+
+	return configs, nil
+}
+
+// Update replaces the settings and redirect URIs of the app. The client
+// secret is kept.
+func (a *Apps) Update(ctx context.Context, config model.AppConfig) (model.AppConfig, error) {
+	const op = "apps.Update"
+
+	appID := int64(config.App.ID)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", appID))
+
+	if config.App.ClientType == "" {
+		config.App.ClientType = model.ClientConfidential
+	}
+
+	if err := validate(config); err != nil {
+		log.Warn("invalid app settings", sl.Err(err))
+		return model.AppConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.st.UpdateApp(ctx, config.App); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) || errors.Is(err, storage.ErrAppAlreadyExists) {
+			log.Warn("failed to update app", sl.Err(err))
+		} else {
+			log.Error("failed to update app", sl.Err(err))
+		}
+
+		return model.AppConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.st.SetAppRedirectURIs(ctx, appID, config.RedirectURIs); err != nil {
+		log.Error("failed to save redirect uris", sl.Err(err))
+		return model.AppConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := a.config(ctx, appID)
+	if err != nil {
+		log.Error("failed to get app", sl.Err(err))
+		return model.AppConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("app updated")
+
+	return updated, nil
+}
+
+// Delete deletes the app together with its tokens and roles.
+func (a *Apps) Delete(ctx context.Context, appID int64) error {
+	const op = "apps.Delete"
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", appID))
+
+	if err := a.st.DeleteApp(ctx, appID); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+		} else {
+			log.Error("failed to delete app", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("app deleted")
+
+	return nil
+}
+
+// RotateSecret replaces the client secret of a confidential app. The
+// previous secret stops working immediately.
+func (a *Apps) RotateSecret(ctx context.Context, appID int64) (string, error) {
+	const op = "apps.RotateSecret"
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", appID))
+
+	app, err := a.st.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+		} else {
+			log.Error("failed to get app", sl.Err(err))
+		}
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if app.ClientType == model.ClientPublic {
+		log.Warn("public client asked for a secret")
+
+		return "", fmt.Errorf("%s: %w", op, ErrPublicClient)
+	}
+
+	secret, err := a.newSecret(ctx, appID)
+	if err != nil {
+		log.Error("failed to set secret", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("secret rotated")
+
+	return secret, nil
+}
+
+func (a *Apps) newSecret(ctx context.Context, appID int64) (string, error) {
+	secret, hash, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+
+	if err := a.st.SetAppSecret(ctx, appID, hash); err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+func (a *Apps) config(ctx context.Context, appID int64) (model.AppConfig, error) {
+	app, err := a.st.App(ctx, appID)
+	if err != nil {
+		return model.AppConfig{}, err
+	}
+
+	uris, err := a.st.AppRedirectURIs(ctx, appID)
+	if err != nil {
+		return model.AppConfig{}, err
+	}
+
+	return model.AppConfig{App: app, RedirectURIs: uris}, nil
+}
+
+func validate(config model.AppConfig) error {
+	app := config.App
+
+	if app.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidSettings)
+	}
+
+	if app.ClientType != model.ClientConfidential && app.ClientType != model.ClientPublic {
+		return fmt.Errorf("%w: unknown client type %q", ErrInvalidSettings, app.ClientType)
+	}
+
+	if app.AccessTokenTTL < 0 || app.RefreshTokenTTL < 0 {
+		return fmt.Errorf("%w: token ttl must not be negative", ErrInvalidSettings)
+	}
+
+	for _, grant := range app.GrantTypes {
+		if !slices.Contains(grantTypes, grant) {
+			return fmt.Errorf("%w: unknown grant type %q", ErrInvalidSettings, grant)
+		}
+	}
+
+	if app.ClientType == model.ClientPublic && slices.Contains(app.GrantTypes, model.GrantClientCredentials) {
+		return fmt.Errorf("%w: public clients cannot use the client credentials grant", ErrInvalidSettings)
+	}
+
+	// RFC 6749 section 3.1.2 requires absolute redirect URIs without a
+	// fragment.
+	for _, uri := range config.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+			return fmt.Errorf("%w: invalid redirect uri %q", ErrInvalidSettings, uri)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+// Package audit keeps the tamper-evident log of security relevant events,
+// e.g. logins, password changes and role changes.
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+const (
+	// DefaultPageSize and MaxPageSize bound the events returned by Events.
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+
+	// verifyBatchSize is the number of events Verify reads at once.
+	verifyBatchSize = 1000
+)
+
+var ErrInvalidFilter = errors.New("invalid audit filter")
+
+type Audit struct {
+	log *slog.Logger
+	st  Storage
+}
+
+type Storage interface {
+	AppendAuditEvent(ctx context.Context, event model.AuditEvent) (model.AuditEvent, error)
+	AuditEvents(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error)
+	AuditChain(ctx context.Context, afterID int64, limit int) ([]model.AuditEvent, error)
+}
+
+func New(log *slog.Logger, st Storage) *Audit {
+	return &Audit{log: log, st: st}
+}
+
+// Record appends the event to the audit log. The peer, user agent and actor
+// of the request are taken from the context unless the event sets them. A
+// failure is logged but not returned, auditing never fails the request.
+func (a *Audit) Record(ctx context.Context, event model.AuditEvent) {
+	const op = "audit.Record"
+
+	req := RequestFromContext(ctx)
+	if event.Peer == "" {
+		event.Peer = req.Peer
+	}
+	if event.UserAgent == "" {
+		event.UserAgent = req.UserAgent
+	}
+	if event.ActorID == 0 {
+		event.ActorID = req.ActorID
+	}
+	// Every storage keeps microseconds, the hash has to match what is read
+	// back.
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	// The event is recorded even if the request has been cancelled.
+	ctx = context.WithoutCancel(ctx)
+
+	if _, err := a.st.AppendAuditEvent(ctx, event); err != nil {
+		a.log.With(slog.String("op", op)).Error("failed to record audit event",
+			sl.Err(err),
+			slog.String("type", event.Type),
+			slog.String("outcome", event.Outcome),
+			slog.Int64("target_id", event.TargetID),
+		)
+	}
+}
+
+// Events returns a page of the events matching the filter, newest first,
+// and the id to pass as BeforeID for the next page, which is zero on the
+// last page.
+func (a *Audit) Events(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, int64, error) {
+	const op = "audit.Events"
+
+	log := a.log.With(slog.String("op", op))
+
+	if filter.Limit < 0 || filter.Limit > MaxPageSize {
+		return nil, 0, fmt.Errorf("%s: %w: page size must be between 0 and %d", op, ErrInvalidFilter, MaxPageSize)
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		return nil, 0, fmt.Errorf("%s: %w: since must be before until", op, ErrInvalidFilter)
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = DefaultPageSize
+	}
+
+	// One more event than asked for tells whether there is another page.
+	limit := filter.Limit
+	filter.Limit++
+
+	events, err := a.st.AuditEvents(ctx, filter)
+	if err != nil {
+		log.Error("failed to get audit events", sl.Err(err))
+		return nil, 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(events) <= limit {
+		return events, 0, nil
+	}
+
+	events = events[:limit]
+
+	return events, events[limit-1].ID, nil
+}
+
+// Verify walks the whole audit log and checks that every event is chained
+// to the one before it and has not been changed.
+func (a *Audit) Verify(ctx context.Context) (model.AuditVerification, error) {
+	const op = "audit.Verify"
+
+	log := a.log.With(slog.String("op", op))
+
+	var (
+		result   = model.AuditVerification{Valid: true}
+		afterID  int64
+		prevHash string
+	)
+	for {
+		events, err := a.st.AuditChain(ctx, afterID, verifyBatchSize)
+		if err != nil {
+			log.Error("failed to get audit events", sl.Err(err))
+			return model.AuditVerification{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		for _, event := range events {
+			result.Events++
+
+			if event.PrevHash != prevHash || event.Hash != event.ComputeHash(prevHash) {
+				log.Warn("audit log has been tampered with", slog.Int64("event_id", event.ID))
+
+				result.Valid = false
+				result.BrokenAt = event.ID
+
+				return result, nil
+			}
+
+			prevHash = event.Hash
+			afterID = event.ID
+		}
+
+		if len(events) < verifyBatchSize {
+			return result, nil
+		}
+	}
+}
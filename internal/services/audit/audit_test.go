@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	slogdiscard "github.com/JSONStatham/sso/internal/utils/logger/sl/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStorage keeps the audit log in memory the way the storages do.
+type memoryStorage struct {
+	events []model.AuditEvent
+}
+
+func (s *memoryStorage) AppendAuditEvent(_ context.Context, event model.AuditEvent) (model.AuditEvent, error) {
+	if len(s.events) > 0 {
+		event.PrevHash = s.events[len(s.events)-1].Hash
+	}
+	event.Hash = event.ComputeHash(event.PrevHash)
+	event.ID = int64(len(s.events) + 1)
+
+	s.events = append(s.events, event)
+
+	return event, nil
+}
+
+func (s *memoryStorage) AuditEvents(_ context.Context, filter model.AuditFilter) ([]model.AuditEvent, error) {
+	var events []model.AuditEvent
+	for i := len(s.events) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		if filter.BeforeID == 0 || s.events[i].ID < filter.BeforeID {
+			events = append(events, s.events[i])
+		}
+	}
+
+	return events, nil
+}
+
+func (s *memoryStorage) AuditChain(_ context.Context, afterID int64, limit int) ([]model.AuditEvent, error) {
+	var events []model.AuditEvent
+	for _, event := range s.events {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func newAudit(t *testing.T, events int) (*Audit, *memoryStorage) {
+	t.Helper()
+
+	st := &memoryStorage{}
+	a := New(slogdiscard.NewDiscardLogger(), st)
+
+	ctx := WithRequest(context.Background(), Request{Peer: "192.0.2.1", UserAgent: "test", ActorID: 1})
+	for i := range events {
+		a.Record(ctx, model.AuditEvent{Type: model.AuditLogin, TargetID: int64(i), Outcome: model.AuditSuccess})
+	}
+
+	return a, st
+}
+
+func TestRecord(t *testing.T) {
+	_, st := newAudit(t, 2)
+
+	require.Len(t, st.events, 2)
+
+	first := st.events[0]
+	assert.Equal(t, "192.0.2.1", first.Peer)
+	assert.Equal(t, "test", first.UserAgent)
+	assert.Equal(t, int64(1), first.ActorID)
+	assert.Empty(t, first.PrevHash)
+	assert.Equal(t, first.Hash, st.events[1].PrevHash)
+}
+
+func TestVerify(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tamper   func(events []model.AuditEvent) []model.AuditEvent
+		valid    bool
+		brokenAt int64
+	}{
+		{
+			name:   "Intact",
+			tamper: func(events []model.AuditEvent) []model.AuditEvent { return events },
+			valid:  true,
+		},
+		{
+			name: "Changed event",
+			tamper: func(events []model.AuditEvent) []model.AuditEvent {
+				events[1].Outcome = model.AuditFailure
+				return events
+			},
+			brokenAt: 2,
+		},
+		{
+			name: "Rehashed event",
+			tamper: func(events []model.AuditEvent) []model.AuditEvent {
+				events[1].TargetID = 42
+				events[1].Hash = events[1].ComputeHash(events[1].PrevHash)
+				return events
+			},
+			brokenAt: 3,
+		},
+		{
+			name: "Removed event",
+			tamper: func(events []model.AuditEvent) []model.AuditEvent {
+				return append(events[:1], events[2:]...)
+			},
+			brokenAt: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, st := newAudit(t, 3)
+			st.events = tc.tamper(st.events)
+
+			result, err := a.Verify(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tc.valid, result.Valid)
+			assert.Equal(t, tc.brokenAt, result.BrokenAt)
+		})
+	}
+}
+
+func TestEvents_Pages(t *testing.T) {
+	a, _ := newAudit(t, 5)
+	ctx := context.Background()
+
+	events, next, err := a.Events(ctx, model.AuditFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(5), events[0].ID)
+	assert.Equal(t, int64(4), next)
+
+	events, next, err = a.Events(ctx, model.AuditFilter{Limit: 3, BeforeID: next})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, int64(1), events[2].ID)
+	assert.Zero(t, next)
+
+	_, _, err = a.Events(ctx, model.AuditFilter{Limit: MaxPageSize + 1})
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+}
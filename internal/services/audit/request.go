@@ -0,0 +1,26 @@
+package audit
+
+import "context"
+
+// Request describes where a request comes from. Transports attach it to the
+// context so that the events recorded while serving it carry it.
+type Request struct {
+	Peer      string
+	UserAgent string
+	// ActorID is the authenticated user making the request, if any.
+	ActorID int64
+}
+
+type requestKey struct{}
+
+func WithRequest(ctx context.Context, req Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFromContext returns the request attached by WithRequest, or the
+// zero Request.
+func RequestFromContext(ctx context.Context) Request {
+	req, _ := ctx.Value(requestKey{}).(Request)
+
+	return req
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/storage"
+)
+
+// Auditor records security relevant events in the audit log.
+type Auditor interface {
+	Record(ctx context.Context, event model.AuditEvent)
+}
+
+// recordOutcome records the event as a success, or as a failure if err is
+// set.
+func (a *Auth) recordOutcome(ctx context.Context, event model.AuditEvent, err error) {
+	event.Outcome = model.AuditSuccess
+	if err != nil {
+		event.Outcome = model.AuditFailure
+		event.Reason = auditReason(err)
+	}
+
+	a.auditor.Record(ctx, event)
+}
+
+// auditReason describes err in the audit log without leaking the details
+// of unexpected errors.
+func auditReason(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		return "invalid_credentials"
+	case errors.Is(err, ErrLoginLocked):
+		return "locked"
+	case errors.Is(err, ErrInvalidToken):
+		return "invalid_token"
+	case errors.Is(err, ErrRefreshTokenReused):
+		return "refresh_token_reused"
+	case errors.Is(err, ErrInvalidAppID):
+		return "invalid_app"
+	case errors.Is(err, ErrUnauthorizedClient):
+		return "unauthorized_client"
+	case errors.Is(err, ErrEmailNotVerified):
+		return "email_not_verified"
+	case errors.Is(err, ErrInvalidMFACode):
+		return "invalid_mfa_code"
+	case errors.Is(err, ErrMFAEnrollmentRequired):
+		return "mfa_enrollment_required"
+	case errors.Is(err, ErrWeakPassword):
+		return "weak_password"
+	case errors.Is(err, ErrSamePassword):
+		return "same_password"
+	case errors.Is(err, ErrRoleNotFound):
+		return "role_not_found"
+	case errors.Is(err, storage.ErrUserAlreadyExists):
+		return "user_exists"
+	case errors.Is(err, storage.ErrUserNotFound):
+		return "user_not_found"
+	default:
+		return "internal_error"
+	}
+}
@@ -5,49 +5,111 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/JSONStatham/sso/internal/config"
 	"github.com/JSONStatham/sso/internal/domain/model"
 	"github.com/JSONStatham/sso/internal/storage"
 	"github.com/JSONStatham/sso/internal/utils/jwt"
 	"github.com/JSONStatham/sso/internal/utils/logger/sl"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrInvalidCredentials = errors.New("invalid creadentials")
 	ErrInvalidAppID       = errors.New("invalid application id")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrRefreshTokenReused = errors.New("refresh token reused")
 )
 
 type Auth struct {
-	log *slog.Logger
-	cfg *config.Config
-	st  Storage
+	log     *slog.Logger
+	cfg     *config.Config
+	st      Storage
+	keys    jwt.Keys
+	mailer  Mailer
+	policy  PasswordPolicy
+	hasher  PasswordHasher
+	auditor Auditor
 }
 
 type Storage interface {
 	SaveUser(ctx context.Context, email string, passHash []byte) (uid int64, err error)
 	User(ctx context.Context, email string) (model.User, error)
-	IsAdmin(ctx context.Context, uid int64) (bool, error)
 	App(ctx context.Context, appID int64) (model.App, error)
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	UserByID(ctx context.Context, uid int64) (model.User, error)
+	SaveRefreshToken(ctx context.Context, token model.RefreshToken) error
+	RefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldID int64, next model.RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	GrantRole(ctx context.Context, uid, appID int64, role string) error
+	RevokeRole(ctx context.Context, uid, appID int64, role string) error
+	UserRoles(ctx context.Context, uid, appID int64) ([]string, error)
+	HasPermission(ctx context.Context, uid, appID int64, permission string) (bool, error)
+	LoginAttempt(ctx context.Context, key string) (model.LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, key string, at, expiresAt time.Time) (int, error)
+	BlockLogin(ctx context.Context, key string, until time.Time) error
+	DeleteLoginAttempt(ctx context.Context, key string) error
+	SaveTOTP(ctx context.Context, uid int64, secret string) error
+	UserTOTP(ctx context.Context, uid int64) (model.TOTP, error)
+	ConfirmTOTP(ctx context.Context, uid int64, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, uid, step int64) error
+	DeleteTOTP(ctx context.Context, uid int64) error
+	UseRecoveryCode(ctx context.Context, uid int64, codeHash string) error
+	SaveMFAChallenge(ctx context.Context, challenge model.MFAChallenge) error
+	MFAChallenge(ctx context.Context, tokenHash string) (model.MFAChallenge, error)
+	DeleteMFAChallenge(ctx context.Context, tokenHash string) error
+	VerifyEmail(ctx context.Context, uid int64) error
+	SaveOneTimeToken(ctx context.Context, token model.OneTimeToken) error
+	OneTimeToken(ctx context.Context, tokenHash, purpose string) (model.OneTimeToken, error)
+	ConsumeOneTimeToken(ctx context.Context, tokenHash, purpose string) (model.OneTimeToken, error)
+	DeleteOneTimeTokens(ctx context.Context, uid int64, purpose string) error
+	UpdatePassword(ctx context.Context, uid int64, passHash []byte, keepFamilyID string) error
+	ReplacePasswordHash(ctx context.Context, uid int64, oldHash, newHash []byte) error
+	AppRedirectURIs(ctx context.Context, appID int64) ([]string, error)
+	SaveAuthorizationCode(ctx context.Context, code model.AuthorizationCode) error
+	AuthorizationCode(ctx context.Context, codeHash string) (model.AuthorizationCode, error)
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) error
 }
 
-func New(log *slog.Logger, cfg *config.Config, st Storage) *Auth {
+func New(log *slog.Logger, cfg *config.Config, st Storage, keys jwt.Keys, mailer Mailer, policy PasswordPolicy, hasher PasswordHasher, auditor Auditor) *Auth {
 	return &Auth{
-		log: log,
-		cfg: cfg,
-		st:  st,
+		log:     log,
+		cfg:     cfg,
+		st:      st,
+		keys:    keys,
+		mailer:  mailer,
+		policy:  policy,
+		hasher:  hasher,
+		auditor: auditor,
 	}
 }
 
-func (a *Auth) RegisterUser(ctx context.Context, email, password string) (int64, error) {
+func (a *Auth) RegisterUser(ctx context.Context, email, password string) (_ int64, err error) {
 	const op = "auth.RegisterUser"
 
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
 	log := a.log.With(slog.String("op", op))
 
+	event := model.AuditEvent{Type: model.AuditUserRegistered, Email: email}
+	defer func() { a.recordOutcome(ctx, event, err) }()
+
 	log.Info("registering user")
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := a.checkPassword(ctx, password, email); err != nil {
+		if errors.Is(err, ErrWeakPassword) {
+			log.Warn("password violates the policy", sl.Err(err))
+		} else {
+			log.Error("failed to check password", sl.Err(err))
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.hashPassword(ctx, password)
 	if err != nil {
 		log.Error("failed to hash password", sl.Err(err))
 		return 0, fmt.Errorf("%s: %w", op, err)
@@ -59,81 +121,276 @@ func (a *Auth) RegisterUser(ctx context.Context, email, password string) (int64,
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
+	event.ActorID, event.TargetID = uid, uid
+
 	log.Info("user registered", slog.Int64("uid", uid), slog.String("email", email))
 
+	// The user can ask for another email with SendVerification, so a failed
+	// verification does not fail the registration.
+	if err := a.sendVerification(ctx, log, model.User{ID: int(uid), Email: email}); err != nil {
+		log.Error("failed to create verification token", sl.Err(err))
+	}
+
 	return uid, nil
 }
 
-func (a *Auth) Login(ctx context.Context, email, password string, appID int64) (string, error) {
+// Login authenticates the user in the app. peer is the address the request
+// comes from, failed logins are throttled per email and per peer.
+func (a *Auth) Login(ctx context.Context, email, password string, appID int64, peer string) (model.LoginResult, error) {
 	const op = "auth.Login"
 
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
 	log := a.log.With(slog.String("op", op))
 
 	log.Info("attempting to login user")
 
+	user, app, mfaToken, err := a.authenticate(ctx, log, email, password, appID, model.GrantPassword, peer)
+	if err != nil {
+		return model.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if mfaToken != "" {
+		return model.LoginResult{MFAToken: mfaToken}, nil
+	}
+
+	log.Info("user logged in succefffully", slog.Int("uid", user.ID), slog.String("email", email))
+
+	tokens, err := a.issueTokens(ctx, user, app, "")
+	if err != nil {
+		log.Error("failed to issue tokens", sl.Err(err))
+		return model.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return model.LoginResult{Tokens: tokens}, nil
+}
+
+// authenticate checks the credentials of the user and whether they may log
+// into the app with the grant type. If the user has to pass a second
+// factor, the challenge token is returned as well. The attempt is recorded
+// in the audit log.
+func (a *Auth) authenticate(ctx context.Context, log *slog.Logger, email, password string, appID int64, grant, peer string) (_ model.User, _ model.App, mfaToken string, err error) {
+	// The app is only recorded once it is known to exist, so arbitrary ids
+	// sent by clients do not end up in the audit log and metrics.
+	event := model.AuditEvent{Type: model.AuditLogin, Email: email, Peer: peer, Detail: grant}
+	defer func() { a.recordOutcome(ctx, event, err) }()
+
+	app, err := a.st.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+
+			return model.User{}, model.App{}, "", ErrInvalidAppID
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return model.User{}, model.App{}, "", err
+	}
+
+	event.AppID = int64(app.ID)
+
+	now := time.Now()
+
+	if err := a.checkLoginBlocked(ctx, now, emailLoginKey(email), peerLoginKey(peer)); err != nil {
+		if errors.Is(err, ErrLoginLocked) {
+			log.Warn("login blocked", sl.Err(err), slog.String("peer", peer))
+		} else {
+			log.Error("failed to check login attempts", sl.Err(err))
+		}
+
+		return model.User{}, model.App{}, "", err
+	}
+
 	user, err := a.st.User(ctx, email)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
 			log.Warn("user not found", sl.Err(err))
+			a.recordLoginFailure(ctx, log, now, email, peer)
 
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+			return model.User{}, model.App{}, "", ErrInvalidCredentials
 		}
 
 		log.Error("failed to get user", sl.Err(err))
-		return "", fmt.Errorf("%s: %w", op, err)
+		return model.User{}, model.App{}, "", err
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.Password, []byte(password)); err != nil {
-		log.Warn("invalid credentials", sl.Err(err))
+	event.ActorID, event.TargetID = int64(user.ID), int64(user.ID)
+
+	if err := a.comparePassword(ctx, user, password); err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			log.Warn("invalid credentials", sl.Err(err))
+			a.recordLoginFailure(ctx, log, now, email, peer)
+		} else {
+			log.Error("failed to compare password", sl.Err(err))
+		}
 
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		return model.User{}, model.App{}, "", err
 	}
 
-	app, err := a.st.App(ctx, appID)
+	a.rehashPassword(ctx, log, user, password)
+
+	if err := a.st.DeleteLoginAttempt(ctx, emailLoginKey(email)); err != nil {
+		log.Error("failed to reset failed logins", sl.Err(err))
+	}
+
+	if !app.AllowsGrant(grant) {
+		log.Warn("app may not use the grant", slog.Int("app_id", app.ID), slog.String("grant", grant))
+
+		return model.User{}, model.App{}, "", ErrUnauthorizedClient
+	}
+
+	if app.RequireVerifiedEmail && !user.EmailVerified {
+		log.Warn("email not verified", slog.Int("uid", user.ID), slog.Int("app_id", app.ID))
+
+		return model.User{}, model.App{}, "", ErrEmailNotVerified
+	}
+
+	mfaToken, err = a.mfaChallenge(ctx, user, app)
 	if err != nil {
-		if errors.Is(err, storage.ErrAppNotFound) {
-			log.Warn("app not found", sl.Err(err))
+		if errors.Is(err, ErrMFAEnrollmentRequired) {
+			log.Warn("app requires mfa", slog.Int("uid", user.ID), slog.Int("app_id", app.ID))
 
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+			return model.User{}, model.App{}, "", err
 		}
 
-		log.Error("failed to get app", sl.Err(err))
-		return "", fmt.Errorf("%s: %w", op, err)
+		log.Error("failed to create mfa challenge", sl.Err(err))
+		return model.User{}, model.App{}, "", err
 	}
 
-	log.Info("user logged in succefffully", slog.Int("uid", user.ID), slog.String("email", email))
+	if mfaToken != "" {
+		log.Info("user has to pass mfa", slog.Int("uid", user.ID))
+
+		event.Detail = "mfa_required"
+	}
+
+	return user, app, mfaToken, nil
+}
+
+func (a *Auth) Logout(ctx context.Context, token string) (err error) {
+	const op = "auth.Logout"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	event := model.AuditEvent{Type: model.AuditLogout}
+	defer func() { a.recordOutcome(ctx, event, err) }()
+
+	claims, err := a.verifyToken(ctx, token, "")
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.Warn("invalid token", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to verify token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.ActorID, event.TargetID, event.AppID = claims.UID, claims.UID, claims.AppID
+
+	if err := a.st.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+		log.Error("failed to revoke token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged out", slog.Int64("uid", claims.UID), slog.Int64("app_id", claims.AppID))
+
+	return nil
+}
+
+// VerifyAccessToken verifies an access token of a user issued for any app
+// and describes it.
+func (a *Auth) VerifyAccessToken(ctx context.Context, accessToken string) (model.TokenInfo, error) {
+	const op = "auth.VerifyAccessToken"
 
-	token, err := jwt.NewToken(user, app, a.cfg.TokenTTL)
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	claims, err := a.verifyToken(ctx, accessToken, "")
 	if err != nil {
-		log.Error("failed to create jwt token", sl.Err(err))
-		return "", fmt.Errorf("%s: %w", op, err)
+		if !errors.Is(err, ErrInvalidToken) {
+			a.log.With(slog.String("op", op)).Error("failed to verify token", sl.Err(err))
+		}
+
+		return model.TokenInfo{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return token, nil
+	return model.TokenInfo{
+		Active:    true,
+		UID:       claims.UID,
+		AppID:     claims.AppID,
+		Scopes:    claims.Scopes,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
 }
 
-func (a *Auth) Logout(ctx context.Context, token string) error {
-	panic("not implemented")
+// verifyToken parses the token and makes sure it has not been revoked,
+// either on its own or by a password change of the user. Every path
+// accepting a token issued by Login must go through it. The token has to be
+// issued for the audience unless it is empty, which the endpoints of the
+// service itself use to accept tokens of every app.
+func (a *Auth) verifyToken(ctx context.Context, token, audience string) (jwt.Claims, error) {
+	return a.checkToken(ctx, token, jwt.Validation{Issuer: a.cfg.JWT.Issuer, Audience: audience})
 }
 
-func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
-	const op = "auth.IsAdmin"
+// verifyClientToken is verifyToken also accepting the tokens apps are
+// issued for themselves by the client credentials grant. Their UID is 0.
+func (a *Auth) verifyClientToken(ctx context.Context, token, audience string) (jwt.Claims, error) {
+	return a.checkToken(ctx, token, jwt.Validation{Issuer: a.cfg.JWT.Issuer, Audience: audience, AllowClient: true})
+}
 
-	log := a.log.With(slog.String("op", op), slog.Int64("uid", userID))
+func (a *Auth) checkToken(ctx context.Context, token string, v jwt.Validation) (jwt.Claims, error) {
+	claims, err := jwt.ParseToken(a.keys, token, v)
+	if err != nil {
+		return jwt.Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
 
-	isAdmin, err := a.st.IsAdmin(ctx, userID)
+	revoked, err := a.st.IsTokenRevoked(ctx, claims.ID)
 	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			log.Warn("user not found", sl.Err(err))
+		return jwt.Claims{}, err
+	}
+
+	if revoked {
+		return jwt.Claims{}, fmt.Errorf("%w: token has been revoked", ErrInvalidToken)
+	}
 
-			return false, fmt.Errorf("%s: %w", op, err)
+	if claims.UID == 0 {
+		// Client tokens are not tied to a user.
+		return claims, nil
+	}
+
+	user, err := a.st.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return jwt.Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 		}
 
-		log.Error("failed to check if user is admin", sl.Err(err))
-		return false, fmt.Errorf("%s: %w", op, err)
+		return jwt.Claims{}, err
+	}
+
+	if claims.TokenVersion != user.TokenVersion {
+		return jwt.Claims{}, fmt.Errorf("%w: token issued before the password was changed", ErrInvalidToken)
 	}
 
-	log.Info("user is admin", slog.Bool("is_admin", isAdmin))
+	return claims, nil
+}
+
+// JWKS returns the public keys tokens issued by the service can be verified with.
+func (a *Auth) JWKS(ctx context.Context) (jwt.JWKS, error) {
+	const op = "auth.JWKS"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	set, err := jwt.NewJWKS(a.keys)
+	if err != nil {
+		a.log.With(slog.String("op", op)).Error("failed to build jwks", sl.Err(err))
+		return jwt.JWKS{}, fmt.Errorf("%s: %w", op, err)
+	}
 
-	return isAdmin, nil
+	return set, nil
 }
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/mailer"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+	"github.com/JSONStatham/sso/internal/utils/opaque"
+)
+
+var ErrEmailNotVerified = errors.New("email not verified")
+
+type Mailer interface {
+	Send(ctx context.Context, msg mailer.Message) error
+}
+
+// SendVerification emails a new verification token to the user. It does not
+// report whether the email belongs to a user or is already verified, so it
+// cannot be used to probe for accounts. For the same reason failed
+// deliveries are only logged.
+func (a *Auth) SendVerification(ctx context.Context, email string) error {
+	const op = "auth.SendVerification"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	user, err := a.st.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+
+			return nil
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.EmailVerified {
+		log.Info("email already verified", slog.Int("uid", user.ID))
+
+		return nil
+	}
+
+	if err := a.sendVerification(ctx, log, user); err != nil {
+		log.Error("failed to create verification token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("verification sent", slog.Int("uid", user.ID))
+
+	return nil
+}
+
+// ConfirmEmail marks the email of the user the verification token was sent
+// to as verified.
+func (a *Auth) ConfirmEmail(ctx context.Context, token string) error {
+	const op = "auth.ConfirmEmail"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	uid, err := a.consumeOneTimeToken(ctx, token, model.PurposeEmailVerification)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.Warn("invalid verification token", sl.Err(err))
+		} else {
+			log.Error("failed to consume verification token", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.st.VerifyEmail(ctx, uid); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to verify email", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email verified", slog.Int64("uid", uid))
+
+	return nil
+}
+
+// sendVerification emails a verification token to the user. Only creating
+// the token can fail, see sendMail.
+func (a *Auth) sendVerification(ctx context.Context, log *slog.Logger, user model.User) error {
+	token, err := a.newOneTimeToken(ctx, int64(user.ID), model.PurposeEmailVerification, a.cfg.Verification.TokenTTL)
+	if err != nil {
+		return err
+	}
+
+	a.sendMail(ctx, log, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: "Confirm your email address by opening the link below:\n\n" +
+			a.cfg.Verification.URL + token + "\n\n" +
+			"If you did not create an account, ignore this email.\n",
+	})
+
+	return nil
+}
+
+// sendMail delivers the message and only logs failures. Mails are sent to
+// existing accounts only, so reporting a failure would reveal the account.
+func (a *Auth) sendMail(ctx context.Context, log *slog.Logger, msg mailer.Message) {
+	if err := a.mailer.Send(ctx, msg); err != nil {
+		log.Error("failed to send email", slog.String("subject", msg.Subject), sl.Err(err))
+	}
+}
+
+// newOneTimeToken stores a single-use token for the user and returns it.
+// Only its hash is persisted. The tokens are random rather than signed on
+// purpose: a signed token would still need a stored record to be used only
+// once, and a random one carries nothing that could be forged or read.
+func (a *Auth) newOneTimeToken(ctx context.Context, uid int64, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+
+	err = a.st.SaveOneTimeToken(ctx, model.OneTimeToken{
+		TokenHash: hash,
+		UserID:    uid,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeOneTimeToken uses up the token and returns the id of the user it
+// was issued to.
+func (a *Auth) consumeOneTimeToken(ctx context.Context, token, purpose string) (int64, error) {
+	stored, err := a.st.ConsumeOneTimeToken(ctx, opaque.Hash(token), purpose)
+	if err != nil {
+		if errors.Is(err, storage.ErrOneTimeTokenNotFound) {
+			return 0, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+
+		return 0, err
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return 0, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+
+	return stored.UserID, nil
+}
+
+// oneTimeToken returns the token if it is valid without using it up.
+func (a *Auth) oneTimeToken(ctx context.Context, token, purpose string) (model.OneTimeToken, error) {
+	stored, err := a.st.OneTimeToken(ctx, opaque.Hash(token), purpose)
+	if err != nil {
+		if errors.Is(err, storage.ErrOneTimeTokenNotFound) {
+			return model.OneTimeToken{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+
+		return model.OneTimeToken{}, err
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return model.OneTimeToken{}, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+
+	return stored, nil
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/JSONStatham/sso/internal/config"
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/mailer"
+	"github.com/JSONStatham/sso/internal/storage"
+	slogdiscard "github.com/JSONStatham/sso/internal/utils/logger/sl/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const knownEmail = "known@example.com"
+
+// fakeStorage knows a single unverified user. Methods the tests do not
+// need panic through the nil Storage.
+type fakeStorage struct {
+	Storage
+}
+
+func (fakeStorage) User(_ context.Context, email string) (model.User, error) {
+	if email != knownEmail {
+		return model.User{}, storage.ErrUserNotFound
+	}
+
+	return model.User{ID: 1, Email: knownEmail}, nil
+}
+
+func (fakeStorage) SaveOneTimeToken(_ context.Context, _ model.OneTimeToken) error {
+	return nil
+}
+
+type failingMailer struct {
+	sent []mailer.Message
+}
+
+func (m *failingMailer) Send(_ context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+
+	return errors.New("connection refused")
+}
+
+func newMailAuth(m Mailer) *Auth {
+	return New(slogdiscard.NewDiscardLogger(), &config.Config{}, fakeStorage{}, nil, m, nil, nil, nil)
+}
+
+func TestSendVerification_HidesFailedDelivery(t *testing.T) {
+	m := &failingMailer{}
+	a := newMailAuth(m)
+
+	// Both emails get the same response although only one is delivered to
+	require.NoError(t, a.SendVerification(context.Background(), knownEmail))
+	require.NoError(t, a.SendVerification(context.Background(), "unknown@example.com"))
+
+	require.Len(t, m.sent, 1)
+	assert.Equal(t, knownEmail, m.sent[0].To)
+}
+
+func TestRequestPasswordReset_HidesFailedDelivery(t *testing.T) {
+	m := &failingMailer{}
+	a := newMailAuth(m)
+
+	require.NoError(t, a.RequestPasswordReset(context.Background(), knownEmail))
+	require.NoError(t, a.RequestPasswordReset(context.Background(), "unknown@example.com"))
+
+	require.Len(t, m.sent, 1)
+	assert.Equal(t, "Reset your password", m.sent[0].Subject)
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+	"github.com/JSONStatham/sso/internal/utils/opaque"
+)
+
+var ErrInvalidAppCredentials = errors.New("invalid app credentials")
+
+// AuthenticateApp checks the secret of a registered app.
+func (a *Auth) AuthenticateApp(ctx context.Context, appID int64, secret string) (model.App, error) {
+	const op = "auth.AuthenticateApp"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", appID))
+
+	app, err := a.st.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+
+			return model.App{}, fmt.Errorf("%s: %w", op, ErrInvalidAppCredentials)
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return model.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !validAppSecret(app, secret) {
+		log.Warn("invalid app secret")
+
+		return model.App{}, fmt.Errorf("%s: %w", op, ErrInvalidAppCredentials)
+	}
+
+	return app, nil
+}
+
+// AuthenticateAppCertificate returns a registered app whose client
+// certificate was verified by the TLS listener.
+func (a *Auth) AuthenticateAppCertificate(ctx context.Context, appID int64) (model.App, error) {
+	const op = "auth.AuthenticateAppCertificate"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", appID))
+
+	app, err := a.st.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app of client certificate not found", sl.Err(err))
+
+			return model.App{}, fmt.Errorf("%s: %w", op, ErrInvalidAppCredentials)
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return model.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+func validAppSecret(app model.App, secret string) bool {
+	return app.SecretHash != "" && subtle.ConstantTimeCompare([]byte(app.SecretHash), []byte(opaque.Hash(secret))) == 1
+}
+
+// Introspect reports whether the token is active for the calling app as
+// described in RFC 7662. Tokens issued for other apps are reported as
+// inactive, so an app cannot learn anything about them. Tokens the app was
+// issued for itself are reported without a user.
+func (a *Auth) Introspect(ctx context.Context, caller model.App, token string) (model.TokenInfo, error) {
+	const op = "auth.Introspect"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int("caller_app_id", caller.ID))
+
+	claims, err := a.verifyClientToken(ctx, token, caller.Audience())
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.Info("token is not active", sl.Err(err))
+
+			return model.TokenInfo{Active: false}, nil
+		}
+
+		log.Error("failed to verify token", sl.Err(err))
+		return model.TokenInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return model.TokenInfo{
+		Active:    true,
+		UID:       claims.UID,
+		AppID:     claims.AppID,
+		Scopes:    claims.Scopes,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+var ErrLoginLocked = errors.New("too many failed login attempts")
+
+// LockedError is returned by Login while the email or the source address
+// is blocked after failed logins. It matches ErrLoginLocked.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrLoginLocked, e.RetryAfter)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLoginLocked
+}
+
+func emailLoginKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func peerLoginKey(peer string) string {
+	if peer == "" {
+		return ""
+	}
+
+	return "peer:" + peer
+}
+
+// checkLoginBlocked returns a *LockedError if any of the keys is blocked.
+func (a *Auth) checkLoginBlocked(ctx context.Context, now time.Time, keys ...string) error {
+	var retryAfter time.Duration
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		attempt, err := a.st.LoginAttempt(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrLoginAttemptNotFound) {
+				continue
+			}
+
+			return err
+		}
+
+		if attempt.BlockedUntil != nil && attempt.BlockedUntil.After(now) {
+			retryAfter = max(retryAfter, attempt.BlockedUntil.Sub(now))
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LockedError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// Scopes of lockouts, recorded as the detail of AuditLockout events.
+const (
+	lockoutScopeEmail = "email"
+	lockoutScopePeer  = "peer"
+)
+
+// recordLoginFailure counts a failed login for the email and the peer and
+// blocks them if needed. Failures are only logged, the caller rejects the
+// login anyway.
+func (a *Auth) recordLoginFailure(ctx context.Context, log *slog.Logger, now time.Time, email, peer string) {
+	cfg := a.cfg.Lockout
+
+	locked, err := a.throttle(ctx, now, emailLoginKey(email), cfg.MaxAttempts, cfg.Backoff)
+	if err != nil {
+		log.Error("failed to record failed login", sl.Err(err))
+	}
+	if locked {
+		a.recordLockout(ctx, log, lockoutScopeEmail, email, peer)
+	}
+
+	// A peer may be shared by many users, so it is only locked once it
+	// reaches its limit.
+	locked, err = a.throttle(ctx, now, peerLoginKey(peer), cfg.PeerMaxAttempts, 0)
+	if err != nil {
+		log.Error("failed to record failed login", sl.Err(err))
+	}
+	if locked {
+		a.recordLockout(ctx, log, lockoutScopePeer, email, peer)
+	}
+}
+
+func (a *Auth) recordLockout(ctx context.Context, log *slog.Logger, scope, email, peer string) {
+	log.Warn("login locked", slog.String("scope", scope), slog.String("peer", peer))
+
+	a.auditor.Record(ctx, model.AuditEvent{
+		Type:    model.AuditLockout,
+		Email:   email,
+		Peer:    peer,
+		Outcome: model.AuditSuccess,
+		Detail:  scope,
+	})
+}
+
+// throttle counts a failed login for the key and blocks it if needed. It
+// reports whether the key got locked for the full lock duration rather than
+// backing off.
+func (a *Auth) throttle(ctx context.Context, now time.Time, key string, maxAttempts int, backoff time.Duration) (bool, error) {
+	if key == "" {
+		return false, nil
+	}
+
+	cfg := a.cfg.Lockout
+
+	failures, err := a.st.RecordLoginFailure(ctx, key, now, now.Add(cfg.Window))
+	if err != nil {
+		return false, err
+	}
+
+	delay := lockoutDelay(failures, maxAttempts, backoff, cfg.LockDuration)
+	if delay <= 0 {
+		return false, nil
+	}
+
+	if err := a.st.BlockLogin(ctx, key, now.Add(delay)); err != nil {
+		return false, err
+	}
+
+	// Every failure past the limit extends the lock, only reaching the
+	// limit locks the key.
+	return maxAttempts > 0 && failures == maxAttempts, nil
+}
+
+// lockoutDelay returns how long a key is blocked for after its nth failed
+// login: backoff doubled for every failure after the first, and lock once
+// maxAttempts is reached.
+func lockoutDelay(failures, maxAttempts int, backoff, lock time.Duration) time.Duration {
+	if maxAttempts > 0 && failures >= maxAttempts {
+		return lock
+	}
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	delay := backoff
+	for i := 1; i < failures && delay < lock; i++ {
+		delay *= 2
+	}
+
+	return min(delay, lock)
+}
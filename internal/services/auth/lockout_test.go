@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutDelay(t *testing.T) {
+	const (
+		maxAttempts = 5
+		backoff     = time.Second
+		lock        = 15 * time.Minute
+	)
+
+	testCases := []struct {
+		name        string
+		failures    int
+		maxAttempts int
+		backoff     time.Duration
+		want        time.Duration
+	}{
+		{name: "First failure", failures: 1, maxAttempts: maxAttempts, backoff: backoff, want: time.Second},
+		{name: "Doubles", failures: 3, maxAttempts: maxAttempts, backoff: backoff, want: 4 * time.Second},
+		{name: "Locks at the limit", failures: 5, maxAttempts: maxAttempts, backoff: backoff, want: lock},
+		{name: "Stays locked past the limit", failures: 8, maxAttempts: maxAttempts, backoff: backoff, want: lock},
+		{name: "Capped by the lock", failures: 40, maxAttempts: 0, backoff: backoff, want: lock},
+		{name: "No backoff", failures: 2, maxAttempts: maxAttempts, backoff: 0, want: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, lockoutDelay(tc.failures, tc.maxAttempts, tc.backoff, lock))
+		})
+	}
+}
@@ -0,0 +1,499 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+	"github.com/JSONStatham/sso/internal/utils/opaque"
+	"github.com/JSONStatham/sso/internal/utils/totp"
+)
+
+// recoveryCodeSize is the number of random bytes in a recovery code.
+const recoveryCodeSize = 10
+
+var (
+	ErrInvalidMFACode        = errors.New("invalid mfa code")
+	ErrMFAAlreadyEnabled     = errors.New("mfa already enabled")
+	ErrMFANotEnrolled        = errors.New("mfa not enrolled")
+	ErrMFAEnrollmentRequired = errors.New("app requires mfa")
+)
+
+// EnrollmentRequiredError is returned by Login when the app requires MFA
+// and the user has no second factor yet. MFAToken lets the user call
+// EnrollTOTP and ConfirmTOTP and then complete the login with VerifyMFA.
+// It matches ErrMFAEnrollmentRequired.
+type EnrollmentRequiredError struct {
+	MFAToken string
+}
+
+func (e *EnrollmentRequiredError) Error() string {
+	return ErrMFAEnrollmentRequired.Error()
+}
+
+func (e *EnrollmentRequiredError) Unwrap() error {
+	return ErrMFAEnrollmentRequired
+}
+
+// EnrollTOTP generates a new TOTP secret for the authenticated user. The
+// secret only becomes a second factor once a code generated
+// from it is passed to ConfirmTOTP.
+func (a *Auth) EnrollTOTP(ctx context.Context, uid int64) (secret, uri string, err error) {
+	const op = "auth.EnrollTOTP"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	user, err := a.sessionUser(ctx, uid)
+	if err != nil {
+		log.Warn("failed to get user", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int("uid", user.ID))
+
+	secret, err = totp.GenerateSecret()
+	if err != nil {
+		log.Error("failed to generate totp secret", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.st.SaveTOTP(ctx, int64(user.ID), secret); err != nil {
+		if errors.Is(err, storage.ErrTOTPAlreadyEnabled) {
+			log.Warn("totp already enabled")
+
+			return "", "", fmt.Errorf("%s: %w", op, ErrMFAAlreadyEnabled)
+		}
+
+		log.Error("failed to save totp secret", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("totp enrollment started")
+
+	return secret, totp.URI(a.cfg.MFA.Issuer, user.Email, secret), nil
+}
+
+// ConfirmTOTP enables the enrolled secret as a second factor once the user
+// proves to have set it up by sending a code. It returns the recovery codes
+// of the user, which are not stored in plain text and cannot be shown again.
+func (a *Auth) ConfirmTOTP(ctx context.Context, uid int64, code string) ([]string, error) {
+	const op = "auth.ConfirmTOTP"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	log = log.With(slog.Int64("uid", uid))
+
+	if _, err := a.sessionUser(ctx, uid); err != nil {
+		log.Warn("failed to get user", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	enrolled, err := a.st.UserTOTP(ctx, uid)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			log.Warn("totp not enrolled")
+
+			return nil, fmt.Errorf("%s: %w", op, ErrMFANotEnrolled)
+		}
+
+		log.Error("failed to get totp", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if enrolled.ConfirmedAt != nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrMFAAlreadyEnabled)
+	}
+
+	step, ok := totp.Verify(enrolled.Secret, code, time.Now())
+	if !ok {
+		log.Warn("invalid totp code")
+
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidMFACode)
+	}
+
+	if err := a.st.UseTOTPStep(ctx, uid, step); err != nil {
+		log.Warn("totp code reused", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidMFACode)
+	}
+
+	codes, hashes, err := newRecoveryCodes(a.cfg.MFA.RecoveryCodes)
+	if err != nil {
+		log.Error("failed to generate recovery codes", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.st.ConfirmTOTP(ctx, uid, hashes); err != nil {
+		log.Error("failed to confirm totp", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("totp enabled")
+
+	return codes, nil
+}
+
+// DisableTOTP removes the second factor of the user after checking a TOTP
+// or recovery code.
+func (a *Auth) DisableTOTP(ctx context.Context, uid int64, code, peer string) error {
+	const op = "auth.DisableTOTP"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	user, err := a.sessionUser(ctx, uid)
+	if err != nil {
+		log.Warn("failed to get user", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int("uid", user.ID))
+
+	if err := a.checkSecondFactor(ctx, log, user, code, peer); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.st.DeleteTOTP(ctx, int64(user.ID)); err != nil {
+		log.Error("failed to delete totp", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("totp disabled")
+
+	return nil
+}
+
+// VerifyMFA completes a login that returned an MFA challenge. The code is
+// either a TOTP code or an unused recovery code.
+func (a *Auth) VerifyMFA(ctx context.Context, mfaToken, code, peer string) (model.TokenPair, error) {
+	const op = "auth.VerifyMFA"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	user, app, err := a.passMFA(ctx, log, mfaToken, code, peer)
+	if err != nil {
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.issueTokens(ctx, user, app, "")
+	if err != nil {
+		log.Error("failed to issue tokens", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user passed mfa", slog.Int("uid", user.ID))
+
+	return tokens, nil
+}
+
+// VerifyEnrollmentToken checks the challenge token Login returns with an
+// EnrollmentRequiredError. It only authorizes enrolling a second factor.
+func (a *Auth) VerifyEnrollmentToken(ctx context.Context, mfaToken string) (model.TokenInfo, error) {
+	const op = "auth.VerifyEnrollmentToken"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	challenge, err := a.st.MFAChallenge(ctx, opaque.Hash(mfaToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			return model.TokenInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to get mfa challenge", sl.Err(err))
+		return model.TokenInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !challenge.Enrollment || time.Now().After(challenge.ExpiresAt) {
+		return model.TokenInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	return model.TokenInfo{
+		Active:    true,
+		UID:       challenge.UserID,
+		AppID:     challenge.AppID,
+		ExpiresAt: challenge.ExpiresAt,
+	}, nil
+}
+
+// passMFA checks the code against the challenge of the token and consumes
+// the challenge. It returns the user and app the challenge was created for.
+// The attempt is recorded in the audit log.
+func (a *Auth) passMFA(ctx context.Context, log *slog.Logger, mfaToken, code, peer string) (_ model.User, _ model.App, err error) {
+	event := model.AuditEvent{Type: model.AuditMFA, Peer: peer}
+	defer func() { a.recordOutcome(ctx, event, err) }()
+
+	tokenHash := opaque.Hash(mfaToken)
+
+	challenge, err := a.st.MFAChallenge(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			log.Warn("mfa challenge not found", sl.Err(err))
+
+			return model.User{}, model.App{}, ErrInvalidToken
+		}
+
+		log.Error("failed to get mfa challenge", sl.Err(err))
+		return model.User{}, model.App{}, err
+	}
+
+	if time.Now().After(challenge.ExpiresAt) {
+		log.Warn("mfa challenge expired")
+
+		return model.User{}, model.App{}, ErrInvalidToken
+	}
+
+	log = log.With(slog.Int64("uid", challenge.UserID))
+
+	event.ActorID, event.TargetID, event.AppID = challenge.UserID, challenge.UserID, challenge.AppID
+
+	user, err := a.st.UserByID(ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+
+			return model.User{}, model.App{}, ErrInvalidToken
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return model.User{}, model.App{}, err
+	}
+
+	if err := a.checkSecondFactor(ctx, log, user, code, peer); err != nil {
+		return model.User{}, model.App{}, err
+	}
+
+	// Consuming the challenge makes sure it only yields one token pair.
+	if err := a.st.DeleteMFAChallenge(ctx, tokenHash); err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			log.Warn("mfa challenge already used", sl.Err(err))
+
+			return model.User{}, model.App{}, ErrInvalidToken
+		}
+
+		log.Error("failed to delete mfa challenge", sl.Err(err))
+		return model.User{}, model.App{}, err
+	}
+
+	app, err := a.st.App(ctx, challenge.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+
+			return model.User{}, model.App{}, ErrInvalidAppID
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return model.User{}, model.App{}, err
+	}
+
+	return user, app, nil
+}
+
+// mfaChallenge decides whether the user has to pass a second factor before
+// logging into the app and returns the challenge token if so.
+func (a *Auth) mfaChallenge(ctx context.Context, user model.User, app model.App) (string, error) {
+	enabled := true
+
+	enrolled, err := a.st.UserTOTP(ctx, int64(user.ID))
+	if err != nil {
+		if !errors.Is(err, storage.ErrTOTPNotFound) {
+			return "", err
+		}
+
+		enabled = false
+	}
+	if enrolled.ConfirmedAt == nil {
+		enabled = false
+	}
+
+	if !enabled {
+		if !app.RequireMFA {
+			return "", nil
+		}
+
+		token, err := a.saveMFAChallenge(ctx, user, app, true)
+		if err != nil {
+			return "", err
+		}
+
+		return "", &EnrollmentRequiredError{MFAToken: token}
+	}
+
+	return a.saveMFAChallenge(ctx, user, app, false)
+}
+
+func (a *Auth) saveMFAChallenge(ctx context.Context, user model.User, app model.App, enrollment bool) (string, error) {
+	token, hash, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := model.MFAChallenge{
+		TokenHash:  hash,
+		UserID:     int64(user.ID),
+		AppID:      int64(app.ID),
+		ExpiresAt:  time.Now().Add(a.cfg.MFA.ChallengeTTL),
+		Enrollment: enrollment,
+	}
+	if err := a.st.SaveMFAChallenge(ctx, challenge); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// checkSecondFactor verifies a TOTP or recovery code of the user. Wrong
+// codes count as failed logins, so guessing them is throttled like
+// guessing passwords.
+func (a *Auth) checkSecondFactor(ctx context.Context, log *slog.Logger, user model.User, code, peer string) error {
+	now := time.Now()
+
+	if err := a.checkLoginBlocked(ctx, now, emailLoginKey(user.Email), peerLoginKey(peer)); err != nil {
+		if errors.Is(err, ErrLoginLocked) {
+			log.Warn("mfa blocked", sl.Err(err), slog.String("peer", peer))
+		} else {
+			log.Error("failed to check login attempts", sl.Err(err))
+		}
+
+		return err
+	}
+
+	if err := a.verifySecondFactor(ctx, int64(user.ID), code, now); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			log.Warn("invalid mfa code")
+			a.recordLoginFailure(ctx, log, now, user.Email, peer)
+		} else {
+			log.Error("failed to verify mfa code", sl.Err(err))
+		}
+
+		return err
+	}
+
+	if err := a.st.DeleteLoginAttempt(ctx, emailLoginKey(user.Email)); err != nil {
+		log.Error("failed to reset failed logins", sl.Err(err))
+	}
+
+	return nil
+}
+
+func (a *Auth) verifySecondFactor(ctx context.Context, uid int64, code string, now time.Time) error {
+	enrolled, err := a.st.UserTOTP(ctx, uid)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return ErrMFANotEnrolled
+		}
+
+		return err
+	}
+
+	if enrolled.ConfirmedAt == nil {
+		return ErrMFANotEnrolled
+	}
+
+	if step, ok := totp.Verify(enrolled.Secret, code, now); ok {
+		if err := a.st.UseTOTPStep(ctx, uid, step); err != nil {
+			if errors.Is(err, storage.ErrTOTPStepUsed) {
+				return ErrInvalidMFACode
+			}
+
+			return err
+		}
+
+		return nil
+	}
+
+	if err := a.st.UseRecoveryCode(ctx, uid, recoveryCodeHash(code)); err != nil {
+		if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+			return ErrInvalidMFACode
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// sessionUser returns the user whose access token the gRPC interceptor
+// verified. Users deleted since are reported as ErrInvalidToken.
+func (a *Auth) sessionUser(ctx context.Context, uid int64) (model.User, error) {
+	user, err := a.st.UserByID(ctx, uid)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return model.User{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+
+		return model.User{}, err
+	}
+
+	return user, nil
+}
+
+// tokenUser returns the user the access token was issued to.
+func (a *Auth) tokenUser(ctx context.Context, accessToken string) (model.User, error) {
+	claims, err := a.verifyToken(ctx, accessToken, "")
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user, err := a.st.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return model.User{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+
+		return model.User{}, err
+	}
+
+	return user, nil
+}
+
+// newRecoveryCodes returns n recovery codes formatted as xxxx-xxxx-xxxx-xxxx
+// together with their hashes.
+func newRecoveryCodes(n int) (codes, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for range n {
+		b := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		raw := strings.ToLower(encoding.EncodeToString(b))
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+
+		codes = append(codes, code)
+		hashes = append(hashes, recoveryCodeHash(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// recoveryCodeHash normalizes the code the way users may retype it before
+// hashing it.
+func recoveryCodeHash(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	return opaque.Hash(code)
+}
@@ -0,0 +1,505 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/jwt"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+	"github.com/JSONStatham/sso/internal/utils/opaque"
+)
+
+// ScopeOpenID makes an authorization request an OpenID Connect request,
+// the code is exchanged for an ID token as well.
+const ScopeOpenID = "openid"
+
+var (
+	ErrInvalidRedirectURI = errors.New("redirect uri is not registered for the app")
+	ErrPKCERequired       = errors.New("public clients have to use pkce")
+	ErrInvalidGrant       = errors.New("invalid authorization grant")
+	ErrUnauthorizedClient = errors.New("client is not allowed to use the grant")
+)
+
+// AuthorizationClient checks the client and redirect URI of an OAuth 2.0
+// authorization request. The redirect URI has to match one registered for
+// the app exactly. Requests failing with ErrInvalidAppID or
+// ErrInvalidRedirectURI must not be redirected back to the client.
+func (a *Auth) AuthorizationClient(ctx context.Context, req model.AuthorizationRequest) (model.App, error) {
+	const op = "auth.AuthorizationClient"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", req.ClientID))
+
+	app, err := a.authorizationClient(ctx, log, req)
+	if err != nil {
+		return model.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+// Authorize authenticates the user for an authorization request and returns
+// an authorization code for the client. If the user has to pass a second
+// factor first, only the challenge token for AuthorizeMFA is returned.
+func (a *Auth) Authorize(ctx context.Context, req model.AuthorizationRequest, email, password, peer string) (model.AuthorizationResult, error) {
+	const op = "auth.Authorize"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", req.ClientID))
+
+	if _, err := a.authorizationClient(ctx, log, req); err != nil {
+		return model.AuthorizationResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, _, mfaToken, err := a.authenticate(ctx, log, email, password, req.ClientID, model.GrantAuthorizationCode, peer)
+	if err != nil {
+		return model.AuthorizationResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if mfaToken != "" {
+		return model.AuthorizationResult{MFAToken: mfaToken}, nil
+	}
+
+	code, err := a.newAuthorizationCode(ctx, user, req)
+	if err != nil {
+		log.Error("failed to create authorization code", sl.Err(err))
+		return model.AuthorizationResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user authorized app", slog.Int("uid", user.ID))
+
+	return model.AuthorizationResult{Code: code}, nil
+}
+
+// AuthorizeMFA completes an authorization that returned an MFA challenge.
+func (a *Auth) AuthorizeMFA(ctx context.Context, req model.AuthorizationRequest, mfaToken, code, peer string) (model.AuthorizationResult, error) {
+	const op = "auth.AuthorizeMFA"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", req.ClientID))
+
+	if _, err := a.authorizationClient(ctx, log, req); err != nil {
+		return model.AuthorizationResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, app, err := a.passMFA(ctx, log, mfaToken, code, peer)
+	if err != nil {
+		return model.AuthorizationResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if int64(app.ID) != req.ClientID {
+		log.Warn("mfa challenge was created for another app", slog.Int("challenge_app_id", app.ID))
+
+		return model.AuthorizationResult{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	authCode, err := a.newAuthorizationCode(ctx, user, req)
+	if err != nil {
+		log.Error("failed to create authorization code", sl.Err(err))
+		return model.AuthorizationResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user passed mfa and authorized app", slog.Int("uid", user.ID))
+
+	return model.AuthorizationResult{Code: authCode}, nil
+}
+
+// AuthenticateClient authenticates an OAuth 2.0 client. Confidential
+// clients need their secret, public clients must not send one.
+func (a *Auth) AuthenticateClient(ctx context.Context, clientID int64, secret string) (model.App, error) {
+	const op = "auth.AuthenticateClient"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int64("app_id", clientID))
+
+	app, err := a.st.App(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+
+			return model.App{}, fmt.Errorf("%s: %w", op, ErrInvalidAppCredentials)
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return model.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if app.ClientType == model.ClientPublic {
+		if secret != "" {
+			log.Warn("public client sent a secret")
+
+			return model.App{}, fmt.Errorf("%s: %w", op, ErrInvalidAppCredentials)
+		}
+
+		return app, nil
+	}
+
+	if !validAppSecret(app, secret) {
+		log.Warn("invalid app secret")
+
+		return model.App{}, fmt.Errorf("%s: %w", op, ErrInvalidAppCredentials)
+	}
+
+	return app, nil
+}
+
+// ExchangeCode exchanges an authorization code issued to the client for a
+// token pair. redirectURI has to be the one of the authorization request
+// and verifier the PKCE code verifier if a code challenge was sent. Codes
+// can only be exchanged once. Presenting a code again revokes the tokens
+// issued for it, as RFC 6749 section 4.1.2 recommends, since it may have
+// been stolen.
+func (a *Auth) ExchangeCode(ctx context.Context, client model.App, code, redirectURI, verifier string) (model.TokenPair, error) {
+	const op = "auth.ExchangeCode"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", client.ID))
+
+	if !client.AllowsGrant(model.GrantAuthorizationCode) {
+		log.Warn("app may not use the authorization code grant")
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrUnauthorizedClient)
+	}
+
+	authCode, err := a.st.AuthorizationCode(ctx, opaque.Hash(code))
+	if err != nil {
+		if errors.Is(err, storage.ErrAuthorizationCodeNotFound) {
+			log.Warn("authorization code not found", sl.Err(err))
+
+			return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+
+		log.Error("failed to get authorization code", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Another app must not be able to burn the code of the client.
+	if authCode.AppID != int64(client.ID) {
+		log.Warn("authorization code was issued to another app", slog.Int64("code_app_id", authCode.AppID))
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	log = log.With(slog.Int64("uid", authCode.UserID))
+
+	if authCode.ConsumedAt != nil {
+		return model.TokenPair{}, a.revokeReusedCode(ctx, log, op, authCode)
+	}
+
+	if err := a.st.ConsumeAuthorizationCode(ctx, authCode.CodeHash); err != nil {
+		if errors.Is(err, storage.ErrAuthorizationCodeUsed) {
+			// Lost a race against a concurrent exchange of the same code.
+			return model.TokenPair{}, a.revokeReusedCode(ctx, log, op, authCode)
+		}
+
+		log.Error("failed to consume authorization code", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch {
+	case time.Now().After(authCode.ExpiresAt):
+		log.Warn("authorization code expired")
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	case authCode.RedirectURI != redirectURI:
+		log.Warn("redirect uri does not match the authorization request")
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	case !verifyCodeChallenge(authCode.CodeChallenge, verifier):
+		log.Warn("invalid pkce code verifier")
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	user, err := a.st.UserByID(ctx, authCode.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+
+			return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.issueTokens(ctx, user, client, authCode.CodeHash)
+	if err != nil {
+		log.Error("failed to issue tokens", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if slices.Contains(strings.Fields(authCode.Scope), ScopeOpenID) {
+		tokens.IDToken, err = signToken(ctx, func() (string, error) {
+			return jwt.NewIDToken(a.keys, a.cfg.JWT.Issuer, user, client, authCode.Nonce,
+				authCode.AuthTime, a.cfg.OAuth.IDTokenTTL)
+		})
+		if err != nil {
+			log.Error("failed to issue id token", sl.Err(err))
+			return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("authorization code exchanged")
+
+	return tokens, nil
+}
+
+func (a *Auth) revokeReusedCode(ctx context.Context, log *slog.Logger, op string, authCode model.AuthorizationCode) error {
+	log.Warn("authorization code reuse detected, revoking the tokens issued for it")
+
+	if err := a.st.RevokeRefreshTokenFamily(ctx, authCode.CodeHash); err != nil {
+		log.Error("failed to revoke refresh token family", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+}
+
+// RefreshClient is Refresh for an authenticated client, which may only
+// refresh the tokens issued to it.
+func (a *Auth) RefreshClient(ctx context.Context, client model.App, refreshToken string) (model.TokenPair, error) {
+	const op = "auth.RefreshClient"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", client.ID))
+
+	current, err := a.st.RefreshToken(ctx, opaque.Hash(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Warn("refresh token not found", sl.Err(err))
+
+			return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to get refresh token", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if current.AppID != int64(client.ID) {
+		log.Warn("refresh token was issued to another app", slog.Int64("token_app_id", current.AppID))
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	tokens, err := a.Refresh(ctx, refreshToken)
+	if err != nil {
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// ClientCredentials issues an access token for the client itself. Only
+// confidential clients can use the grant.
+func (a *Auth) ClientCredentials(ctx context.Context, client model.App, scope string) (model.TokenPair, error) {
+	const op = "auth.ClientCredentials"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", client.ID))
+
+	if client.ClientType == model.ClientPublic || !client.AllowsGrant(model.GrantClientCredentials) {
+		log.Warn("app may not use the client credentials grant")
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrUnauthorizedClient)
+	}
+
+	ttl := a.accessTokenTTL(client)
+
+	accessToken, err := signToken(ctx, func() (string, error) {
+		return jwt.NewClientToken(a.keys, a.cfg.JWT.Issuer, client, scope, ttl)
+	})
+	if err != nil {
+		log.Error("failed to issue token", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("client token issued")
+
+	return model.TokenPair{AccessToken: accessToken, ExpiresIn: ttl}, nil
+}
+
+// RevokeClientToken revokes a refresh token, together with its family, or
+// an access token issued to the client as described in RFC 7009. Unknown
+// tokens and tokens of other apps are ignored, so the client cannot learn
+// anything about them.
+func (a *Auth) RevokeClientToken(ctx context.Context, client model.App, token string) error {
+	const op = "auth.RevokeClientToken"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", client.ID))
+
+	rt, err := a.st.RefreshToken(ctx, opaque.Hash(token))
+	switch {
+	case err == nil:
+		if rt.AppID != int64(client.ID) {
+			log.Warn("refresh token was issued to another app", slog.Int64("token_app_id", rt.AppID))
+
+			return nil
+		}
+
+		if err := a.st.RevokeRefreshTokenFamily(ctx, rt.FamilyID); err != nil {
+			log.Error("failed to revoke refresh token family", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("refresh token revoked", slog.Int64("uid", rt.UserID))
+
+		return nil
+	case !errors.Is(err, storage.ErrRefreshTokenNotFound):
+		log.Error("failed to get refresh token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Access tokens of other apps fail the audience check and are ignored
+	// like unknown tokens.
+	claims, err := jwt.ParseToken(a.keys, token, jwt.Validation{Issuer: a.cfg.JWT.Issuer, Audience: client.Audience(), AllowClient: true})
+	if err != nil {
+		log.Info("token is neither a refresh nor an access token of the client", sl.Err(err))
+
+		return nil
+	}
+
+	if err := a.st.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+		log.Error("failed to revoke token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("access token revoked", slog.Int64("uid", claims.UID))
+
+	return nil
+}
+
+func (a *Auth) authorizationClient(ctx context.Context, log *slog.Logger, req model.AuthorizationRequest) (model.App, error) {
+	app, err := a.st.App(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+
+			return model.App{}, ErrInvalidAppID
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return model.App{}, err
+	}
+
+	uris, err := a.st.AppRedirectURIs(ctx, req.ClientID)
+	if err != nil {
+		log.Error("failed to get redirect uris", sl.Err(err))
+		return model.App{}, err
+	}
+
+	if !slices.Contains(uris, req.RedirectURI) {
+		log.Warn("redirect uri is not registered", slog.String("redirect_uri", req.RedirectURI))
+
+		return model.App{}, ErrInvalidRedirectURI
+	}
+
+	if !app.AllowsGrant(model.GrantAuthorizationCode) {
+		log.Warn("app may not use the authorization code grant")
+
+		return model.App{}, ErrUnauthorizedClient
+	}
+
+	if app.ClientType == model.ClientPublic && req.CodeChallenge == "" {
+		log.Warn("public client did not send a code challenge")
+
+		return model.App{}, ErrPKCERequired
+	}
+
+	return app, nil
+}
+
+func (a *Auth) newAuthorizationCode(ctx context.Context, user model.User, req model.AuthorizationRequest) (string, error) {
+	code, hash, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	authCode := model.AuthorizationCode{
+		CodeHash:      hash,
+		AppID:         req.ClientID,
+		UserID:        int64(user.ID),
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(a.cfg.OAuth.CodeTTL),
+	}
+	if err := a.st.SaveAuthorizationCode(ctx, authCode); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// verifyCodeChallenge checks the PKCE verifier against the S256 challenge
+// of RFC 7636. Without a challenge no verifier may be sent either.
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if challenge == "" {
+		return verifier == ""
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// UserInfo returns the OpenID Connect claims of the user the access token
+// was issued to.
+func (a *Auth) UserInfo(ctx context.Context, accessToken string) (model.UserInfo, error) {
+	const op = "auth.UserInfo"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	user, err := a.tokenUser(ctx, accessToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.Warn("invalid token", sl.Err(err))
+		} else {
+			log.Error("failed to get user", sl.Err(err))
+		}
+
+		return model.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return model.UserInfo{
+		Subject:       strconv.Itoa(user.ID),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	}, nil
+}
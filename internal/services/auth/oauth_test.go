@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// Example of RFC 7636 appendix B
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+
+	assert.True(t, verifyCodeChallenge(challenge, verifier))
+	assert.False(t, verifyCodeChallenge(challenge, verifier+"x"))
+	assert.False(t, verifyCodeChallenge(challenge, ""))
+	assert.False(t, verifyCodeChallenge("", verifier), "A verifier without a challenge should be rejected")
+	assert.True(t, verifyCodeChallenge("", ""))
+}
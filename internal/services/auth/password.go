@@ -0,0 +1,366 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/mailer"
+	"github.com/JSONStatham/sso/internal/password"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+	"github.com/JSONStatham/sso/internal/utils/opaque"
+)
+
+var (
+	ErrSamePassword = errors.New("new password must differ from the current one")
+	ErrWeakPassword = errors.New("password violates the policy")
+)
+
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	// Compare returns password.ErrMismatch if the password does not match.
+	Compare(hash []byte, password string) error
+	NeedsRehash(hash []byte) bool
+}
+
+type PasswordPolicy interface {
+	Check(ctx context.Context, password, email string) ([]password.Violation, error)
+}
+
+// PasswordError lists every rule of the policy a new password violates.
+// It matches ErrWeakPassword.
+type PasswordError struct {
+	Violations []password.Violation
+}
+
+func (e *PasswordError) Error() string {
+	rules := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		rules = append(rules, v.Rule)
+	}
+
+	return fmt.Sprintf("%s: %s", ErrWeakPassword, strings.Join(rules, ", "))
+}
+
+func (e *PasswordError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// RequestPasswordReset emails a password reset token to the user. Like
+// SendVerification it does not report whether the email belongs to a user,
+// and failed deliveries are only logged.
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) error {
+	const op = "auth.RequestPasswordReset"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	user, err := a.st.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+
+			return nil
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, err := a.newOneTimeToken(ctx, int64(user.ID), model.PurposePasswordReset, a.cfg.PasswordReset.TokenTTL)
+	if err != nil {
+		log.Error("failed to create reset token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.sendMail(ctx, log, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Choose a new password by opening the link below:\n\n" +
+			a.cfg.PasswordReset.URL + token + "\n\n" +
+			"If you did not ask to reset your password, ignore this email.\n",
+	})
+
+	log.Info("password reset requested", slog.Int("uid", user.ID))
+
+	return nil
+}
+
+// ResetPassword sets the password of the user the reset token was sent to.
+// Every session of the user is ended: refresh tokens are revoked and access
+// tokens issued up to the second of the reset are rejected by verifyToken.
+func (a *Auth) ResetPassword(ctx context.Context, token, password string) (err error) {
+	const op = "auth.ResetPassword"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	event := model.AuditEvent{Type: model.AuditPasswordReset}
+	defer func() { a.recordOutcome(ctx, event, err) }()
+
+	stored, err := a.oneTimeToken(ctx, token, model.PurposePasswordReset)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.Warn("invalid reset token", sl.Err(err))
+		} else {
+			log.Error("failed to get reset token", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	uid := stored.UserID
+	event.TargetID = uid
+
+	user, err := a.st.UserByID(ctx, uid)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The token is only used up by an acceptable password, so the user can
+	// try again with the same link.
+	if err := a.checkPassword(ctx, password, user.Email); err != nil {
+		if errors.Is(err, ErrWeakPassword) {
+			log.Warn("password violates the policy", sl.Err(err))
+		} else {
+			log.Error("failed to check password", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.consumeOneTimeToken(ctx, token, model.PurposePasswordReset); err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.Warn("invalid reset token", sl.Err(err))
+		} else {
+			log.Error("failed to consume reset token", sl.Err(err))
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.setPassword(ctx, uid, password, ""); err != nil {
+		log.Error("failed to set password", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The other reset links are stale now.
+	if err := a.st.DeleteOneTimeTokens(ctx, uid, model.PurposePasswordReset); err != nil {
+		log.Error("failed to delete reset tokens", sl.Err(err))
+	}
+
+	// Proving access to the mailbox lifts a lockout of the account.
+	if err := a.st.DeleteLoginAttempt(ctx, emailLoginKey(user.Email)); err != nil {
+		log.Error("failed to reset failed logins", sl.Err(err))
+	}
+
+	log.Info("password reset", slog.Int64("uid", uid))
+
+	return nil
+}
+
+// ChangePassword sets a new password for the authenticated user after
+// checking the current one. Wrong passwords count as failed
+// logins. The session of refreshToken is kept and gets a new token pair,
+// every other session of the user is ended.
+func (a *Auth) ChangePassword(ctx context.Context, uid int64, refreshToken, currentPassword, newPassword, peer string) (_ model.TokenPair, err error) {
+	const op = "auth.ChangePassword"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	event := model.AuditEvent{Type: model.AuditPasswordChanged, Peer: peer}
+	defer func() { a.recordOutcome(ctx, event, err) }()
+
+	user, err := a.sessionUser(ctx, uid)
+	if err != nil {
+		log.Warn("failed to get user", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int("uid", user.ID))
+
+	event.ActorID, event.TargetID = int64(user.ID), int64(user.ID)
+
+	current, err := a.st.RefreshToken(ctx, opaque.Hash(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Warn("refresh token not found", sl.Err(err))
+
+			return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to get refresh token", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if current.UserID != int64(user.ID) || current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		log.Warn("refresh token is revoked, expired or issued to another user")
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	event.AppID = current.AppID
+
+	if current.RotatedAt != nil {
+		return model.TokenPair{}, a.revokeReusedFamily(ctx, log, op, current.FamilyID)
+	}
+
+	now := time.Now()
+
+	if err := a.checkLoginBlocked(ctx, now, emailLoginKey(user.Email), peerLoginKey(peer)); err != nil {
+		if errors.Is(err, ErrLoginLocked) {
+			log.Warn("password change blocked", sl.Err(err), slog.String("peer", peer))
+		} else {
+			log.Error("failed to check login attempts", sl.Err(err))
+		}
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.comparePassword(ctx, user, currentPassword); err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			log.Warn("invalid current password", sl.Err(err))
+			a.recordLoginFailure(ctx, log, now, user.Email, peer)
+		} else {
+			log.Error("failed to compare password", sl.Err(err))
+		}
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.st.DeleteLoginAttempt(ctx, emailLoginKey(user.Email)); err != nil {
+		log.Error("failed to reset failed logins", sl.Err(err))
+	}
+
+	if currentPassword == newPassword {
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrSamePassword)
+	}
+
+	if err := a.checkPassword(ctx, newPassword, user.Email); err != nil {
+		if errors.Is(err, ErrWeakPassword) {
+			log.Warn("password violates the policy", sl.Err(err))
+		} else {
+			log.Error("failed to check password", sl.Err(err))
+		}
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.st.App(ctx, current.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+
+			return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.setPassword(ctx, int64(user.ID), newPassword, current.FamilyID); err != nil {
+		log.Error("failed to set password", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	user.TokenVersion++
+
+	// The access token of the current session predates the change and is
+	// rejected from now on, so the session continues with a new pair.
+	tokens, err := a.rotateTokens(ctx, user, app, current)
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenRotated) {
+			return model.TokenPair{}, a.revokeReusedFamily(ctx, log, op, current.FamilyID)
+		}
+
+		log.Error("failed to rotate tokens", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password changed")
+
+	return tokens, nil
+}
+
+// checkPassword returns a *PasswordError if the password violates the policy.
+func (a *Auth) checkPassword(ctx context.Context, password, email string) error {
+	violations, err := a.policy.Check(ctx, password, email)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return &PasswordError{Violations: violations}
+	}
+
+	return nil
+}
+
+// comparePassword returns ErrInvalidCredentials if pass is not the
+// password of the user.
+func (a *Auth) comparePassword(ctx context.Context, user model.User, pass string) error {
+	_, span := tracer.Start(ctx, "password.Compare")
+	err := a.hasher.Compare(user.Password, pass)
+	span.End()
+
+	if err != nil {
+		if errors.Is(err, password.ErrMismatch) {
+			return ErrInvalidCredentials
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// rehashPassword replaces a hash created with an outdated algorithm or
+// parameters. It only logs errors, since the old hash is still valid.
+func (a *Auth) rehashPassword(ctx context.Context, log *slog.Logger, user model.User, pass string) {
+	if !a.hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	passHash, err := a.hashPassword(ctx, pass)
+	if err != nil {
+		log.Error("failed to rehash password", sl.Err(err))
+		return
+	}
+
+	if err := a.st.ReplacePasswordHash(ctx, int64(user.ID), user.Password, passHash); err != nil {
+		log.Error("failed to save rehashed password", sl.Err(err))
+		return
+	}
+
+	log.Info("password rehashed", slog.Int("uid", user.ID))
+}
+
+// setPassword stores the new password of the user and revokes the refresh
+// tokens of every session but keepFamilyID. The access tokens issued so
+// far are rejected from then on.
+func (a *Auth) setPassword(ctx context.Context, uid int64, password, keepFamilyID string) error {
+	passHash, err := a.hashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	return a.st.UpdatePassword(ctx, uid, passHash, keepFamilyID)
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/jwt"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+	"github.com/JSONStatham/sso/internal/utils/opaque"
+)
+
+// Refresh exchanges a refresh token for a new access/refresh token pair.
+// Refresh tokens are single-use: presenting a token that has already been
+// rotated revokes its whole family, since either the legitimate client or
+// an attacker is holding a stolen copy.
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (model.TokenPair, error) {
+	const op = "auth.Refresh"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op))
+
+	current, err := a.st.RefreshToken(ctx, opaque.Hash(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Warn("refresh token not found", sl.Err(err))
+
+			return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to get refresh token", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", current.UserID), slog.String("family_id", current.FamilyID))
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		log.Warn("refresh token is revoked or expired")
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if current.RotatedAt != nil {
+		return model.TokenPair{}, a.revokeReusedFamily(ctx, log, op, current.FamilyID)
+	}
+
+	user, err := a.st.UserByID(ctx, current.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+
+			return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.st.App(ctx, current.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+
+			return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !app.AllowsGrant(model.GrantRefreshToken) {
+		log.Warn("app may not use the refresh token grant")
+
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, ErrUnauthorizedClient)
+	}
+
+	tokens, err := a.rotateTokens(ctx, user, app, current)
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenRotated) {
+			// Lost a race against a concurrent refresh with the same token.
+			return model.TokenPair{}, a.revokeReusedFamily(ctx, log, op, current.FamilyID)
+		}
+
+		log.Error("failed to rotate tokens", sl.Err(err))
+		return model.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("tokens refreshed")
+
+	return tokens, nil
+}
+
+func (a *Auth) revokeReusedFamily(ctx context.Context, log *slog.Logger, op, familyID string) error {
+	log.Warn("refresh token reuse detected, revoking token family")
+
+	if err := a.st.RevokeRefreshTokenFamily(ctx, familyID); err != nil {
+		log.Error("failed to revoke refresh token family", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return fmt.Errorf("%s: %w", op, ErrRefreshTokenReused)
+}
+
+// rotateTokens creates an access token and the successor of the current
+// refresh token in its family.
+func (a *Auth) rotateTokens(ctx context.Context, user model.User, app model.App, current model.RefreshToken) (model.TokenPair, error) {
+	roles, err := a.st.UserRoles(ctx, current.UserID, current.AppID)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	accessToken, err := a.newAccessToken(ctx, user, app, roles)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	next, refreshToken, err := a.newRefreshToken(user, app, current.FamilyID)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	if err := a.st.RotateRefreshToken(ctx, current.ID, next); err != nil {
+		return model.TokenPair{}, err
+	}
+
+	return model.TokenPair{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresIn: a.accessTokenTTL(app)}, nil
+}
+
+// issueTokens creates an access token and a refresh token starting a new
+// refresh token family. The family is named after the first token unless
+// familyID is set.
+func (a *Auth) issueTokens(ctx context.Context, user model.User, app model.App, familyID string) (model.TokenPair, error) {
+	roles, err := a.st.UserRoles(ctx, int64(user.ID), int64(app.ID))
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	accessToken, err := a.newAccessToken(ctx, user, app, roles)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	rt, refreshToken, err := a.newRefreshToken(user, app, familyID)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	if err := a.st.SaveRefreshToken(ctx, rt); err != nil {
+		return model.TokenPair{}, err
+	}
+
+	return model.TokenPair{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresIn: a.accessTokenTTL(app)}, nil
+}
+
+// newAccessToken signs an access token for the user in the app.
+func (a *Auth) newAccessToken(ctx context.Context, user model.User, app model.App, roles []string) (string, error) {
+	return signToken(ctx, func() (string, error) {
+		return jwt.NewToken(a.keys, a.cfg.JWT.Issuer, user, app, roles, a.accessTokenTTL(app))
+	})
+}
+
+func (a *Auth) newRefreshToken(user model.User, app model.App, familyID string) (model.RefreshToken, string, error) {
+	token, hash, err := opaque.New()
+	if err != nil {
+		return model.RefreshToken{}, "", err
+	}
+
+	if familyID == "" {
+		// The hash of the first token is as unique as the token itself.
+		familyID = hash
+	}
+
+	return model.RefreshToken{
+		TokenHash: hash,
+		FamilyID:  familyID,
+		UserID:    int64(user.ID),
+		AppID:     int64(app.ID),
+		ExpiresAt: time.Now().Add(a.refreshTokenTTL(app)),
+	}, token, nil
+}
+
+// accessTokenTTL returns the lifetime of access tokens issued for the app.
+func (a *Auth) accessTokenTTL(app model.App) time.Duration {
+	if app.AccessTokenTTL > 0 {
+		return app.AccessTokenTTL
+	}
+
+	return a.cfg.TokenTTL
+}
+
+// refreshTokenTTL returns the lifetime of refresh tokens issued for the app.
+func (a *Auth) refreshTokenTTL(app model.App) time.Duration {
+	if app.RefreshTokenTTL > 0 {
+		return app.RefreshTokenTTL
+	}
+
+	return a.cfg.RefreshTokenTTL
+}
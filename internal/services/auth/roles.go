@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+// AdminRole is the role checked by IsAdmin.
+const AdminRole = "admin"
+
+// GlobalAppID assigns a role in every app.
+const GlobalAppID = 0
+
+var ErrRoleNotFound = errors.New("role not found")
+
+// GrantRole assigns the role to the user in the app. Use GlobalAppID to
+// grant it in every app.
+func (a *Auth) GrantRole(ctx context.Context, userID, appID int64, role string) (err error) {
+	const op = "auth.GrantRole"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	event := model.AuditEvent{Type: model.AuditRoleGranted, TargetID: userID, AppID: appID, Detail: role}
+	defer func() { a.recordOutcome(ctx, event, err) }()
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+		slog.Int64("app_id", appID),
+		slog.String("role", role),
+	)
+
+	if err := a.ensureUserAndApp(ctx, userID, appID); err != nil {
+		log.Warn("failed to grant role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.st.GrantRole(ctx, userID, appID, role); err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			log.Warn("role not found", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, ErrRoleNotFound)
+		}
+
+		log.Error("failed to grant role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("role granted")
+
+	return nil
+}
+
+// RevokeRole removes the role of the user in the app.
+func (a *Auth) RevokeRole(ctx context.Context, userID, appID int64, role string) (err error) {
+	const op = "auth.RevokeRole"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	event := model.AuditEvent{Type: model.AuditRoleRevoked, TargetID: userID, AppID: appID, Detail: role}
+	defer func() { a.recordOutcome(ctx, event, err) }()
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+		slog.Int64("app_id", appID),
+		slog.String("role", role),
+	)
+
+	if err := a.st.RevokeRole(ctx, userID, appID, role); err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			log.Warn("role not found", sl.Err(err))
+
+			return fmt.Errorf("%s: %w", op, ErrRoleNotFound)
+		}
+
+		log.Error("failed to revoke role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("role revoked")
+
+	return nil
+}
+
+// CheckPermission reports whether any role of the user in the app grants
+// the permission.
+func (a *Auth) CheckPermission(ctx context.Context, userID, appID int64, permission string) (bool, error) {
+	const op = "auth.CheckPermission"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+		slog.Int64("app_id", appID),
+		slog.String("permission", permission),
+	)
+
+	allowed, err := a.st.HasPermission(ctx, userID, appID, permission)
+	if err != nil {
+		log.Error("failed to check permission", sl.Err(err))
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return allowed, nil
+}
+
+// IsAdmin reports whether the user holds the admin role in every app. It is
+// kept for clients of the IsAdmin RPC; new code should use CheckPermission.
+func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	const op = "auth.IsAdmin"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer span.End()
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", userID))
+
+	if _, err := a.st.UserByID(ctx, userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	roles, err := a.st.UserRoles(ctx, userID, GlobalAppID)
+	if err != nil {
+		log.Error("failed to check if user is admin", sl.Err(err))
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	isAdmin := slices.Contains(roles, AdminRole)
+
+	log.Info("user is admin", slog.Bool("is_admin", isAdmin))
+
+	return isAdmin, nil
+}
+
+func (a *Auth) ensureUserAndApp(ctx context.Context, userID, appID int64) error {
+	if _, err := a.st.UserByID(ctx, userID); err != nil {
+		return err
+	}
+
+	if appID == GlobalAppID {
+		return nil
+	}
+
+	if _, err := a.st.App(ctx, appID); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return ErrInvalidAppID
+		}
+
+		return err
+	}
+
+	return nil
+}
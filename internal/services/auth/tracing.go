@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/JSONStatham/sso/internal/services/auth")
+
+// endSpan marks the span as failed if err is set and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// hashPassword hashes the password in its own span, hashing is slow on
+// purpose.
+func (a *Auth) hashPassword(ctx context.Context, password string) (hash []byte, err error) {
+	_, span := tracer.Start(ctx, "password.Hash")
+	defer func() { endSpan(span, err) }()
+
+	return a.hasher.Hash(password)
+}
+
+// signToken signs the token created by sign in its own span.
+func signToken(ctx context.Context, sign func() (string, error)) (token string, err error) {
+	_, span := tracer.Start(ctx, "jwt.Sign")
+	defer func() { endSpan(span, err) }()
+
+	return sign()
+}
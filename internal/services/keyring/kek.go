@@ -0,0 +1,128 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sealedPrefix starts private keys encrypted by a KEK. Keys stored before
+// encryption was introduced are plain PEM.
+const sealedPrefix = "$aes256gcm$"
+
+var (
+	ErrNoKeyEncryptionKey = errors.New("no key encryption key configured")
+	ErrUnknownKEK         = errors.New("key was encrypted with another key encryption key")
+)
+
+// b64 is the encoding of binary fields of sealed keys.
+var b64 = base64.RawStdEncoding
+
+// KEK is the key encryption key private signing keys are encrypted with
+// before they are stored. It is kept out of the database, so a leaked
+// database alone does not allow forging tokens. Sealed keys look like
+// "$aes256gcm$keyid=<id>$<nonce and ciphertext>", where the id identifies
+// the KEK.
+type KEK struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// LoadKEK reads the secret the KEK is derived from from the file at path.
+func LoadKEK(path string) (*KEK, error) {
+	const op = "keyring.LoadKEK"
+
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	kek, err := NewKEK(bytes.TrimSpace(secret))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return kek, nil
+}
+
+// NewKEK derives the KEK from a secret of at least 16 bytes.
+func NewKEK(secret []byte) (*KEK, error) {
+	const op = "keyring.NewKEK"
+
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("%s: key encryption key must be at least 16 bytes long", op)
+	}
+
+	key, err := hkdf.Key(sha256.New, secret, nil, "sso signing key encryption", 32)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The id is derived separately so that it reveals nothing about the key.
+	id, err := hkdf.Key(sha256.New, secret, nil, "sso signing key encryption id", 6)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &KEK{id: b64.EncodeToString(id), aead: aead}, nil
+}
+
+// seal encrypts the PEM encoded private key. The key id is authenticated
+// along with it, so sealed keys cannot be swapped between rows.
+func (k *KEK) seal(kid string, pemKey []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := k.aead.Seal(nonce, nonce, pemKey, []byte(kid))
+
+	return []byte(sealedPrefix + "keyid=" + k.id + "$" + b64.EncodeToString(sealed)), nil
+}
+
+// open decrypts a key sealed by seal.
+func (k *KEK) open(kid string, sealed []byte) ([]byte, error) {
+	fields := strings.Split(strings.TrimPrefix(string(sealed), sealedPrefix), "$")
+	if len(fields) != 2 || !strings.HasPrefix(fields[0], "keyid=") {
+		return nil, errors.New("malformed sealed key")
+	}
+
+	if strings.TrimPrefix(fields[0], "keyid=") != k.id {
+		return nil, ErrUnknownKEK
+	}
+
+	data, err := b64.DecodeString(fields[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < k.aead.NonceSize() {
+		return nil, errors.New("malformed sealed key")
+	}
+
+	nonce, ciphertext := data[:k.aead.NonceSize()], data[k.aead.NonceSize():]
+
+	return k.aead.Open(nil, nonce, ciphertext, []byte(kid))
+}
+
+func isSealed(privateKey []byte) bool {
+	return bytes.HasPrefix(privateKey, []byte(sealedPrefix))
+}
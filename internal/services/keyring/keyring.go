@@ -0,0 +1,395 @@
+package keyring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/utils/jwt"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+)
+
+const (
+	loadTimeout = 5 * time.Second
+	// minReload limits reloads triggered by unknown key ids.
+	minReload = 5 * time.Second
+)
+
+var ErrNoSigningKey = errors.New("no active signing key")
+
+type Storage interface {
+	SigningKeys(ctx context.Context) ([]model.SigningKey, error)
+	SaveSigningKey(ctx context.Context, key model.SigningKey) error
+	ActivateSigningKey(ctx context.Context, kid string, at time.Time) error
+	RetireSigningKey(ctx context.Context, kid string, at time.Time) error
+	UpdateSigningKeyPrivateKey(ctx context.Context, kid string, privateKey []byte) error
+}
+
+// KeyRing implements jwt.Keys on top of the keys persisted in storage.
+//
+// The key with the latest activation time that has passed signs new tokens.
+// Every key that has not reached its retirement time is accepted for
+// verification, so tokens signed by the previous key stay valid while it is
+// being rotated out. An optional fallback key, usually loaded from the
+// config, signs tokens while storage holds no active key. Once the fallback
+// key is imported into storage, the stored activation and retirement times
+// take precedence.
+//
+// Private keys are encrypted with the KEK before they are stored. Keys are
+// only stored if a KEK is configured.
+type KeyRing struct {
+	log      *slog.Logger
+	st       Storage
+	kek      *KEK
+	fallback *jwt.Key
+	refresh  time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	keys     []ringKey
+	loadedAt time.Time
+}
+
+type ringKey struct {
+	key         jwt.Key
+	activatesAt *time.Time
+	retiresAt   *time.Time
+}
+
+func New(log *slog.Logger, st Storage, kek *KEK, fallback *jwt.Key, refresh time.Duration) *KeyRing {
+	return &KeyRing{
+		log:      log,
+		st:       st,
+		kek:      kek,
+		fallback: fallback,
+		refresh:  refresh,
+		now:      time.Now,
+	}
+}
+
+func (r *KeyRing) SigningKey() (jwt.Key, error) {
+	keys, err := r.load(false)
+	if err != nil {
+		return jwt.Key{}, err
+	}
+
+	now := r.now()
+
+	var active *ringKey
+	for i, k := range keys {
+		if !k.canSign(now) {
+			continue
+		}
+		if active == nil || k.activatesAt.After(*active.activatesAt) {
+			active = &keys[i]
+		}
+	}
+
+	if active != nil {
+		return active.key, nil
+	}
+
+	if fallback, ok := r.fallbackKey(keys); ok {
+		return fallback, nil
+	}
+
+	return jwt.Key{}, ErrNoSigningKey
+}
+
+func (r *KeyRing) VerificationKey(kid string) (jwt.Key, error) {
+	keys, err := r.load(false)
+	if err != nil {
+		return jwt.Key{}, err
+	}
+
+	key, found, retired := r.find(keys, kid)
+	if !found && !retired {
+		// The key may have been added after the last load.
+		if keys, err = r.load(true); err != nil {
+			return jwt.Key{}, err
+		}
+		key, found, retired = r.find(keys, kid)
+	}
+
+	if retired {
+		return jwt.Key{}, fmt.Errorf("%w: key %q is retired", jwt.ErrKeyNotFound, kid)
+	}
+	if found {
+		return key, nil
+	}
+
+	if fallback, ok := r.fallbackKey(keys); ok && fallback.ID == kid {
+		return fallback, nil
+	}
+
+	return jwt.Key{}, jwt.ErrKeyNotFound
+}
+
+func (r *KeyRing) PublicKeys() ([]jwt.Key, error) {
+	keys, err := r.load(false)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.now()
+
+	var public []jwt.Key
+	for _, k := range keys {
+		if k.canVerify(now) {
+			public = append(public, k.key)
+		}
+	}
+
+	if fallback, ok := r.fallbackKey(keys); ok {
+		public = append(public, fallback)
+	}
+
+	return public, nil
+}
+
+// Generate creates a new key for the algorithm and stores it. A zero
+// activatesAt stores the key without activating it, which lets resource
+// servers pick it up from the JWKS before it is used for signing.
+func (r *KeyRing) Generate(ctx context.Context, alg string, activatesAt time.Time) (jwt.Key, error) {
+	const op = "keyring.Generate"
+
+	key, err := jwt.GenerateKey(alg)
+	if err != nil {
+		return jwt.Key{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := r.Import(ctx, key, activatesAt); err != nil {
+		return jwt.Key{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// Import stores an existing key, e.g. the one previously configured with
+// jwt.private_key_path.
+func (r *KeyRing) Import(ctx context.Context, key jwt.Key, activatesAt time.Time) error {
+	const op = "keyring.Import"
+
+	if r.kek == nil {
+		return fmt.Errorf("%s: %w", op, ErrNoKeyEncryptionKey)
+	}
+
+	pemKey, err := jwt.MarshalPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sealed, err := r.kek.seal(key.ID, pemKey)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	signingKey := model.SigningKey{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: sealed,
+	}
+	if !activatesAt.IsZero() {
+		signingKey.ActivatesAt = &activatesAt
+	}
+
+	if err := r.st.SaveSigningKey(ctx, signingKey); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	r.invalidate()
+
+	return nil
+}
+
+// Activate makes the key the signing key from the given time on.
+func (r *KeyRing) Activate(ctx context.Context, kid string, at time.Time) error {
+	const op = "keyring.Activate"
+
+	if err := r.st.ActivateSigningKey(ctx, kid, at); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	r.invalidate()
+
+	return nil
+}
+
+// Retire stops accepting tokens signed by the key from the given time on.
+// To avoid logging users out it should be at least one token TTL after the
+// key stopped signing.
+func (r *KeyRing) Retire(ctx context.Context, kid string, at time.Time) error {
+	const op = "keyring.Retire"
+
+	if err := r.st.RetireSigningKey(ctx, kid, at); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	r.invalidate()
+
+	return nil
+}
+
+// Seal encrypts the keys stored before private keys were encrypted and
+// returns how many there were.
+func (r *KeyRing) Seal(ctx context.Context) (int, error) {
+	const op = "keyring.Seal"
+
+	if r.kek == nil {
+		return 0, fmt.Errorf("%s: %w", op, ErrNoKeyEncryptionKey)
+	}
+
+	keys, err := r.st.SigningKeys(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sealed := 0
+	for _, key := range keys {
+		if isSealed(key.PrivateKey) {
+			continue
+		}
+
+		privateKey, err := r.kek.seal(key.ID, key.PrivateKey)
+		if err != nil {
+			return sealed, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if err := r.st.UpdateSigningKeyPrivateKey(ctx, key.ID, privateKey); err != nil {
+			return sealed, fmt.Errorf("%s: key %q: %w", op, key.ID, err)
+		}
+
+		sealed++
+	}
+
+	r.invalidate()
+
+	return sealed, nil
+}
+
+// Keys returns every stored key, including retired ones.
+func (r *KeyRing) Keys(ctx context.Context) ([]model.SigningKey, error) {
+	const op = "keyring.Keys"
+
+	keys, err := r.st.SigningKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+// fallbackKey returns the fallback key unless it has been imported into
+// storage.
+func (r *KeyRing) fallbackKey(keys []ringKey) (jwt.Key, bool) {
+	if r.fallback == nil {
+		return jwt.Key{}, false
+	}
+
+	for _, k := range keys {
+		if k.key.ID == r.fallback.ID {
+			return jwt.Key{}, false
+		}
+	}
+
+	return *r.fallback, true
+}
+
+func (r *KeyRing) find(keys []ringKey, kid string) (key jwt.Key, found, retired bool) {
+	now := r.now()
+
+	for _, k := range keys {
+		if k.key.ID != kid {
+			continue
+		}
+
+		if !k.canVerify(now) {
+			return jwt.Key{}, false, true
+		}
+
+		return k.key, true, false
+	}
+
+	return jwt.Key{}, false, false
+}
+
+func (r *KeyRing) load(force bool) ([]ringKey, error) {
+	const op = "keyring.load"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	since := r.now().Sub(r.loadedAt)
+	if !r.loadedAt.IsZero() && (since < r.refresh && !force || since < minReload) {
+		return r.keys, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+
+	stored, err := r.st.SigningKeys(ctx)
+	if err != nil {
+		if r.loadedAt.IsZero() {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		// Keep serving the last known keys rather than failing every request.
+		r.log.With(slog.String("op", op)).Error("failed to reload signing keys", sl.Err(err))
+		return r.keys, nil
+	}
+
+	keys := make([]ringKey, 0, len(stored))
+	for _, sk := range stored {
+		key, err := r.parse(sk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", op, sk.ID, err)
+		}
+
+		keys = append(keys, ringKey{key: key, activatesAt: sk.ActivatesAt, retiresAt: sk.RetiresAt})
+	}
+
+	r.keys = keys
+	r.loadedAt = r.now()
+
+	return keys, nil
+}
+
+// parse decrypts the private key of the stored key. Keys stored before
+// private keys were encrypted are accepted until they are sealed.
+func (r *KeyRing) parse(sk model.SigningKey) (jwt.Key, error) {
+	pemKey := sk.PrivateKey
+
+	if isSealed(pemKey) {
+		if r.kek == nil {
+			return jwt.Key{}, ErrNoKeyEncryptionKey
+		}
+
+		var err error
+		if pemKey, err = r.kek.open(sk.ID, pemKey); err != nil {
+			return jwt.Key{}, err
+		}
+	} else {
+		r.log.Warn("signing key is stored unencrypted, seal it with the keys command", slog.String("kid", sk.ID))
+	}
+
+	return jwt.ParsePrivateKey(sk.ID, pemKey)
+}
+
+func (r *KeyRing) invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.loadedAt = time.Time{}
+}
+
+func (k ringKey) canSign(now time.Time) bool {
+	return k.activatesAt != nil && !k.activatesAt.After(now) && k.canVerify(now)
+}
+
+func (k ringKey) canVerify(now time.Time) bool {
+	return k.retiresAt == nil || now.Before(*k.retiresAt)
+}
@@ -0,0 +1,245 @@
+package keyring
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/storage"
+	"github.com/JSONStatham/sso/internal/utils/jwt"
+	slogdiscard "github.com/JSONStatham/sso/internal/utils/logger/sl/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRing_Rotation(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	fallback, err := jwt.GenerateKey(jwt.AlgEdDSA)
+	require.NoError(t, err)
+
+	ring := New(slogdiscard.NewDiscardLogger(), newFakeStorage(), testKEK(t), &fallback, time.Minute)
+	ring.now = func() time.Time { return now }
+
+	// Without stored keys the fallback key signs
+	signing, err := ring.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, fallback.ID, signing.ID)
+
+	// A generated but not yet active key is published but does not sign
+	next, err := ring.Generate(ctx, jwt.AlgES256, time.Time{})
+	require.NoError(t, err)
+
+	signing, err = ring.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, fallback.ID, signing.ID)
+	assert.ElementsMatch(t, []string{fallback.ID, next.ID}, kids(t, ring))
+
+	// Once activated, the new key signs and the fallback still verifies
+	require.NoError(t, ring.Activate(ctx, next.ID, now.Add(-time.Second)))
+
+	signing, err = ring.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, next.ID, signing.ID)
+
+	_, err = ring.VerificationKey(fallback.ID)
+	require.NoError(t, err)
+
+	// A newer activation takes over, the previous key stays valid until retired
+	newest, err := ring.Generate(ctx, jwt.AlgRS256, now)
+	require.NoError(t, err)
+
+	signing, err = ring.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, newest.ID, signing.ID)
+
+	_, err = ring.VerificationKey(next.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, ring.Retire(ctx, next.ID, now.Add(time.Hour)))
+	_, err = ring.VerificationKey(next.ID)
+	require.NoError(t, err, "Key should verify until its retirement time")
+
+	now = now.Add(2 * time.Hour)
+	_, err = ring.VerificationKey(next.ID)
+	require.ErrorIs(t, err, jwt.ErrKeyNotFound, "Fully retired key should not verify")
+	assert.NotContains(t, kids(t, ring), next.ID)
+}
+
+func TestKeyRing_ImportedFallback(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	fallback, err := jwt.GenerateKey(jwt.AlgEdDSA)
+	require.NoError(t, err)
+
+	ring := New(slogdiscard.NewDiscardLogger(), newFakeStorage(), testKEK(t), &fallback, time.Minute)
+	ring.now = func() time.Time { return now }
+
+	require.NoError(t, ring.Import(ctx, fallback, now))
+	require.NoError(t, ring.Retire(ctx, fallback.ID, now))
+
+	// The stored retirement wins over the configured key
+	_, err = ring.VerificationKey(fallback.ID)
+	require.ErrorIs(t, err, jwt.ErrKeyNotFound)
+
+	_, err = ring.SigningKey()
+	require.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestKeyRing_ReloadsUnknownKid(t *testing.T) {
+	ctx := context.Background()
+	st := newFakeStorage()
+
+	ring := New(slogdiscard.NewDiscardLogger(), st, testKEK(t), nil, time.Hour)
+	_, err := ring.PublicKeys()
+	require.NoError(t, err)
+
+	// Another instance adds a key behind the ring's back
+	other := New(slogdiscard.NewDiscardLogger(), st, testKEK(t), nil, time.Hour)
+	key, err := other.Generate(ctx, jwt.AlgEdDSA, time.Now())
+	require.NoError(t, err)
+
+	ring.loadedAt = time.Now().Add(-minReload)
+
+	found, err := ring.VerificationKey(key.ID)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+}
+
+func TestKeyRing_EncryptsPrivateKeys(t *testing.T) {
+	ctx := context.Background()
+	st := newFakeStorage()
+
+	ring := New(slogdiscard.NewDiscardLogger(), st, testKEK(t), nil, time.Hour)
+	key, err := ring.Generate(ctx, jwt.AlgEdDSA, time.Now())
+	require.NoError(t, err)
+
+	require.Len(t, st.keys, 1)
+	assert.NotContains(t, string(st.keys[0].PrivateKey), "PRIVATE KEY")
+
+	signing, err := ring.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, signing.ID)
+
+	// Keys are not stored without a KEK
+	_, err = New(slogdiscard.NewDiscardLogger(), st, nil, nil, time.Hour).Generate(ctx, jwt.AlgEdDSA, time.Now())
+	require.ErrorIs(t, err, ErrNoKeyEncryptionKey)
+
+	other, err := NewKEK([]byte("another-key-encryption-key"))
+	require.NoError(t, err)
+
+	_, err = New(slogdiscard.NewDiscardLogger(), st, other, nil, time.Hour).SigningKey()
+	require.ErrorIs(t, err, ErrUnknownKEK)
+
+	// A sealed key cannot be moved to another row
+	st.keys[0].ID = "other"
+	_, err = New(slogdiscard.NewDiscardLogger(), st, testKEK(t), nil, time.Hour).SigningKey()
+	require.Error(t, err)
+}
+
+func TestKeyRing_SealsPlaintextKeys(t *testing.T) {
+	ctx := context.Background()
+	st := newFakeStorage()
+
+	key, err := jwt.GenerateKey(jwt.AlgES256)
+	require.NoError(t, err)
+
+	pemKey, err := jwt.MarshalPrivateKey(key)
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, st.SaveSigningKey(ctx, model.SigningKey{ID: key.ID, Algorithm: key.Algorithm, PrivateKey: pemKey, ActivatesAt: &now}))
+
+	ring := New(slogdiscard.NewDiscardLogger(), st, testKEK(t), nil, time.Hour)
+
+	// Keys stored before encryption keep signing until they are sealed
+	signing, err := ring.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, signing.ID)
+
+	sealed, err := ring.Seal(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sealed)
+	assert.NotEqual(t, pemKey, st.keys[0].PrivateKey)
+
+	signing, err = ring.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, signing.ID)
+
+	sealed, err = ring.Seal(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, sealed)
+}
+
+func testKEK(t *testing.T) *KEK {
+	t.Helper()
+
+	kek, err := NewKEK([]byte("test-key-encryption-key"))
+	require.NoError(t, err)
+
+	return kek
+}
+
+func kids(t *testing.T, ring *KeyRing) []string {
+	t.Helper()
+
+	keys, err := ring.PublicKeys()
+	require.NoError(t, err)
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.ID)
+	}
+
+	return ids
+}
+
+type fakeStorage struct {
+	keys []model.SigningKey
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{}
+}
+
+func (s *fakeStorage) SigningKeys(_ context.Context) ([]model.SigningKey, error) {
+	return append([]model.SigningKey(nil), s.keys...), nil
+}
+
+func (s *fakeStorage) SaveSigningKey(_ context.Context, key model.SigningKey) error {
+	for _, k := range s.keys {
+		if k.ID == key.ID {
+			return storage.ErrSigningKeyAlreadyExists
+		}
+	}
+
+	s.keys = append(s.keys, key)
+
+	return nil
+}
+
+func (s *fakeStorage) ActivateSigningKey(_ context.Context, kid string, at time.Time) error {
+	return s.update(kid, func(k *model.SigningKey) { k.ActivatesAt = &at })
+}
+
+func (s *fakeStorage) RetireSigningKey(_ context.Context, kid string, at time.Time) error {
+	return s.update(kid, func(k *model.SigningKey) { k.RetiresAt = &at })
+}
+
+func (s *fakeStorage) UpdateSigningKeyPrivateKey(_ context.Context, kid string, privateKey []byte) error {
+	return s.update(kid, func(k *model.SigningKey) { k.PrivateKey = privateKey })
+}
+
+func (s *fakeStorage) update(kid string, fn func(k *model.SigningKey)) error {
+	for i := range s.keys {
+		if s.keys[i].ID == kid {
+			fn(&s.keys[i])
+			return nil
+		}
+	}
+
+	return storage.ErrSigningKeyNotFound
+}
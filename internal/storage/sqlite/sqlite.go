@@ -5,14 +5,19 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/JSONStatham/sso/internal/domain/model"
 	"github.com/JSONStatham/sso/internal/storage"
 	"github.com/mattn/go-sqlite3"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 type Storage struct {
-	db *sql.DB
+	db      *storage.DB
+	auditMu sync.Mutex
 }
 
 func New(storagePah string) (*Storage, error) {
@@ -23,13 +28,49 @@ func New(storagePah string) (*Storage, error) {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	return &Storage{db: storage.NewDB(db, semconv.DBSystemSqlite)}, nil
 }
 
 func (s *Storage) Close() error {
 	return s.db.Close()
 }
 
+// Stats returns the stats of the connection pool.
+func (s *Storage) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Ping checks that the database is reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "storage.sqlite.Ping"
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MigrationVersion returns the schema version golang-migrate recorded in
+// table. dirty is set if the last migration failed half way.
+func (s *Storage) MigrationVersion(ctx context.Context, table string) (version uint, dirty bool, err error) {
+	const op = "storage.sqlite.MigrationVersion"
+
+	// Identifiers cannot be bound as arguments.
+	query := fmt.Sprintf(`SELECT version, dirty FROM "%s" LIMIT 1`, strings.ReplaceAll(table, `"`, `""`))
+
+	err = s.db.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, fmt.Errorf("%s: %w", op, storage.ErrNotMigrated)
+		}
+
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, dirty, nil
+}
+
 func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (int64, error) {
 	const op = "storage.sqlite.SaveUser"
 
@@ -54,11 +95,11 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (
 func (s *Storage) User(ctx context.Context, email string) (model.User, error) {
 	const op = "storage.sqlite.User"
 
-	query := "SELECT id, email, password, created_at FROM users WHERE email = ?"
+	query := "SELECT id, email, password, email_verified, token_version, created_at FROM users WHERE email = ?"
 	row := s.db.QueryRowContext(ctx, query, email)
 
 	var user model.User
-	err := row.Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt)
+	err := row.Scan(&user.ID, &user.Email, &user.Password, &user.EmailVerified, &user.TokenVersion, &user.CreatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return model.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -70,33 +111,35 @@ func (s *Storage) User(ctx context.Context, email string) (model.User, error) {
 	return user, nil
 }
 
-func (s *Storage) IsAdmin(ctx context.Context, uid int64) (bool, error) {
-	const op = "sqlite.IsAdmin"
+func (s *Storage) UserByID(ctx context.Context, uid int64) (model.User, error) {
+	const op = "storage.sqlite.UserByID"
 
-	query := "SELECT is_admin FROM users WHERE id = ?"
+	query := "SELECT id, email, password, email_verified, token_version, created_at FROM users WHERE id = ?"
 	row := s.db.QueryRowContext(ctx, query, uid)
 
-	var app model.App
-	err := row.Scan(&app.ID, &app.Name, &app.CreatedAt)
+	var user model.User
+	err := row.Scan(&user.ID, &user.Email, &user.Password, &user.EmailVerified, &user.TokenVersion, &user.CreatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			return model.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
 		}
 
-		return false, fmt.Errorf("%s: %w", op, err)
+		return model.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return true, nil
+	return user, nil
 }
 
+// appColumns are the columns scanned by scanApp.
+const appColumns = `id, name, secret_hash, require_mfa, require_verified_email, client_type,
+	access_token_ttl, refresh_token_ttl, grant_types, created_at`
+
 func (s *Storage) App(ctx context.Context, appID int64) (model.App, error) {
 	const op = "sqlite.App"
 
-	query := "SELECT id, name, created_at FROM apps WHERE id = ?"
-	row := s.db.QueryRowContext(ctx, query, appID)
+	row := s.db.QueryRowContext(ctx, "SELECT "+appColumns+" FROM apps WHERE id = ?", appID)
 
-	var app model.App
-	err := row.Scan(&app.ID, &app.Name, &app.CreatedAt)
+	app, err := scanApp(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return model.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
@@ -108,6 +151,54 @@ func (s *Storage) App(ctx context.Context, appID int64) (model.App, error) {
 	return app, nil
 }
 
+func (s *Storage) Apps(ctx context.Context) ([]model.App, error) {
+	const op = "sqlite.Apps"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+appColumns+" FROM apps ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var apps []model.App
+	for rows.Next() {
+		app, err := scanApp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		apps = append(apps, app)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return apps, nil
+}
+
+func scanApp(row interface{ Scan(dest ...any) error }) (model.App, error) {
+	var (
+		app             model.App
+		secretHash      sql.NullString
+		accessTokenTTL  sql.NullInt64
+		refreshTokenTTL sql.NullInt64
+		grantTypes      string
+	)
+	err := row.Scan(&app.ID, &app.Name, &secretHash, &app.RequireMFA, &app.RequireVerifiedEmail, &app.ClientType,
+		&accessTokenTTL, &refreshTokenTTL, &grantTypes, &app.CreatedAt)
+	if err != nil {
+		return model.App{}, err
+	}
+
+	app.SecretHash = secretHash.String
+	app.AccessTokenTTL = time.Duration(accessTokenTTL.Int64) * time.Second
+	app.RefreshTokenTTL = time.Duration(refreshTokenTTL.Int64) * time.Second
+	app.GrantTypes = strings.Fields(grantTypes)
+
+	return app, nil
+}
+
 func (s *Storage) CreateApp(ctx context.Context, name string) (int64, error) {
 	const op = "sqlite.CreateApp"
 
@@ -128,3 +219,1334 @@ func (s *Storage) CreateApp(ctx context.Context, name string) (int64, error) {
 
 	return appID, nil
 }
+
+// SaveApp creates an app with the settings of app and returns its id.
+func (s *Storage) SaveApp(ctx context.Context, app model.App) (int64, error) {
+	const op = "sqlite.SaveApp"
+
+	query := `INSERT INTO apps (name, require_mfa, require_verified_email, client_type,
+		access_token_ttl, refresh_token_ttl, grant_types) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := s.db.ExecContext(ctx, query, app.Name, app.RequireMFA, app.RequireVerifiedEmail, app.ClientType,
+		nullSeconds(app.AccessTokenTTL), nullSeconds(app.RefreshTokenTTL), strings.Join(app.GrantTypes, " "))
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppAlreadyExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	appID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return appID, nil
+}
+
+// UpdateApp replaces the settings of the app with those of app. The
+// secret and redirect URIs are kept.
+func (s *Storage) UpdateApp(ctx context.Context, app model.App) error {
+	const op = "sqlite.UpdateApp"
+
+	query := `UPDATE apps SET name = ?, require_mfa = ?, require_verified_email = ?, client_type = ?,
+		access_token_ttl = ?, refresh_token_ttl = ?, grant_types = ? WHERE id = ?`
+	res, err := s.db.ExecContext(ctx, query, app.Name, app.RequireMFA, app.RequireVerifiedEmail, app.ClientType,
+		nullSeconds(app.AccessTokenTTL), nullSeconds(app.RefreshTokenTTL), strings.Join(app.GrantTypes, " "), app.ID)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+			return fmt.Errorf("%s: %w", op, storage.ErrAppAlreadyExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// DeleteApp deletes the app together with its tokens, codes and roles.
+func (s *Storage) DeleteApp(ctx context.Context, appID int64) error {
+	const op = "sqlite.DeleteApp"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{
+		"authorization_codes", "app_redirect_uris", "mfa_challenges", "refresh_tokens", "user_roles",
+	} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE app_id = ?", appID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM apps WHERE id = ?", appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SetAppRedirectURIs replaces the redirect URIs of the app.
+func (s *Storage) SetAppRedirectURIs(ctx context.Context, appID int64, redirectURIs []string) error {
+	const op = "sqlite.SetAppRedirectURIs"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM apps WHERE id = ?)", appID).Scan(&exists); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM app_redirect_uris WHERE app_id = ?", appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := "INSERT INTO app_redirect_uris (app_id, redirect_uri) VALUES (?, ?) ON CONFLICT DO NOTHING"
+	for _, uri := range redirectURIs {
+		if _, err := tx.ExecContext(ctx, query, appID, uri); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetAppSecret(ctx context.Context, appID int64, secretHash string) error {
+	const op = "sqlite.SetAppSecret"
+
+	res, err := s.db.ExecContext(ctx, "UPDATE apps SET secret_hash = ? WHERE id = ?", secretHash, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) GrantRole(ctx context.Context, uid, appID int64, role string) error {
+	const op = "sqlite.GrantRole"
+
+	roleID, err := s.roleID(ctx, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := "INSERT OR IGNORE INTO user_roles (user_id, app_id, role_id) VALUES (?, ?, ?)"
+	if _, err := s.db.ExecContext(ctx, query, uid, appID, roleID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeRole(ctx context.Context, uid, appID int64, role string) error {
+	const op = "sqlite.RevokeRole"
+
+	roleID, err := s.roleID(ctx, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := "DELETE FROM user_roles WHERE user_id = ? AND app_id = ? AND role_id = ?"
+	if _, err := s.db.ExecContext(ctx, query, uid, appID, roleID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UserRoles returns the roles of the user in the app, including roles
+// granted in every app.
+func (s *Storage) UserRoles(ctx context.Context, uid, appID int64) ([]string, error) {
+	const op = "sqlite.UserRoles"
+
+	query := `SELECT DISTINCT r.name FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = ? AND ur.app_id IN (?, 0)
+		ORDER BY r.name`
+	rows, err := s.db.QueryContext(ctx, query, uid, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+func (s *Storage) HasPermission(ctx context.Context, uid, appID int64, permission string) (bool, error) {
+	const op = "sqlite.HasPermission"
+
+	query := `SELECT EXISTS(SELECT 1 FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = ? AND ur.app_id IN (?, 0) AND p.name = ?)`
+	row := s.db.QueryRowContext(ctx, query, uid, appID, permission)
+
+	var allowed bool
+	if err := row.Scan(&allowed); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return allowed, nil
+}
+
+func (s *Storage) roleID(ctx context.Context, role string) (int64, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT id FROM roles WHERE name = ?", role)
+
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, storage.ErrRoleNotFound
+		}
+
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "sqlite.RevokeToken"
+
+	query := "INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)"
+	if _, err := s.db.ExecContext(ctx, query, jti, expiresAt.UTC()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "sqlite.IsTokenRevoked"
+
+	query := "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)"
+	row := s.db.QueryRowContext(ctx, query, jti)
+
+	var revoked bool
+	if err := row.Scan(&revoked); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+func (s *Storage) DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int64, error) {
+	const op = "sqlite.DeleteExpiredRevokedTokens"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	const op = "sqlite.SaveRefreshToken"
+
+	query := "INSERT INTO refresh_tokens (token_hash, family_id, user_id, app_id, expires_at) VALUES (?, ?, ?, ?, ?)"
+	_, err := s.db.ExecContext(ctx, query, token.TokenHash, token.FamilyID, token.UserID, token.AppID, token.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken, error) {
+	const op = "sqlite.RefreshToken"
+
+	query := `SELECT id, token_hash, family_id, user_id, app_id, expires_at, rotated_at, revoked_at, created_at
+		FROM refresh_tokens WHERE token_hash = ?`
+	row := s.db.QueryRowContext(ctx, query, tokenHash)
+
+	var (
+		token     model.RefreshToken
+		rotatedAt sql.NullTime
+		revokedAt sql.NullTime
+	)
+	err := row.Scan(
+		&token.ID, &token.TokenHash, &token.FamilyID, &token.UserID, &token.AppID,
+		&token.ExpiresAt, &rotatedAt, &revokedAt, &token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.RefreshToken{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+		}
+
+		return model.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if rotatedAt.Valid {
+		token.RotatedAt = &rotatedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken marks the old token as used and stores its successor
+// in a single transaction. It fails with storage.ErrRefreshTokenRotated if
+// the old token has already been rotated or revoked.
+func (s *Storage) RotateRefreshToken(ctx context.Context, oldID int64, next model.RefreshToken) error {
+	const op = "sqlite.RotateRefreshToken"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET rotated_at = ? WHERE id = ? AND rotated_at IS NULL AND revoked_at IS NULL",
+		time.Now().UTC(), oldID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenRotated)
+	}
+
+	query := "INSERT INTO refresh_tokens (token_hash, family_id, user_id, app_id, expires_at) VALUES (?, ?, ?, ?, ?)"
+	_, err = tx.ExecContext(ctx, query, next.TokenHash, next.FamilyID, next.UserID, next.AppID, next.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	const op = "sqlite.RevokeRefreshTokenFamily"
+
+	query := "UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL"
+	if _, err := s.db.ExecContext(ctx, query, time.Now().UTC(), familyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) (int64, error) {
+	const op = "sqlite.DeleteExpiredRefreshTokens"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+func (s *Storage) LoginAttempt(ctx context.Context, key string) (model.LoginAttempt, error) {
+	const op = "sqlite.LoginAttempt"
+
+	query := "SELECT key, failures, blocked_until, expires_at FROM login_attempts WHERE key = ?"
+	row := s.db.QueryRowContext(ctx, query, key)
+
+	var (
+		attempt      model.LoginAttempt
+		blockedUntil sql.NullTime
+	)
+	if err := row.Scan(&attempt.Key, &attempt.Failures, &blockedUntil, &attempt.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.LoginAttempt{}, fmt.Errorf("%s: %w", op, storage.ErrLoginAttemptNotFound)
+		}
+
+		return model.LoginAttempt{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if blockedUntil.Valid {
+		attempt.BlockedUntil = &blockedUntil.Time
+	}
+
+	return attempt, nil
+}
+
+// RecordLoginFailure counts a failed login for the key and returns the
+// number of failures since the key last expired. The key is kept at least
+// until expiresAt.
+func (s *Storage) RecordLoginFailure(ctx context.Context, key string, at, expiresAt time.Time) (int, error) {
+	const op = "sqlite.RecordLoginFailure"
+
+	query := `INSERT INTO login_attempts (key, failures, expires_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.expires_at <= ? THEN 1 ELSE login_attempts.failures + 1 END,
+			blocked_until = CASE WHEN login_attempts.expires_at <= ? THEN NULL ELSE login_attempts.blocked_until END,
+			expires_at = MAX(login_attempts.expires_at, excluded.expires_at)
+		RETURNING failures`
+	row := s.db.QueryRowContext(ctx, query, key, expiresAt.UTC(), at.UTC(), at.UTC())
+
+	var failures int
+	if err := row.Scan(&failures); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, nil
+}
+
+// BlockLogin rejects logins for the key until the given time.
+func (s *Storage) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	const op = "sqlite.BlockLogin"
+
+	query := "UPDATE login_attempts SET blocked_until = ?, expires_at = MAX(expires_at, ?) WHERE key = ?"
+	res, err := s.db.ExecContext(ctx, query, until.UTC(), until.UTC(), key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrLoginAttemptNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteLoginAttempt(ctx context.Context, key string) error {
+	const op = "sqlite.DeleteLoginAttempt"
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = ?", key); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredLoginAttempts(ctx context.Context, now time.Time) (int64, error) {
+	const op = "sqlite.DeleteExpiredLoginAttempts"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+func (s *Storage) SaveSigningKey(ctx context.Context, key model.SigningKey) error {
+	const op = "sqlite.SaveSigningKey"
+
+	query := "INSERT INTO signing_keys (id, algorithm, private_key, activates_at, retires_at) VALUES (?, ?, ?, ?, ?)"
+	_, err := s.db.ExecContext(ctx, query, key.ID, key.Algorithm, key.PrivateKey, nullTime(key.ActivatesAt), nullTime(key.RetiresAt))
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+			return fmt.Errorf("%s: %w", op, storage.ErrSigningKeyAlreadyExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SigningKeys(ctx context.Context) ([]model.SigningKey, error) {
+	const op = "sqlite.SigningKeys"
+
+	query := "SELECT id, algorithm, private_key, activates_at, retires_at, created_at FROM signing_keys ORDER BY created_at"
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []model.SigningKey
+	for rows.Next() {
+		var (
+			key         model.SigningKey
+			activatesAt sql.NullTime
+			retiresAt   sql.NullTime
+		)
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &activatesAt, &retiresAt, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if activatesAt.Valid {
+			key.ActivatesAt = &activatesAt.Time
+		}
+		if retiresAt.Valid {
+			key.RetiresAt = &retiresAt.Time
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) ActivateSigningKey(ctx context.Context, kid string, at time.Time) error {
+	const op = "sqlite.ActivateSigningKey"
+
+	return s.updateSigningKey(ctx, op, "UPDATE signing_keys SET activates_at = ? WHERE id = ?", at.UTC(), kid)
+}
+
+func (s *Storage) RetireSigningKey(ctx context.Context, kid string, at time.Time) error {
+	const op = "sqlite.RetireSigningKey"
+
+	return s.updateSigningKey(ctx, op, "UPDATE signing_keys SET retires_at = ? WHERE id = ?", at.UTC(), kid)
+}
+
+func (s *Storage) UpdateSigningKeyPrivateKey(ctx context.Context, kid string, privateKey []byte) error {
+	const op = "sqlite.UpdateSigningKeyPrivateKey"
+
+	return s.updateSigningKey(ctx, op, "UPDATE signing_keys SET private_key = ? WHERE id = ?", privateKey, kid)
+}
+
+func (s *Storage) updateSigningKey(ctx context.Context, op, query string, args ...any) error {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetAppRequireMFA(ctx context.Context, appID int64, require bool) error {
+	const op = "sqlite.SetAppRequireMFA"
+
+	res, err := s.db.ExecContext(ctx, "UPDATE apps SET require_mfa = ? WHERE id = ?", require, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// SaveTOTP stores a new unconfirmed secret for the user, replacing a
+// previous unconfirmed one. It fails with storage.ErrTOTPAlreadyEnabled if
+// the user has a confirmed secret.
+func (s *Storage) SaveTOTP(ctx context.Context, uid int64, secret string) error {
+	const op = "sqlite.SaveTOTP"
+
+	query := `INSERT INTO user_totp (user_id, secret, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, last_used_step = 0, created_at = excluded.created_at
+		WHERE user_totp.confirmed_at IS NULL`
+	res, err := s.db.ExecContext(ctx, query, uid, secret, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	saved, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if saved == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPAlreadyEnabled)
+	}
+
+	return nil
+}
+
+func (s *Storage) UserTOTP(ctx context.Context, uid int64) (model.TOTP, error) {
+	const op = "sqlite.UserTOTP"
+
+	query := "SELECT user_id, secret, confirmed_at, last_used_step, created_at FROM user_totp WHERE user_id = ?"
+	row := s.db.QueryRowContext(ctx, query, uid)
+
+	var (
+		totp        model.TOTP
+		confirmedAt sql.NullTime
+	)
+	if err := row.Scan(&totp.UserID, &totp.Secret, &confirmedAt, &totp.LastUsedStep, &totp.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.TOTP{}, fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+		}
+
+		return model.TOTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if confirmedAt.Valid {
+		totp.ConfirmedAt = &confirmedAt.Time
+	}
+
+	return totp, nil
+}
+
+// ConfirmTOTP enables the secret of the user as a second factor and
+// replaces the recovery codes of the user in a single transaction.
+func (s *Storage) ConfirmTOTP(ctx context.Context, uid int64, recoveryCodeHashes []string) error {
+	const op = "sqlite.ConfirmTOTP"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE user_totp SET confirmed_at = ? WHERE user_id = ? AND confirmed_at IS NULL",
+		time.Now().UTC(), uid,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", uid); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", uid, hash); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code. It fails with
+// storage.ErrTOTPStepUsed if a code of the same or a later step has
+// already been accepted.
+func (s *Storage) UseTOTPStep(ctx context.Context, uid, step int64) error {
+	const op = "sqlite.UseTOTPStep"
+
+	query := "UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?"
+	res, err := s.db.ExecContext(ctx, query, step, uid, step)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPStepUsed)
+	}
+
+	return nil
+}
+
+// DeleteTOTP disables the second factor of the user together with the
+// recovery codes.
+func (s *Storage) DeleteTOTP(ctx context.Context, uid int64) error {
+	const op = "sqlite.DeleteTOTP"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", uid); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = ?", uid); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code as used. It fails with
+// storage.ErrRecoveryCodeNotFound if the code does not exist or has already
+// been used.
+func (s *Storage) UseRecoveryCode(ctx context.Context, uid int64, codeHash string) error {
+	const op = "sqlite.UseRecoveryCode"
+
+	query := "UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL"
+	res, err := s.db.ExecContext(ctx, query, time.Now().UTC(), uid, codeHash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRecoveryCodeNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveMFAChallenge(ctx context.Context, challenge model.MFAChallenge) error {
+	const op = "sqlite.SaveMFAChallenge"
+
+	query := "INSERT INTO mfa_challenges (token_hash, user_id, app_id, expires_at, enrollment) VALUES (?, ?, ?, ?, ?)"
+	_, err := s.db.ExecContext(ctx, query, challenge.TokenHash, challenge.UserID, challenge.AppID, challenge.ExpiresAt.UTC(), challenge.Enrollment)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) MFAChallenge(ctx context.Context, tokenHash string) (model.MFAChallenge, error) {
+	const op = "sqlite.MFAChallenge"
+
+	query := "SELECT token_hash, user_id, app_id, expires_at, enrollment FROM mfa_challenges WHERE token_hash = ?"
+	row := s.db.QueryRowContext(ctx, query, tokenHash)
+
+	var challenge model.MFAChallenge
+	if err := row.Scan(&challenge.TokenHash, &challenge.UserID, &challenge.AppID, &challenge.ExpiresAt, &challenge.Enrollment); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.MFAChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+		}
+
+		return model.MFAChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return challenge, nil
+}
+
+// DeleteMFAChallenge consumes the challenge. It fails with
+// storage.ErrMFAChallengeNotFound if the challenge has already been
+// consumed.
+func (s *Storage) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	const op = "sqlite.DeleteMFAChallenge"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE token_hash = ?", tokenHash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredMFAChallenges(ctx context.Context, now time.Time) (int64, error) {
+	const op = "sqlite.DeleteExpiredMFAChallenges"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+func (s *Storage) SetAppRequireVerifiedEmail(ctx context.Context, appID int64, require bool) error {
+	const op = "sqlite.SetAppRequireVerifiedEmail"
+
+	res, err := s.db.ExecContext(ctx, "UPDATE apps SET require_verified_email = ? WHERE id = ?", require, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) VerifyEmail(ctx context.Context, uid int64) error {
+	const op = "sqlite.VerifyEmail"
+
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET email_verified = TRUE WHERE id = ?", uid)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveOneTimeToken(ctx context.Context, token model.OneTimeToken) error {
+	const op = "sqlite.SaveOneTimeToken"
+
+	query := "INSERT INTO one_time_tokens (token_hash, user_id, purpose, expires_at) VALUES (?, ?, ?, ?)"
+	_, err := s.db.ExecContext(ctx, query, token.TokenHash, token.UserID, token.Purpose, token.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// OneTimeToken returns the token without using it up.
+func (s *Storage) OneTimeToken(ctx context.Context, tokenHash, purpose string) (model.OneTimeToken, error) {
+	const op = "sqlite.OneTimeToken"
+
+	query := "SELECT token_hash, user_id, purpose, expires_at FROM one_time_tokens WHERE token_hash = ? AND purpose = ?"
+	row := s.db.QueryRowContext(ctx, query, tokenHash, purpose)
+
+	var token model.OneTimeToken
+	if err := row.Scan(&token.TokenHash, &token.UserID, &token.Purpose, &token.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.OneTimeToken{}, fmt.Errorf("%s: %w", op, storage.ErrOneTimeTokenNotFound)
+		}
+
+		return model.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// ConsumeOneTimeToken deletes the token and returns it, so that it can
+// only be used once. It fails with storage.ErrOneTimeTokenNotFound if there
+// is no token for the purpose with the hash.
+func (s *Storage) ConsumeOneTimeToken(ctx context.Context, tokenHash, purpose string) (model.OneTimeToken, error) {
+	const op = "sqlite.ConsumeOneTimeToken"
+
+	query := `DELETE FROM one_time_tokens WHERE token_hash = ? AND purpose = ?
+		RETURNING token_hash, user_id, purpose, expires_at`
+	row := s.db.QueryRowContext(ctx, query, tokenHash, purpose)
+
+	var token model.OneTimeToken
+	if err := row.Scan(&token.TokenHash, &token.UserID, &token.Purpose, &token.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.OneTimeToken{}, fmt.Errorf("%s: %w", op, storage.ErrOneTimeTokenNotFound)
+		}
+
+		return model.OneTimeToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+func (s *Storage) DeleteExpiredOneTimeTokens(ctx context.Context, now time.Time) (int64, error) {
+	const op = "sqlite.DeleteExpiredOneTimeTokens"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM one_time_tokens WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+// UpdatePassword replaces the password hash of the user and revokes every
+// refresh token of the user except the ones of the keepFamilyID family, if
+// not empty, in a single transaction. The token version of the user is
+// bumped, so the access tokens issued so far are rejected from then on.
+func (s *Storage) UpdatePassword(ctx context.Context, uid int64, passHash []byte, keepFamilyID string) error {
+	const op = "sqlite.UpdatePassword"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE users SET password = ?, token_version = token_version + 1 WHERE id = ?",
+		passHash, uid,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND family_id <> ? AND revoked_at IS NULL",
+		time.Now().UTC(), uid, keepFamilyID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ReplacePasswordHash swaps the password hash of the user for an equivalent
+// one, e.g. with stronger parameters, unless the password has been changed
+// in the meantime. Unlike UpdatePassword it does not end any session.
+func (s *Storage) ReplacePasswordHash(ctx context.Context, uid int64, oldHash, newHash []byte) error {
+	const op = "sqlite.ReplacePasswordHash"
+
+	query := "UPDATE users SET password = ? WHERE id = ? AND password = ?"
+	if _, err := s.db.ExecContext(ctx, query, newHash, uid, oldHash); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteOneTimeTokens deletes every token of the user for the purpose.
+func (s *Storage) DeleteOneTimeTokens(ctx context.Context, uid int64, purpose string) error {
+	const op = "sqlite.DeleteOneTimeTokens"
+
+	query := "DELETE FROM one_time_tokens WHERE user_id = ? AND purpose = ?"
+	if _, err := s.db.ExecContext(ctx, query, uid, purpose); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetAppClientType(ctx context.Context, appID int64, clientType string) error {
+	const op = "sqlite.SetAppClientType"
+
+	res, err := s.db.ExecContext(ctx, "UPDATE apps SET client_type = ? WHERE id = ?", clientType, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// AddAppRedirectURI registers a redirect URI of the app. Adding a URI twice
+// is not an error.
+func (s *Storage) AddAppRedirectURI(ctx context.Context, appID int64, redirectURI string) error {
+	const op = "sqlite.AddAppRedirectURI"
+
+	query := `INSERT INTO app_redirect_uris (app_id, redirect_uri) SELECT id, ? FROM apps WHERE id = ?
+		ON CONFLICT DO NOTHING`
+	res, err := s.db.ExecContext(ctx, query, redirectURI, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if inserted == 0 {
+		if _, err := s.App(ctx, appID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) AppRedirectURIs(ctx context.Context, appID int64) ([]string, error) {
+	const op = "sqlite.AppRedirectURIs"
+
+	query := "SELECT redirect_uri FROM app_redirect_uris WHERE app_id = ? ORDER BY redirect_uri"
+	rows, err := s.db.QueryContext(ctx, query, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var uris []string
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		uris = append(uris, uri)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return uris, nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code model.AuthorizationCode) error {
+	const op = "sqlite.SaveAuthorizationCode"
+
+	query := `INSERT INTO authorization_codes
+		(code_hash, app_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, code.CodeHash, code.AppID, code.UserID, code.RedirectURI,
+		code.Scope, code.CodeChallenge, code.Nonce, code.AuthTime.UTC(), code.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AuthorizationCode returns the code with the hash, whether or not it was
+// exchanged already. It fails with storage.ErrAuthorizationCodeNotFound if
+// there is none.
+func (s *Storage) AuthorizationCode(ctx context.Context, codeHash string) (model.AuthorizationCode, error) {
+	const op = "sqlite.AuthorizationCode"
+
+	query := `SELECT code_hash, app_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at, consumed_at
+		FROM authorization_codes WHERE code_hash = ?`
+	row := s.db.QueryRowContext(ctx, query, codeHash)
+
+	var (
+		code       model.AuthorizationCode
+		authTime   sql.NullTime
+		consumedAt sql.NullTime
+	)
+	err := row.Scan(&code.CodeHash, &code.AppID, &code.UserID, &code.RedirectURI, &code.Scope,
+		&code.CodeChallenge, &code.Nonce, &authTime, &code.ExpiresAt, &consumedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.AuthorizationCode{}, fmt.Errorf("%s: %w", op, storage.ErrAuthorizationCodeNotFound)
+		}
+
+		return model.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if authTime.Valid {
+		code.AuthTime = authTime.Time
+	}
+	if consumedAt.Valid {
+		code.ConsumedAt = &consumedAt.Time
+	}
+
+	return code, nil
+}
+
+// ConsumeAuthorizationCode marks the code as exchanged, so that it can only
+// be exchanged once. It is kept until it expires to detect reuse. It fails
+// with storage.ErrAuthorizationCodeUsed if the code was consumed already.
+func (s *Storage) ConsumeAuthorizationCode(ctx context.Context, codeHash string) error {
+	const op = "sqlite.ConsumeAuthorizationCode"
+
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE authorization_codes SET consumed_at = ? WHERE code_hash = ? AND consumed_at IS NULL",
+		time.Now().UTC(), codeHash,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	consumed, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if consumed == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAuthorizationCodeUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredAuthorizationCodes(ctx context.Context, now time.Time) (int64, error) {
+	const op = "sqlite.DeleteExpiredAuthorizationCodes"
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM authorization_codes WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+// AppendAuditEvent chains the event to the last one in the audit log and
+// appends it.
+func (s *Storage) AppendAuditEvent(ctx context.Context, event model.AuditEvent) (model.AuditEvent, error) {
+	const op = "sqlite.AppendAuditEvent"
+
+	// Appends are serialized so that every event is chained to the last
+	// one, sqlite is only ever used by a single process.
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var prevHash string
+	err = tx.QueryRowContext(ctx, "SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return model.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.PrevHash = prevHash
+	event.Hash = event.ComputeHash(prevHash)
+
+	query := `INSERT INTO audit_log
+		(type, actor_id, target_id, app_id, email, peer, user_agent, outcome, reason, detail, created_at, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.ExecContext(ctx, query, event.Type, event.ActorID, event.TargetID, event.AppID, event.Email,
+		event.Peer, event.UserAgent, event.Outcome, event.Reason, event.Detail, event.CreatedAt.UTC(),
+		event.PrevHash, event.Hash)
+	if err != nil {
+		return model.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.ID, err = res.LastInsertId()
+	if err != nil {
+		return model.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return event, nil
+}
+
+// auditColumns are the columns scanned by scanAuditEvents.
+const auditColumns = `id, type, actor_id, target_id, app_id, email, peer, user_agent, outcome, reason, detail,
+	created_at, prev_hash, hash`
+
+// AuditEvents returns the events matching the filter, newest first.
+func (s *Storage) AuditEvents(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error) {
+	const op = "sqlite.AuditEvents"
+
+	var (
+		conds []string
+		args  []any
+	)
+	where := func(cond string, values ...any) {
+		conds = append(conds, cond)
+		args = append(args, values...)
+	}
+
+	if filter.UserID != 0 {
+		where("(actor_id = ? OR target_id = ?)", filter.UserID, filter.UserID)
+	}
+	if filter.AppID != 0 {
+		where("app_id = ?", filter.AppID)
+	}
+	if filter.Type != "" {
+		where("type = ?", filter.Type)
+	}
+	if !filter.Since.IsZero() {
+		where("created_at >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where("created_at < ?", filter.Until.UTC())
+	}
+	if filter.BeforeID != 0 {
+		where("id < ?", filter.BeforeID)
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	args = append(args, filter.Limit)
+	query += " ORDER BY id DESC LIMIT ?"
+
+	events, err := s.scanAuditEvents(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// AuditChain returns up to limit events after afterID, oldest first.
+func (s *Storage) AuditChain(ctx context.Context, afterID int64, limit int) ([]model.AuditEvent, error) {
+	const op = "sqlite.AuditChain"
+
+	query := "SELECT " + auditColumns + " FROM audit_log WHERE id > ? ORDER BY id LIMIT ?"
+
+	events, err := s.scanAuditEvents(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+func (s *Storage) scanAuditEvents(ctx context.Context, query string, args ...any) ([]model.AuditEvent, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.AuditEvent
+	for rows.Next() {
+		var event model.AuditEvent
+		err := rows.Scan(&event.ID, &event.Type, &event.ActorID, &event.TargetID, &event.AppID, &event.Email,
+			&event.Peer, &event.UserAgent, &event.Outcome, &event.Reason, &event.Detail, &event.CreatedAt,
+			&event.PrevHash, &event.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// nullSeconds stores a duration in seconds, zero is stored as NULL.
+func nullSeconds(d time.Duration) sql.NullInt64 {
+	if d == 0 {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: int64(d / time.Second), Valid: true}
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
@@ -7,4 +7,26 @@ var (
 	ErrUserAlreadyExists = errors.New("user already exists")
 	ErrAppNotFound       = errors.New("app not found")
 	ErrAppAlreadyExists  = errors.New("app already exists")
+	ErrRoleNotFound      = errors.New("role not found")
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenRotated  = errors.New("refresh token already rotated")
+
+	ErrSigningKeyNotFound      = errors.New("signing key not found")
+	ErrSigningKeyAlreadyExists = errors.New("signing key already exists")
+
+	ErrLoginAttemptNotFound = errors.New("login attempt not found")
+
+	ErrTOTPNotFound         = errors.New("totp not found")
+	ErrTOTPAlreadyEnabled   = errors.New("totp already enabled")
+	ErrTOTPStepUsed         = errors.New("totp code already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	ErrMFAChallengeNotFound = errors.New("mfa challenge not found")
+
+	ErrOneTimeTokenNotFound = errors.New("one-time token not found")
+
+	ErrAuthorizationCodeNotFound = errors.New("authorization code not found")
+	ErrAuthorizationCodeUsed     = errors.New("authorization code already used")
+
+	ErrNotMigrated = errors.New("no migration applied")
 )
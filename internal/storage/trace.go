@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/JSONStatham/sso/internal/storage")
+
+// DB runs every query of *sql.DB in its own span.
+type DB struct {
+	*sql.DB
+	system attribute.KeyValue
+}
+
+// NewDB traces the queries run on db. system is the database system
+// attribute of the spans, e.g. semconv.DBSystemSqlite.
+func NewDB(db *sql.DB, system attribute.KeyValue) *DB {
+	return &DB{DB: db, system: system}
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := db.startQuery(ctx, query)
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	endQuery(span, err)
+
+	return res, err
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := db.startQuery(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	endQuery(span, err)
+
+	return rows, err
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := db.startQuery(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	endQuery(span, row.Err())
+
+	return row
+}
+
+// BeginTx starts a transaction whose queries are traced as well.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx, db: db}, nil
+}
+
+// Tx runs every query of *sql.Tx in its own span.
+type Tx struct {
+	*sql.Tx
+	db *DB
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := tx.db.startQuery(ctx, query)
+	res, err := tx.Tx.ExecContext(ctx, query, args...)
+	endQuery(span, err)
+
+	return res, err
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := tx.db.startQuery(ctx, query)
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	endQuery(span, err)
+
+	return rows, err
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := tx.db.startQuery(ctx, query)
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	endQuery(span, row.Err())
+
+	return row
+}
+
+// startQuery starts a span named after the operation of the query, e.g.
+// "SELECT". The span does not cover reading the rows.
+func (db *DB) startQuery(ctx context.Context, query string) (context.Context, trace.Span) {
+	operation := "QUERY"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(db.system, semconv.DBOperationName(operation), semconv.DBQueryText(query)),
+	)
+}
+
+func endQuery(span trace.Span, err error) {
+	// No rows is an expected outcome, the storage reports it as not found.
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,97 @@
+// Package tracing sets up the OpenTelemetry tracer provider of the service.
+// Spans are created with the global provider, so the gRPC server, the
+// services and the storage need no reference to it.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/config"
+	"github.com/JSONStatham/sso/internal/utils/logger/sl"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// shutdownTimeout bounds how long Stop waits for pending spans to be exported.
+const shutdownTimeout = 5 * time.Second
+
+type Tracing struct {
+	log      *slog.Logger
+	provider *sdktrace.TracerProvider
+}
+
+// New installs the tracer provider exporting to cfg.Exporter as the global
+// one. With the "none" exporter the global provider is left as it is, so
+// one installed by the caller, e.g. a test, keeps recording. W3C trace
+// context is propagated either way.
+func New(ctx context.Context, log *slog.Logger, cfg config.TracingConfig) (*Tracing, error) {
+	const op = "tracing.New"
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch cfg.Exporter {
+	case config.ExporterNone:
+		return &Tracing{log: log}, nil
+	case config.ExporterStdout:
+		exporter, err = stdouttrace.New()
+	case config.ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("%s: unknown exporter %q", op, cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return &Tracing{log: log, provider: provider}, nil
+}
+
+// Stop exports the pending spans and shuts the provider down.
+func (t *Tracing) Stop() {
+	const op = "tracing.Stop"
+
+	if t.provider == nil {
+		return
+	}
+
+	log := t.log.With(slog.String("op", op))
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := t.provider.Shutdown(ctx); err != nil {
+		log.Error("failed to shut down tracer provider", sl.Err(err))
+	}
+}
@@ -0,0 +1,176 @@
+package jwt
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is the public part of a signing key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set document.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWKS builds the JWKS document for the public keys of the given set.
+func NewJWKS(keys Keys) (JWKS, error) {
+	publicKeys, err := keys.PublicKeys()
+	if err != nil {
+		return JWKS{}, err
+	}
+
+	set := JWKS{Keys: make([]JWK, 0, len(publicKeys))}
+	for _, key := range publicKeys {
+		jwk, err := PublicJWK(key)
+		if err != nil {
+			return JWKS{}, err
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+// PublicJWK returns the JWK representation of the public part of key.
+func PublicJWK(key Key) (JWK, error) {
+	jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Algorithm}
+
+	switch pub := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(pub.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ecdhKey, err := pub.ECDH()
+		if err != nil {
+			return JWK{}, err
+		}
+
+		// Uncompressed point: 0x04 || X || Y
+		point := ecdhKey.Bytes()
+		size := (len(point) - 1) / 2
+
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = encodeSegment(point[1 : 1+size])
+		jwk.Y = encodeSegment(point[1+size:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeSegment(pub)
+	default:
+		return JWK{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+	}
+
+	return jwk, nil
+}
+
+// ParseJWK returns the verification key described by the JWK. It accepts
+// the keys produced by PublicJWK.
+func ParseJWK(jwk JWK) (Key, error) {
+	key := Key{ID: jwk.Kid, Algorithm: jwk.Alg}
+
+	switch {
+	case jwk.Kty == "RSA" && jwk.Alg == AlgRS256:
+		n, errN := decodeSegment(jwk.N)
+		e, errE := decodeSegment(jwk.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+			return Key{}, fmt.Errorf("%w: malformed rsa key %q", ErrUnsupportedKey, jwk.Kid)
+		}
+
+		key.PublicKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case jwk.Kty == "EC" && jwk.Crv == "P-256" && jwk.Alg == AlgES256:
+		x, errX := decodeSegment(jwk.X)
+		y, errY := decodeSegment(jwk.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return Key{}, fmt.Errorf("%w: malformed ec key %q", ErrUnsupportedKey, jwk.Kid)
+		}
+
+		// Rejects points that are not on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+
+		key.PublicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519" && jwk.Alg == AlgEdDSA:
+		x, err := decodeSegment(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return Key{}, fmt.Errorf("%w: malformed ed25519 key %q", ErrUnsupportedKey, jwk.Kid)
+		}
+
+		key.PublicKey = ed25519.PublicKey(x)
+	default:
+		return Key{}, fmt.Errorf("%w: kty %q alg %q", ErrUnsupportedKey, jwk.Kty, jwk.Alg)
+	}
+
+	return key, nil
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of the key.
+func Thumbprint(key Key) (string, error) {
+	jwk, err := PublicJWK(key)
+	if err != nil {
+		return "", err
+	}
+
+	// Only the required members, in lexicographic order.
+	var members any
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return encodeSegment(sum[:]), nil
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
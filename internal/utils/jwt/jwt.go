@@ -1,22 +1,284 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/JSONStatham/sso/internal/domain/model"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func NewToken(user model.User, app model.App, duration time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims holds the claims of a token issued by NewToken.
+type Claims struct {
+	ID       string
+	Issuer   string
+	Subject  string
+	Audience []string
+	UID      int64
+	AppID    int64
+	Scopes   []string
+	Roles    []string
+	// TokenVersion is the token version of the user the token was issued
+	// for.
+	TokenVersion int64
+	IssuedAt     time.Time
+	NotBefore    time.Time
+	ExpiresAt    time.Time
+}
+
+// Validation lists the claims ParseToken checks besides the signature and
+// the lifetime of a token.
+type Validation struct {
+	// Issuer has to match the "iss" claim.
+	Issuer string
+	// Audience has to be contained in the "aud" claim. Tokens issued for
+	// any app are accepted if it is empty.
+	Audience string
+	// AllowClient also accepts tokens issued by NewClientToken. Their UID
+	// is 0.
+	AllowClient bool
+}
+
+// NewToken issues a token for the user signed with the current signing key
+// of keys. The key id is set in the "kid" header, the audience is the app
+// and the roles of the user in the app are put into the "roles" claim. The
+// token version of the user is put into the "ver" claim.
+func NewToken(keys Keys, issuer string, user model.User, app model.App, roles []string, duration time.Duration) (string, error) {
+	key, err := keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	if roles == nil {
+		roles = []string{}
+	}
+
+	now := time.Now()
+
+	return Sign(key, jwt.MapClaims{
+		"jti":    jti,
+		"iss":    issuer,
+		"sub":    strconv.Itoa(user.ID),
+		"aud":    app.Audience(),
 		"uid":    user.ID,
 		"app_id": app.ID,
-		"exp":    time.Now().Add(duration).Unix(),
+		"roles":  roles,
+		"ver":    user.TokenVersion,
+		"iat":    now.Unix(),
+		"nbf":    now.Unix(),
+		"exp":    now.Add(duration).Unix(),
 	})
+}
+
+// NewClientToken issues a token for the app itself, as granted by the
+// OAuth 2.0 client credentials flow. Its subject and audience are the app.
+// It has no "uid" claim, so ParseToken only accepts it in place of a token
+// of a user if Validation.AllowClient is set.
+func NewClientToken(keys Keys, issuer string, app model.App, scope string, duration time.Duration) (string, error) {
+	key, err := keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"jti":    jti,
+		"iss":    issuer,
+		"sub":    app.Audience(),
+		"aud":    app.Audience(),
+		"app_id": app.ID,
+		"iat":    now.Unix(),
+		"nbf":    now.Unix(),
+		"exp":    now.Add(duration).Unix(),
+	}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+
+	return Sign(key, claims)
+}
+
+// NewIDToken issues an OpenID Connect ID token telling the app who the user
+// is. The audience is the app, nonce is the one of the authorization
+// request and authTime is when the user authenticated.
+func NewIDToken(keys Keys, issuer string, user model.User, app model.App, nonce string, authTime time.Time, duration time.Duration) (string, error) {
+	key, err := keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"iss":            issuer,
+		"sub":            strconv.Itoa(user.ID),
+		"aud":            app.Audience(),
+		"iat":            now.Unix(),
+		"exp":            now.Add(duration).Unix(),
+		"auth_time":      authTime.Unix(),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return Sign(key, claims)
+}
+
+// Sign signs arbitrary claims with the key.
+func Sign(key Key, claims jwt.Claims) (string, error) {
+	if key.PrivateKey == nil {
+		return "", fmt.Errorf("%w: key %q cannot be used for signing", ErrUnsupportedKey, key.ID)
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.PrivateKey)
+}
+
+// ParseToken verifies the signature, lifetime, issuer and audience of a
+// token issued by NewToken, or NewClientToken if allowed by v, and returns
+// its claims. The verification key is selected by the "kid" header of the
+// token.
+func ParseToken(keys Keys, tokenStr string, v Validation) (Claims, error) {
+	if v.Issuer == "" {
+		return Claims{}, fmt.Errorf("%w: issuer is required", ErrInvalidToken)
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{AlgRS256, AlgES256, AlgEdDSA}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(v.Issuer),
+	}
+	if v.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.Audience))
+	}
+
+	token, err := jwt.Parse(tokenStr, keyFunc(keys), opts...)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return Claims{}, ErrInvalidToken
+	}
+
+	jti, _ := mapClaims["jti"].(string)
+	sub, _ := mapClaims["sub"].(string)
+	uid, isUser := mapClaims["uid"].(float64)
+	appID, _ := mapClaims["app_id"].(float64)
+	if jti == "" || appID == 0 {
+		return Claims{}, fmt.Errorf("%w: missing required claims", ErrInvalidToken)
+	}
+
+	switch {
+	case isUser:
+		if uid == 0 || sub != strconv.FormatInt(int64(uid), 10) {
+			return Claims{}, fmt.Errorf("%w: missing required claims", ErrInvalidToken)
+		}
+	case v.AllowClient:
+		// The subject of a client token is the app itself.
+		if sub != strconv.FormatInt(int64(appID), 10) {
+			return Claims{}, fmt.Errorf("%w: missing required claims", ErrInvalidToken)
+		}
+	default:
+		return Claims{}, fmt.Errorf("%w: token was not issued for a user", ErrInvalidToken)
+	}
+
+	aud, err := mapClaims.GetAudience()
+	if err != nil || !slices.Contains(aud, strconv.FormatInt(int64(appID), 10)) {
+		return Claims{}, fmt.Errorf("%w: audience does not match the app", ErrInvalidToken)
+	}
+
+	exp, err := mapClaims.GetExpirationTime()
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var issuedAt, notBefore time.Time
+	if iat, err := mapClaims.GetIssuedAt(); err == nil && iat != nil {
+		issuedAt = iat.Time
+	}
+	if nbf, err := mapClaims.GetNotBefore(); err == nil && nbf != nil {
+		notBefore = nbf.Time
+	}
+
+	scope, _ := mapClaims["scope"].(string)
+	version, _ := mapClaims["ver"].(float64)
+
+	var roles []string
+	if list, ok := mapClaims["roles"].([]interface{}); ok {
+		for _, role := range list {
+			if role, ok := role.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	return Claims{
+		ID:           jti,
+		Issuer:       v.Issuer,
+		Subject:      sub,
+		Audience:     aud,
+		UID:          int64(uid),
+		AppID:        int64(appID),
+		Scopes:       strings.Fields(scope),
+		Roles:        roles,
+		TokenVersion: int64(version),
+		IssuedAt:     issuedAt,
+		NotBefore:    notBefore,
+		ExpiresAt:    exp.Time,
+	}, nil
+}
+
+func keyFunc(keys Keys) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, fmt.Errorf("%w: missing kid header", ErrKeyNotFound)
+		}
+
+		key, err := keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+		}
+
+		return key.PublicKey, nil
+	}
+}
 
-	fmt.Printf("LOC: %s", os.Getenv("JWT_SECRET"))
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
 
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return hex.EncodeToString(b), nil
 }
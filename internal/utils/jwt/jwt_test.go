@@ -1,43 +1,306 @@
 package jwt
 
 import (
-	"os"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"testing"
 	"time"
 
 	"github.com/JSONStatham/sso/internal/domain/model"
-	"github.com/golang-jwt/jwt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+const testIssuer = "https://sso.example.com"
+
 func TestNewToken(t *testing.T) {
-	os.Setenv("JWT_SECRET", "secret")
-	defer os.Unsetenv("JWT_SECRET")
+	for alg, key := range testKeys(t) {
+		t.Run(alg, func(t *testing.T) {
+			keys := NewKeySet(key)
 
-	user := model.User{ID: 1}
-	app := model.App{ID: 1}
-	duration := time.Minute * 15
+			user := model.User{ID: 1}
+			app := model.App{ID: 1}
+			duration := time.Minute * 15
 
-	tokenStr, err := NewToken(user, app, duration)
-	require.NoError(t, err, "Toeken generation shopuld not return an error")
-	require.NotEmpty(t, tokenStr, "Token string should not be empty")
+			tokenStr, err := NewToken(keys, testIssuer, user, app, []string{"admin"}, duration)
+			require.NoError(t, err, "Token generation should not return an error")
+			require.NotEmpty(t, tokenStr, "Token string should not be empty")
 
-	// Parse token
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+			// Parse token
+			token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+				return key.PublicKey, nil
+			})
+			require.NoError(t, err, "Token parsing should not return an error")
+			require.NotNil(t, token, "Parsed token should not be nil")
+
+			assert.Equal(t, alg, token.Method.Alg(), "Token should be signed with the key algorithm")
+			assert.Equal(t, key.ID, token.Header["kid"], "Token header should contain the key id")
+
+			// Validate claims
+			claims, ok := token.Claims.(jwt.MapClaims)
+			require.True(t, ok, "Token claims should be of type jwt.MapClaims")
+
+			assert.Equal(t, testIssuer, claims["iss"], "Token claims should contain the issuer")
+			assert.Equal(t, "1", claims["sub"], "Token subject should be the user ID")
+			assert.Equal(t, "1", claims["aud"], "Token audience should be the app ID")
+			assert.Contains(t, claims, "nbf", "Token claims should contain not before")
+			assert.Equal(t, float64(user.ID), claims["uid"], "Token claims should contain user ID")
+			assert.Equal(t, float64(app.ID), claims["app_id"], "Token claims should contain app ID")
+			assert.Equal(t, []interface{}{"admin"}, claims["roles"], "Token claims should contain roles")
+
+			exp, ok := claims["exp"].(float64)
+			require.True(t, ok, "Exp time should be a float64")
+			assert.Greater(t, exp, float64(time.Now().Unix()), "Exp time should be in the future")
+		})
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	keys := NewKeySet(testKeys(t)[AlgEdDSA])
+
+	user := model.User{ID: 1, TokenVersion: 3}
+	app := model.App{ID: 2}
+
+	tokenStr, err := NewToken(keys, testIssuer, user, app, []string{"admin", "editor"}, time.Minute*15)
+	require.NoError(t, err)
+
+	claims, err := ParseToken(keys, tokenStr, Validation{Issuer: testIssuer, Audience: app.Audience()})
 	require.NoError(t, err, "Token parsing should not return an error")
-	require.NotNil(t, token, "Parsed token should not be nil")
 
-	// Validate claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	require.True(t, ok, "Token claims should be of type jwt.MapClaims")
+	assert.NotEmpty(t, claims.ID, "Token should contain a unique id")
+	assert.Equal(t, testIssuer, claims.Issuer)
+	assert.Equal(t, "1", claims.Subject)
+	assert.Equal(t, []string{"2"}, claims.Audience)
+	assert.Equal(t, int64(user.ID), claims.UID)
+	assert.Equal(t, int64(app.ID), claims.AppID)
+	assert.Equal(t, []string{"admin", "editor"}, claims.Roles)
+	assert.Equal(t, user.TokenVersion, claims.TokenVersion)
+	assert.WithinDuration(t, time.Now(), claims.IssuedAt, time.Second)
+	assert.WithinDuration(t, time.Now(), claims.NotBefore, time.Second)
+	assert.WithinDuration(t, time.Now().Add(time.Minute*15), claims.ExpiresAt, time.Second)
+
+	other, err := NewToken(keys, testIssuer, user, app, nil, time.Minute*15)
+	require.NoError(t, err)
+	otherClaims, err := ParseToken(keys, other, Validation{Issuer: testIssuer})
+	require.NoError(t, err)
+	assert.NotEqual(t, claims.ID, otherClaims.ID, "Token ids should be unique")
+}
+
+func TestParseToken_ClientToken(t *testing.T) {
+	key := testKeys(t)[AlgEdDSA]
+	keys := NewKeySet(key)
+	app := model.App{ID: 2}
+
+	tokenStr, err := NewClientToken(keys, testIssuer, app, "reports:read", time.Minute*15)
+	require.NoError(t, err)
+
+	claims, err := ParseToken(keys, tokenStr, Validation{Issuer: testIssuer, Audience: app.Audience(), AllowClient: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, "2", claims.Subject)
+	assert.Zero(t, claims.UID, "Client tokens carry no user")
+	assert.Equal(t, int64(app.ID), claims.AppID)
+	assert.Equal(t, []string{"reports:read"}, claims.Scopes)
+
+	// A user token may not pose as the app
+	forged := signClaims(t, key, jwt.MapClaims{"uid": 0})
+	_, err = ParseToken(keys, forged, Validation{Issuer: testIssuer, AllowClient: true})
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestParseToken_Invalid(t *testing.T) {
+	all := testKeys(t)
+	keys := NewKeySet(all[AlgEdDSA])
+	user, app := model.User{ID: 1}, model.App{ID: 1}
+
+	expired, err := NewToken(keys, testIssuer, user, app, nil, -time.Minute)
+	require.NoError(t, err)
+
+	unknownKey, err := NewToken(NewKeySet(all[AlgES256]), testIssuer, user, app, nil, time.Minute)
+	require.NoError(t, err)
+
+	// Signed by a different key that claims the id of the trusted one
+	forgedKey := all[AlgRS256]
+	forgedKey.ID = all[AlgEdDSA].ID
+	forged, err := NewToken(NewKeySet(forgedKey), testIssuer, user, app, nil, time.Minute)
+	require.NoError(t, err)
+
+	// Client tokens carry no user
+	clientToken, err := NewClientToken(keys, testIssuer, app, "", time.Minute)
+	require.NoError(t, err)
+
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti":    "id",
+		"iss":    testIssuer,
+		"sub":    "1",
+		"aud":    "1",
+		"uid":    1,
+		"app_id": 1,
+		"exp":    time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	otherIssuer, err := NewToken(keys, "https://evil.example.com", user, app, nil, time.Minute)
+	require.NoError(t, err)
+
+	otherApp, err := NewToken(keys, testIssuer, user, model.App{ID: 2}, nil, time.Minute)
+	require.NoError(t, err)
+
+	notYetValid := signClaims(t, all[AlgEdDSA], jwt.MapClaims{
+		"nbf": time.Now().Add(time.Hour).Unix(),
+	})
+
+	// The audience has to name the app of the token
+	wrongAudience := signClaims(t, all[AlgEdDSA], jwt.MapClaims{
+		"aud": "2",
+	})
+
+	testCases := []struct {
+		name  string
+		token string
+	}{
+		{name: "Malformed", token: "not-a-token"},
+		{name: "Expired", token: expired},
+		{name: "Unknown key", token: unknownKey},
+		{name: "Forged kid", token: forged},
+		{name: "Symmetric signature", token: hmac},
+		{name: "Client token", token: clientToken},
+		{name: "Other issuer", token: otherIssuer},
+		{name: "Other app", token: otherApp},
+		{name: "Not yet valid", token: notYetValid},
+		{name: "Audience of another app", token: wrongAudience},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseToken(keys, tc.token, Validation{Issuer: testIssuer, Audience: app.Audience()})
+			require.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	for alg, key := range testKeys(t) {
+		t.Run(alg, func(t *testing.T) {
+			data, err := MarshalPrivateKey(key)
+			require.NoError(t, err)
+
+			parsed, err := ParsePrivateKey("", data)
+			require.NoError(t, err)
+
+			assert.Equal(t, alg, parsed.Algorithm)
+			assert.Equal(t, key.ID, parsed.ID, "Key id should default to the JWK thumbprint")
+		})
+	}
+}
+
+func TestNewJWKS(t *testing.T) {
+	all := testKeys(t)
+	keys := NewKeySet(all[AlgRS256], all[AlgES256], all[AlgEdDSA])
+
+	set, err := NewJWKS(keys)
+	require.NoError(t, err)
+	require.Len(t, set.Keys, 3)
+
+	byKid := make(map[string]JWK)
+	for _, jwk := range set.Keys {
+		byKid[jwk.Kid] = jwk
+	}
+
+	rsaJWK := byKid[all[AlgRS256].ID]
+	assert.Equal(t, "RSA", rsaJWK.Kty)
+	assert.Equal(t, "AQAB", rsaJWK.E)
+	assert.NotEmpty(t, rsaJWK.N)
+
+	ecJWK := byKid[all[AlgES256].ID]
+	assert.Equal(t, "EC", ecJWK.Kty)
+	assert.Equal(t, "P-256", ecJWK.Crv)
+	assert.Len(t, ecJWK.X, 43, "P-256 coordinates should be 32 bytes long")
+	assert.Len(t, ecJWK.Y, 43, "P-256 coordinates should be 32 bytes long")
+
+	edJWK := byKid[all[AlgEdDSA].ID]
+	assert.Equal(t, "OKP", edJWK.Kty)
+	assert.Equal(t, "Ed25519", edJWK.Crv)
+
+	for _, jwk := range set.Keys {
+		assert.Equal(t, "sig", jwk.Use)
+	}
+}
+
+// signClaims signs the claims of a valid token of user 1 in app 1 with the
+// claims overridden.
+func signClaims(t *testing.T, key Key, override jwt.MapClaims) string {
+	t.Helper()
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"jti":    "id",
+		"iss":    testIssuer,
+		"sub":    "1",
+		"aud":    "1",
+		"uid":    1,
+		"app_id": 1,
+		"iat":    now.Unix(),
+		"nbf":    now.Unix(),
+		"exp":    now.Add(time.Minute).Unix(),
+	}
+	for name, value := range override {
+		claims[name] = value
+	}
+
+	token, err := Sign(key, claims)
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestParseJWK(t *testing.T) {
+	for alg, key := range testKeys(t) {
+		t.Run(alg, func(t *testing.T) {
+			jwk, err := PublicJWK(key)
+			require.NoError(t, err)
+
+			parsed, err := ParseJWK(jwk)
+			require.NoError(t, err)
+
+			assert.Equal(t, key.ID, parsed.ID)
+			assert.Equal(t, alg, parsed.Algorithm)
+			assert.Nil(t, parsed.PrivateKey)
+			assert.True(t, key.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(parsed.PublicKey))
+		})
+	}
+
+	_, err := ParseJWK(JWK{Kty: "EC", Crv: "P-256", Alg: AlgES256, X: encodeSegment(make([]byte, 32)), Y: encodeSegment(make([]byte, 32))})
+	require.ErrorIs(t, err, ErrUnsupportedKey, "Points off the curve should be rejected")
+
+	_, err = ParseJWK(JWK{Kty: "oct", Alg: "HS256"})
+	require.ErrorIs(t, err, ErrUnsupportedKey)
+}
+
+func testKeys(t *testing.T) map[string]Key {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keys := make(map[string]Key)
+	for _, signer := range []crypto.Signer{rsaKey, ecKey, edKey} {
+		key, err := NewKey("", signer)
+		require.NoError(t, err)
 
-	assert.Equal(t, float64(user.ID), claims["uid"], "Token claims should contain user ID")
-	assert.Equal(t, float64(app.ID), claims["app_id"], "Token claims should contain app ID")
+		keys[key.Algorithm] = key
+	}
 
-	exp, ok := claims["exp"].(float64)
-	require.True(t, ok, "Exp time should be a float64")
-	assert.Greater(t, exp, float64(time.Now().Unix()), "Exp time should be in the future")
+	return keys
 }
@@ -0,0 +1,210 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported key")
+	ErrKeyNotFound    = errors.New("key not found")
+)
+
+// Key is an asymmetric key used to sign or verify tokens. PrivateKey is nil
+// for keys that may only be used for verification.
+type Key struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// Keys provides the keys used to sign and verify tokens.
+type Keys interface {
+	// SigningKey returns the key new tokens are signed with.
+	SigningKey() (Key, error)
+	// VerificationKey returns the key with the given id, or ErrKeyNotFound.
+	VerificationKey(kid string) (Key, error)
+	// PublicKeys returns every key that tokens may currently be verified with.
+	PublicKeys() ([]Key, error)
+}
+
+// KeySet is a fixed set of keys with a single signing key.
+type KeySet struct {
+	signing Key
+	keys    map[string]Key
+}
+
+func NewKeySet(signing Key, verification ...Key) *KeySet {
+	keys := make(map[string]Key, len(verification)+1)
+	for _, key := range verification {
+		keys[key.ID] = key
+	}
+	keys[signing.ID] = signing
+
+	return &KeySet{signing: signing, keys: keys}
+}
+
+func (s *KeySet) SigningKey() (Key, error) {
+	return s.signing, nil
+}
+
+func (s *KeySet) VerificationKey(kid string) (Key, error) {
+	key, ok := s.keys[kid]
+	if !ok {
+		return Key{}, ErrKeyNotFound
+	}
+
+	return key, nil
+}
+
+func (s *KeySet) PublicKeys() ([]Key, error) {
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// LoadKey reads a PEM encoded private key from path. When id is empty the
+// RFC 7638 thumbprint of the public key is used as the key id.
+func LoadKey(id, path string) (Key, error) {
+	const op = "jwt.LoadKey"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := ParsePrivateKey(id, data)
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8, PKCS #1 or SEC 1 private key.
+func ParsePrivateKey(id string, data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, fmt.Errorf("%w: no PEM data found", ErrUnsupportedKey)
+	}
+
+	var (
+		parsed any
+		err    error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return Key{}, fmt.Errorf("%w: PEM block %q", ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return Key{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, parsed)
+	}
+
+	return NewKey(id, signer)
+}
+
+// NewKey wraps a private key, inferring the signing algorithm from its type.
+func NewKey(id string, signer crypto.Signer) (Key, error) {
+	alg, err := algorithm(signer.Public())
+	if err != nil {
+		return Key{}, err
+	}
+
+	key := Key{
+		ID:         id,
+		Algorithm:  alg,
+		PrivateKey: signer,
+		PublicKey:  signer.Public(),
+	}
+
+	if key.ID == "" {
+		key.ID, err = Thumbprint(key)
+		if err != nil {
+			return Key{}, err
+		}
+	}
+
+	return key, nil
+}
+
+// GenerateKey creates a new private key for the algorithm.
+func GenerateKey(alg string) (Key, error) {
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch alg {
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return Key{}, fmt.Errorf("%w: algorithm %q", ErrUnsupportedKey, alg)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	return NewKey("", signer)
+}
+
+// MarshalPrivateKey encodes the private key as a PKCS #8 PEM block.
+func MarshalPrivateKey(key Key) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func algorithm(pub crypto.PublicKey) (string, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return "", fmt.Errorf("%w: RSA keys must be at least 2048 bits", ErrUnsupportedKey)
+		}
+		return AlgRS256, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return "", fmt.Errorf("%w: only P-256 EC keys are supported", ErrUnsupportedKey)
+		}
+		return AlgES256, nil
+	case ed25519.PublicKey:
+		return AlgEdDSA, nil
+	default:
+		return "", fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+	}
+}
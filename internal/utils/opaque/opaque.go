@@ -0,0 +1,31 @@
+package opaque
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+const tokenSize = 32
+
+// New generates a random URL-safe token and returns it together with its
+// hash. Only the hash is meant to be persisted.
+func New() (token, hash string, err error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("opaque.New: %w", err)
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+
+	return token, Hash(token), nil
+}
+
+// Hash returns the hex-encoded SHA-256 of the token.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
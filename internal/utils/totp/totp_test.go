@@ -0,0 +1,69 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode_RFC6238(t *testing.T) {
+	// The last 6 digits of the 8 digit SHA1 vectors from RFC 6238 appendix B.
+	testCases := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tc := range testCases {
+		got, err := Code(rfcSecret, time.Unix(tc.unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got, "time %d", tc.unix)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	code, err := Code(secret, now.Add(-Period))
+	require.NoError(t, err)
+
+	step, ok := Verify(secret, code, now)
+	require.True(t, ok, "Code of the previous period should be accepted")
+	assert.Equal(t, Step(now)-1, step)
+
+	stale, err := Code(secret, now.Add(-3*Period))
+	require.NoError(t, err)
+
+	_, ok = Verify(secret, stale, now)
+	assert.False(t, ok, "Stale code should be rejected")
+
+	_, ok = Verify(secret, "12345", now)
+	assert.False(t, ok, "Short code should be rejected")
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("sso", "user@example.com", "SECRET"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/sso:user@example.com", uri.Path)
+	assert.Equal(t, "SECRET", uri.Query().Get("secret"))
+	assert.Equal(t, "sso", uri.Query().Get("issuer"))
+}
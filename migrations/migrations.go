@@ -0,0 +1,46 @@
+// Package migrations embeds the schema migrations of every storage driver,
+// so the service knows which schema version it needs.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var FS embed.FS
+
+// Latest returns the version of the newest migration of the storage driver,
+// e.g. 14 for "00014_audit_log.up.sql".
+func Latest(driver string) (uint, error) {
+	const op = "migrations.Latest"
+
+	entries, err := fs.ReadDir(FS, driver)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var latest uint
+	for _, entry := range entries {
+		prefix, _, ok := strings.Cut(entry.Name(), "_")
+		if !ok {
+			continue
+		}
+
+		version, err := strconv.ParseUint(prefix, 10, 0)
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid migration %q: %w", op, entry.Name(), err)
+		}
+
+		latest = max(latest, uint(version))
+	}
+
+	if latest == 0 {
+		return 0, fmt.Errorf("%s: no migrations for driver %q", op, driver)
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS user_totp;
+ALTER TABLE apps DROP COLUMN IF EXISTS require_mfa;
@@ -0,0 +1,19 @@
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS client_type TEXT NOT NULL DEFAULT 'confidential';
+
+CREATE TABLE IF NOT EXISTS app_redirect_uris (
+    app_id BIGINT NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    PRIMARY KEY (app_id, redirect_uri)
+);
+
+CREATE TABLE IF NOT EXISTS authorization_codes (
+    code_hash TEXT PRIMARY KEY,
+    app_id BIGINT NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    code_challenge TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_authorization_codes_expires_at ON authorization_codes(expires_at);
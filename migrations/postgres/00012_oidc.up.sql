@@ -0,0 +1,2 @@
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT '';
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS access_token_ttl BIGINT;
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS refresh_token_ttl BIGINT;
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS grant_types TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at;
@@ -0,0 +1 @@
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS consumed_at TIMESTAMPTZ;
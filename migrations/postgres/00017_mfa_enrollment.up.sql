@@ -0,0 +1 @@
+ALTER TABLE mfa_challenges ADD COLUMN IF NOT EXISTS enrollment BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id TEXT PRIMARY KEY,
+    algorithm TEXT NOT NULL,
+    private_key BLOB NOT NULL,
+    activates_at DATETIME,
+    retires_at DATETIME,
+    created_at DATETIME DEFAULT (CURRENT_TIMESTAMP)
+);
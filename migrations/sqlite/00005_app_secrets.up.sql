@@ -0,0 +1 @@
+ALTER TABLE apps ADD COLUMN secret_hash TEXT;
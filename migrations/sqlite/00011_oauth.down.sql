@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS authorization_codes;
+DROP TABLE IF EXISTS app_redirect_uris;
+ALTER TABLE apps DROP COLUMN client_type;
@@ -0,0 +1,3 @@
+ALTER TABLE apps ADD COLUMN access_token_ttl INTEGER;
+ALTER TABLE apps ADD COLUMN refresh_token_ttl INTEGER;
+ALTER TABLE apps ADD COLUMN grant_types TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN password_changed_at DATETIME;
+ALTER TABLE users DROP COLUMN token_version;
@@ -0,0 +1 @@
+ALTER TABLE mfa_challenges DROP COLUMN enrollment;
@@ -0,0 +1 @@
+ALTER TABLE mfa_challenges ADD COLUMN enrollment BOOLEAN NOT NULL DEFAULT FALSE;
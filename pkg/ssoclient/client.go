@@ -0,0 +1,321 @@
+// Package ssoclient is a typed client of the SSO gRPC API. Calls get a
+// deadline if the context has none and are retried while the service is
+// unavailable.
+package ssoclient
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultTimeout     = 5 * time.Second
+	DefaultMaxAttempts = 3
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrNotFound           = errors.New("not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrInvalidArgument    = errors.New("invalid argument")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrLocked             = errors.New("too many failed attempts")
+)
+
+type Config struct {
+	// Addr is the host:port of the gRPC server.
+	Addr string
+	// Timeout is the deadline of calls whose context has none. Retries
+	// share it. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// MaxAttempts limits how often a call is tried while the server is
+	// unavailable. Defaults to DefaultMaxAttempts.
+	MaxAttempts int
+	// AppID and AppSecret authenticate the app for CheckPermission and
+	// Introspect.
+	AppID     int64
+	AppSecret string
+	// DialOptions are passed to grpc.NewClient. Connections are insecure
+	// unless transport credentials are given here.
+	DialOptions []grpc.DialOption
+}
+
+// Client wraps ssov1.AuthClient.
+type Client struct {
+	cfg  Config
+	conn *grpc.ClientConn
+	api  ssov1.AuthClient
+}
+
+// Tokens are the tokens issued by Login, VerifyMFA and Refresh.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// LoginResult holds the tokens of the user, or the MFA token to pass to
+// VerifyMFA if the user has a second factor. If the app requires MFA and
+// the user has none yet, MFAEnrollmentRequired is set instead. The MFA
+// token then authenticates EnrollTOTP and ConfirmTOTP as bearer token and
+// completes the login with VerifyMFA afterwards.
+type LoginResult struct {
+	Tokens
+	MFARequired           bool
+	MFAEnrollmentRequired bool
+	MFAToken              string
+}
+
+// TokenInfo describes a token as reported by Introspect.
+type TokenInfo struct {
+	Active    bool
+	UserID    int64
+	AppID     int64
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+func New(cfg Config) (*Client, error) {
+	const op = "ssoclient.New"
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(serviceConfig(cfg.MaxAttempts)),
+		grpc.WithChainUnaryInterceptor(timeoutInterceptor(cfg.Timeout)),
+	}
+	opts = append(opts, cfg.DialOptions...)
+
+	conn, err := grpc.NewClient(cfg.Addr, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Client{cfg: cfg, conn: conn, api: ssov1.NewAuthClient(conn)}, nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Raw returns the underlying gRPC client for calls the wrapper lacks.
+func (c *Client) Raw() ssov1.AuthClient {
+	return c.api
+}
+
+func (c *Client) Register(ctx context.Context, email, password string) (int64, error) {
+	const op = "ssoclient.Register"
+
+	resp, err := c.api.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, convert(err))
+	}
+
+	return resp.GetUserId(), nil
+}
+
+func (c *Client) Login(ctx context.Context, email, password string, appID int64) (LoginResult, error) {
+	const op = "ssoclient.Login"
+
+	resp, err := c.api.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	if err != nil {
+		// The server does not tell unknown users and wrong passwords apart.
+		if status.Code(err) == codes.NotFound {
+			return LoginResult{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidCredentials, err)
+		}
+
+		if token, ok := enrollmentToken(err); ok {
+			return LoginResult{MFAEnrollmentRequired: true, MFAToken: token}, nil
+		}
+
+		return LoginResult{}, fmt.Errorf("%s: %w", op, convert(err))
+	}
+
+	return LoginResult{
+		Tokens:      Tokens{AccessToken: resp.GetToken(), RefreshToken: resp.GetRefreshToken()},
+		MFARequired: resp.GetMfaRequired(),
+		MFAToken:    resp.GetMfaToken(),
+	}, nil
+}
+
+func (c *Client) VerifyMFA(ctx context.Context, mfaToken, code string) (Tokens, error) {
+	const op = "ssoclient.VerifyMFA"
+
+	resp, err := c.api.VerifyMFA(ctx, &ssov1.VerifyMFARequest{MfaToken: mfaToken, Code: code})
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%s: %w", op, convert(err))
+	}
+
+	return Tokens{AccessToken: resp.GetToken(), RefreshToken: resp.GetRefreshToken()}, nil
+}
+
+func (c *Client) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	const op = "ssoclient.Refresh"
+
+	resp, err := c.api.Refresh(ctx, &ssov1.RefreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%s: %w", op, convert(err))
+	}
+
+	return Tokens{AccessToken: resp.GetToken(), RefreshToken: resp.GetRefreshToken()}, nil
+}
+
+func (c *Client) Logout(ctx context.Context, token string) error {
+	const op = "ssoclient.Logout"
+
+	if _, err := c.api.Logout(ctx, &ssov1.LogoutRequest{Token: token}); err != nil {
+		return fmt.Errorf("%s: %w", op, convert(err))
+	}
+
+	return nil
+}
+
+// IsAdmin reports whether the user is a global admin. The access token has
+// to belong to the user or to an admin.
+func (c *Client) IsAdmin(ctx context.Context, accessToken string, userID int64) (bool, error) {
+	const op = "ssoclient.IsAdmin"
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+accessToken)
+
+	resp, err := c.api.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: userID})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, convert(err))
+	}
+
+	return resp.GetIsAdmin(), nil
+}
+
+// CheckPermission reports whether the user has the permission in the app
+// set in the config.
+func (c *Client) CheckPermission(ctx context.Context, userID int64, permission string) (bool, error) {
+	const op = "ssoclient.CheckPermission"
+
+	resp, err := c.api.CheckPermission(c.appContext(ctx), &ssov1.CheckPermissionRequest{
+		UserId:     userID,
+		AppId:      c.cfg.AppID,
+		Permission: permission,
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, convert(err))
+	}
+
+	return resp.GetAllowed(), nil
+}
+
+// Introspect asks the server whether the token is active for the app set in
+// the config. Tokens of other apps are reported as inactive.
+func (c *Client) Introspect(ctx context.Context, token string) (TokenInfo, error) {
+	const op = "ssoclient.Introspect"
+
+	resp, err := c.api.Introspect(c.appContext(ctx), &ssov1.IntrospectRequest{Token: token})
+	if err != nil {
+		return TokenInfo{}, fmt.Errorf("%s: %w", op, convert(err))
+	}
+
+	if !resp.GetActive() {
+		return TokenInfo{}, nil
+	}
+
+	return TokenInfo{
+		Active:    true,
+		UserID:    resp.GetUid(),
+		AppID:     resp.GetAppId(),
+		Scopes:    resp.GetScopes(),
+		ExpiresAt: time.Unix(resp.GetExp(), 0),
+	}, nil
+}
+
+// appContext adds the app credentials of the config to the outgoing
+// metadata.
+func (c *Client) appContext(ctx context.Context) context.Context {
+	credentials := base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(c.cfg.AppID, 10) + ":" + c.cfg.AppSecret))
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Basic "+credentials)
+}
+
+// serviceConfig retries every method while the server is unavailable.
+func serviceConfig(maxAttempts int) string {
+	return fmt.Sprintf(`{"methodConfig": [{
+		"name": [{}],
+		"retryPolicy": {
+			"maxAttempts": %d,
+			"initialBackoff": "0.1s",
+			"maxBackoff": "1s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]}`, maxAttempts)
+}
+
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// enrollmentToken returns the MFA token the server attaches to Login errors
+// of users who have to enroll a second factor.
+func enrollmentToken(err error) (string, bool) {
+	if status.Code(err) != codes.FailedPrecondition {
+		return "", false
+	}
+
+	for _, detail := range status.Convert(err).Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if ok && info.GetReason() == "MFA_ENROLLMENT_REQUIRED" && info.GetMetadata()["mfa_token"] != "" {
+			return info.GetMetadata()["mfa_token"], true
+		}
+	}
+
+	return "", false
+}
+
+// convert maps the status codes used by the server to the errors of this
+// package. The status stays available through errors.As.
+func convert(err error) error {
+	var sentinel error
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		sentinel = ErrNotFound
+	case codes.AlreadyExists:
+		sentinel = ErrUserExists
+	case codes.Unauthenticated:
+		sentinel = ErrInvalidToken
+	case codes.InvalidArgument:
+		sentinel = ErrInvalidArgument
+	case codes.PermissionDenied:
+		sentinel = ErrPermissionDenied
+	case codes.ResourceExhausted:
+		sentinel = ErrLocked
+	default:
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
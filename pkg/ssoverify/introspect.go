@@ -0,0 +1,40 @@
+package ssoverify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/JSONStatham/sso/pkg/ssoclient"
+)
+
+// IntrospectionVerifier asks the service about every token, so revoked
+// tokens are rejected right away. The client has to be configured with the
+// credentials of the app tokens are issued for.
+type IntrospectionVerifier struct {
+	client *ssoclient.Client
+}
+
+func NewIntrospectionVerifier(client *ssoclient.Client) *IntrospectionVerifier {
+	return &IntrospectionVerifier{client: client}
+}
+
+func (v *IntrospectionVerifier) Verify(ctx context.Context, token string) (Principal, error) {
+	const op = "ssoverify.IntrospectionVerifier.Verify"
+
+	info, err := v.client.Introspect(ctx, token)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !info.Active || !info.ExpiresAt.After(time.Now()) {
+		return Principal{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	return Principal{
+		UserID:    info.UserID,
+		AppID:     info.AppID,
+		Scopes:    info.Scopes,
+		ExpiresAt: info.ExpiresAt,
+	}, nil
+}
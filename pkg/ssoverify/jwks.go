@@ -0,0 +1,209 @@
+package ssoverify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/utils/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultRefreshInterval    = 5 * time.Minute
+	DefaultMinRefreshInterval = 10 * time.Second
+)
+
+// JWKSConfig configures a JWKSVerifier.
+type JWKSConfig struct {
+	// URL of the JWKS document, usually the issuer followed by
+	// /.well-known/jwks.json.
+	URL string
+	// Issuer has to match the "iss" claim of tokens.
+	Issuer string
+	// AppID is the app tokens have to be issued for.
+	AppID int64
+	// AllowClient also accepts the tokens the app was issued for itself by
+	// the client credentials grant. Their principal has no UserID.
+	AllowClient bool
+	// RefreshInterval controls how long the keys are cached. Defaults to
+	// DefaultRefreshInterval.
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits how often a token signed by an unknown key
+	// may trigger a refresh. Defaults to DefaultMinRefreshInterval.
+	MinRefreshInterval time.Duration
+	// HTTPClient fetches the JWKS. Defaults to a client with a 5s timeout.
+	HTTPClient *http.Client
+}
+
+// JWKSVerifier verifies the signature and claims of tokens locally with the
+// public keys of the service. It cannot tell whether a token was revoked
+// before it expired, use an IntrospectionVerifier where that matters.
+type JWKSVerifier struct {
+	cfg JWKSConfig
+
+	mu        sync.Mutex
+	keys      keySet
+	fetchedAt time.Time
+}
+
+func NewJWKSVerifier(cfg JWKSConfig) *JWKSVerifier {
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = DefaultRefreshInterval
+	}
+	if cfg.MinRefreshInterval <= 0 {
+		cfg.MinRefreshInterval = DefaultMinRefreshInterval
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	return &JWKSVerifier{cfg: cfg}
+}
+
+func (v *JWKSVerifier) Verify(ctx context.Context, token string) (Principal, error) {
+	const op = "ssoverify.JWKSVerifier.Verify"
+
+	keys, err := v.keySet(ctx, tokenKeyID(token))
+	if err != nil {
+		return Principal{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	claims, err := jwt.ParseToken(keys, token, jwt.Validation{
+		Issuer:      v.cfg.Issuer,
+		Audience:    strconv.FormatInt(v.cfg.AppID, 10),
+		AllowClient: v.cfg.AllowClient,
+	})
+	if err != nil {
+		return Principal{}, fmt.Errorf("%s: %w: %v", op, ErrInvalidToken, err)
+	}
+
+	return Principal{
+		UserID:    claims.UID,
+		AppID:     claims.AppID,
+		TokenID:   claims.ID,
+		Scopes:    claims.Scopes,
+		Roles:     claims.Roles,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+// keySet returns the cached keys. They are refetched once they are older
+// than the refresh interval, or when kid is unknown and the keys were not
+// fetched within the minimum refresh interval, which picks up rotated keys
+// early.
+func (v *JWKSVerifier) keySet(ctx context.Context, kid string) (keySet, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	age := time.Since(v.fetchedAt)
+	_, known := v.keys[kid]
+
+	if v.keys != nil && age < v.cfg.RefreshInterval && (known || age < v.cfg.MinRefreshInterval) {
+		return v.keys, nil
+	}
+
+	keys, err := v.fetch(ctx)
+	if err != nil {
+		// Keep verifying with the previous keys while the service is
+		// unreachable.
+		if v.keys != nil {
+			return v.keys, nil
+		}
+
+		return nil, err
+	}
+
+	v.keys = keys
+	v.fetchedAt = time.Now()
+
+	return v.keys, nil
+}
+
+func (v *JWKSVerifier) fetch(ctx context.Context) (keySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := v.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: unexpected status %s", resp.Status)
+	}
+
+	var set jwt.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding jwks: %w", err)
+	}
+
+	keys := make(keySet, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwt.ParseJWK(jwk)
+		if err != nil {
+			// Skip keys of types this version does not know.
+			continue
+		}
+
+		keys[key.ID] = key
+	}
+
+	return keys, nil
+}
+
+// tokenKeyID returns the "kid" header of the token without verifying it.
+func tokenKeyID(token string) string {
+	header, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return ""
+	}
+
+	data, err := gojwt.NewParser().DecodeSegment(header)
+	if err != nil {
+		return ""
+	}
+
+	var h struct {
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return ""
+	}
+
+	return h.Kid
+}
+
+// keySet holds verification keys by id.
+type keySet map[string]jwt.Key
+
+func (s keySet) SigningKey() (jwt.Key, error) {
+	return jwt.Key{}, errors.New("jwks keys cannot sign tokens")
+}
+
+func (s keySet) VerificationKey(kid string) (jwt.Key, error) {
+	key, ok := s[kid]
+	if !ok {
+		return jwt.Key{}, jwt.ErrKeyNotFound
+	}
+
+	return key, nil
+}
+
+func (s keySet) PublicKeys() ([]jwt.Key, error) {
+	keys := make([]jwt.Key, 0, len(s))
+	for _, key := range s {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
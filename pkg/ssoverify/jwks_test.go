@@ -0,0 +1,211 @@
+package ssoverify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/utils/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testIssuer = "https://sso.example.com"
+
+// jwksServer serves the public keys of the current key set.
+type jwksServer struct {
+	*httptest.Server
+	keys     atomic.Pointer[jwt.KeySet]
+	requests atomic.Int32
+}
+
+func newJWKSServer(t *testing.T, key jwt.Key) *jwksServer {
+	t.Helper()
+
+	s := &jwksServer{}
+	s.keys.Store(jwt.NewKeySet(key))
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+
+		set, err := jwt.NewJWKS(s.keys.Load())
+		require.NoError(t, err)
+		require.NoError(t, json.NewEncoder(w).Encode(set))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestJWKSVerifier(t *testing.T) {
+	key := generateKey(t)
+	server := newJWKSServer(t, key)
+
+	v := NewJWKSVerifier(JWKSConfig{URL: server.URL, Issuer: testIssuer, AppID: 1})
+
+	user, app := model.User{ID: 7}, model.App{ID: 1}
+
+	token, err := jwt.NewToken(jwt.NewKeySet(key), testIssuer, user, app, []string{"admin"}, time.Minute)
+	require.NoError(t, err)
+
+	principal, err := v.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), principal.UserID)
+	assert.Equal(t, int64(1), principal.AppID)
+	assert.Equal(t, []string{"admin"}, principal.Roles)
+	assert.NotEmpty(t, principal.TokenID)
+
+	// The keys are cached
+	_, err = v.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), server.requests.Load())
+
+	otherApp, err := jwt.NewToken(jwt.NewKeySet(key), testIssuer, user, model.App{ID: 2}, nil, time.Minute)
+	require.NoError(t, err)
+
+	_, err = v.Verify(context.Background(), otherApp)
+	require.ErrorIs(t, err, ErrInvalidToken, "Tokens of other apps should be rejected")
+
+	otherIssuer, err := jwt.NewToken(jwt.NewKeySet(key), "https://evil.example.com", user, app, nil, time.Minute)
+	require.NoError(t, err)
+
+	_, err = v.Verify(context.Background(), otherIssuer)
+	require.ErrorIs(t, err, ErrInvalidToken, "Tokens of other issuers should be rejected")
+}
+
+func TestJWKSVerifier_ClientToken(t *testing.T) {
+	key := generateKey(t)
+	server := newJWKSServer(t, key)
+
+	token, err := jwt.NewClientToken(jwt.NewKeySet(key), testIssuer, model.App{ID: 1}, "reports:read", time.Minute)
+	require.NoError(t, err)
+
+	v := NewJWKSVerifier(JWKSConfig{URL: server.URL, Issuer: testIssuer, AppID: 1})
+	_, err = v.Verify(context.Background(), token)
+	require.ErrorIs(t, err, ErrInvalidToken, "Client tokens should be rejected unless allowed")
+
+	v = NewJWKSVerifier(JWKSConfig{URL: server.URL, Issuer: testIssuer, AppID: 1, AllowClient: true})
+	principal, err := v.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Zero(t, principal.UserID)
+	assert.Equal(t, int64(1), principal.AppID)
+	assert.Equal(t, []string{"reports:read"}, principal.Scopes)
+}
+
+func TestJWKSVerifier_RotatedKey(t *testing.T) {
+	key := generateKey(t)
+	server := newJWKSServer(t, key)
+
+	v := NewJWKSVerifier(JWKSConfig{
+		URL:                server.URL,
+		Issuer:             testIssuer,
+		AppID:              1,
+		MinRefreshInterval: time.Millisecond,
+	})
+
+	user, app := model.User{ID: 7}, model.App{ID: 1}
+
+	token, err := jwt.NewToken(jwt.NewKeySet(key), testIssuer, user, app, nil, time.Minute)
+	require.NoError(t, err)
+	_, err = v.Verify(context.Background(), token)
+	require.NoError(t, err)
+
+	// A token signed by a key published after the keys were cached
+	next := generateKey(t)
+	server.keys.Store(jwt.NewKeySet(next, key))
+	time.Sleep(2 * time.Millisecond)
+
+	token, err = jwt.NewToken(jwt.NewKeySet(next), testIssuer, user, app, nil, time.Minute)
+	require.NoError(t, err)
+
+	_, err = v.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), server.requests.Load())
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	key := generateKey(t)
+	server := newJWKSServer(t, key)
+
+	v := NewJWKSVerifier(JWKSConfig{URL: server.URL, Issuer: testIssuer, AppID: 1})
+	interceptor := UnaryServerInterceptor(v, "/test.Service/Public")
+
+	token, err := jwt.NewToken(jwt.NewKeySet(key), testIssuer, model.User{ID: 7}, model.App{ID: 1}, nil, time.Minute)
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		principal, ok := FromContext(ctx)
+		if !ok {
+			return nil, nil
+		}
+
+		return principal.UserID, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Private"}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), resp)
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Private"}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer invalid"))
+	_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Private"}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	resp, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Public"}, handler)
+	require.NoError(t, err)
+	assert.Nil(t, resp, "Public methods should not get a principal")
+}
+
+func TestMiddleware(t *testing.T) {
+	key := generateKey(t)
+	server := newJWKSServer(t, key)
+
+	v := NewJWKSVerifier(JWKSConfig{URL: server.URL, Issuer: testIssuer, AppID: 1})
+
+	handler := Middleware(v)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := FromContext(r.Context())
+		require.True(t, ok)
+		assert.Equal(t, int64(7), principal.UserID)
+	}))
+
+	token, err := jwt.NewToken(jwt.NewKeySet(key), testIssuer, model.User{ID: 7}, model.App{ID: 1}, nil, time.Minute)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "invalid_token")
+}
+
+func generateKey(t *testing.T) jwt.Key {
+	t.Helper()
+
+	key, err := jwt.GenerateKey(jwt.AlgEdDSA)
+	require.NoError(t, err)
+
+	return key
+}
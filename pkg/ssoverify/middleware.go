@@ -0,0 +1,117 @@
+package ssoverify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor verifies the bearer token sent as "authorization"
+// metadata and puts its principal into the context of the handler. Calls
+// of the public methods, given by full method name, are passed through.
+func UnaryServerInterceptor(v Verifier, publicMethods ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if slices.Contains(publicMethods, info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authenticate(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls.
+func StreamServerInterceptor(v Verifier, publicMethods ...string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if slices.Contains(publicMethods, info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		ctx, err := authenticate(ss.Context(), v)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// Middleware verifies the bearer token of the Authorization header and puts
+// its principal into the request context. Requests without a valid token
+// are answered with 401 Unauthorized.
+func Middleware(v Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r.Header.Values("Authorization"))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			principal, err := v.Verify(r.Context(), token)
+			if err != nil {
+				if errors.Is(err, ErrInvalidToken) {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+					return
+				}
+
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+func authenticate(ctx context.Context, v Verifier) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	token, ok := bearerToken(md.Get("authorization"))
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	principal, err := v.Verify(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		return nil, status.Error(codes.Unavailable, "failed to verify token")
+	}
+
+	return WithPrincipal(ctx, principal), nil
+}
+
+func bearerToken(values []string) (string, bool) {
+	for _, value := range values {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok && token != "" {
+			return token, true
+		}
+	}
+
+	return "", false
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,223 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestApps_Lifecycle(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+	name := "managed-app-" + gofakeit.UUID()
+
+	createResponse, err := st.AppClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{
+		Name:                  name,
+		RedirectUris:          []string{redirectURI},
+		GrantTypes:            []string{model.GrantAuthorizationCode, model.GrantClientCredentials},
+		AccessTokenTtlSeconds: 120,
+	})
+	require.NoError(t, err)
+
+	app := createResponse.GetApp()
+	require.NotZero(t, app.GetId())
+	assert.Equal(t, name, app.GetName())
+	assert.Equal(t, model.ClientConfidential, app.GetClientType())
+	assert.Equal(t, []string{redirectURI}, app.GetRedirectUris())
+	assert.Equal(t, int64(120), app.GetAccessTokenTtlSeconds())
+	assert.True(t, app.GetHasSecret())
+	require.NotEmpty(t, createResponse.GetClientSecret())
+
+	// The secret authenticates the client, the access token uses the app ttl
+	resp := postForm(t, st, "/token", url.Values{
+		"grant_type":    {model.GrantClientCredentials},
+		"client_id":     {strconv.FormatInt(app.GetId(), 10)},
+		"client_secret": {createResponse.GetClientSecret()},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, float64(120), decodeJSON(t, resp)["expires_in"])
+
+	getResponse, err := st.AppClient.GetApp(adminCtx, &ssov1.GetAppRequest{AppId: app.GetId()})
+	require.NoError(t, err)
+	assert.Equal(t, app, getResponse.GetApp())
+
+	listResponse, err := st.AppClient.ListApps(adminCtx, &ssov1.ListAppsRequest{})
+	require.NoError(t, err)
+	assert.Contains(t, listResponse.GetApps(), app)
+
+	updateResponse, err := st.AppClient.UpdateApp(adminCtx, &ssov1.UpdateAppRequest{
+		AppId:        app.GetId(),
+		Name:         name,
+		RedirectUris: []string{redirectURI, "https://example.com/callback"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, updateResponse.GetApp().GetRedirectUris(), 2)
+	assert.Empty(t, updateResponse.GetApp().GetGrantTypes())
+	assert.Zero(t, updateResponse.GetApp().GetAccessTokenTtlSeconds())
+	assert.True(t, updateResponse.GetApp().GetHasSecret())
+
+	// Rotating the secret invalidates the previous one
+	rotateResponse, err := st.AppClient.RotateAppSecret(adminCtx, &ssov1.RotateAppSecretRequest{AppId: app.GetId()})
+	require.NoError(t, err)
+	require.NotEmpty(t, rotateResponse.GetClientSecret())
+	assert.NotEqual(t, createResponse.GetClientSecret(), rotateResponse.GetClientSecret())
+
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type":    {model.GrantClientCredentials},
+		"client_id":     {strconv.FormatInt(app.GetId(), 10)},
+		"client_secret": {createResponse.GetClientSecret()},
+	})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type":    {model.GrantClientCredentials},
+		"client_id":     {strconv.FormatInt(app.GetId(), 10)},
+		"client_secret": {rotateResponse.GetClientSecret()},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	_, err = st.AppClient.DeleteApp(adminCtx, &ssov1.DeleteAppRequest{AppId: app.GetId()})
+	require.NoError(t, err)
+
+	_, err = st.AppClient.GetApp(adminCtx, &ssov1.GetAppRequest{AppId: app.GetId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestApps_PublicClientHasNoSecret(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+
+	createResponse, err := st.AppClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{
+		Name:         "public-app-" + gofakeit.UUID(),
+		ClientType:   model.ClientPublic,
+		RedirectUris: []string{redirectURI},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, createResponse.GetClientSecret())
+	assert.False(t, createResponse.GetApp().GetHasSecret())
+
+	_, err = st.AppClient.RotateAppSecret(adminCtx, &ssov1.RotateAppSecretRequest{AppId: createResponse.GetApp().GetId()})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestApps_LoginUsesAppSettings(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	createResponse, err := st.AppClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{
+		Name:                   "ttl-app-" + gofakeit.UUID(),
+		GrantTypes:             []string{model.GrantPassword},
+		AccessTokenTtlSeconds:  300,
+		RefreshTokenTtlSeconds: 600,
+	})
+	require.NoError(t, err)
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    createResponse.GetApp().GetId(),
+	})
+	require.NoError(t, err)
+
+	claims := verifyJWTToken(t, st, loginResponse.GetToken())
+	exp := time.Unix(int64(claims["exp"].(float64)), 0)
+	assert.WithinDuration(t, time.Now().Add(300*time.Second), exp, 5*time.Second)
+
+	// The app does not allow the refresh token grant
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{RefreshToken: loginResponse.GetRefreshToken()})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AppClient.UpdateApp(adminCtx, &ssov1.UpdateAppRequest{
+		AppId:      createResponse.GetApp().GetId(),
+		Name:       createResponse.GetApp().GetName(),
+		GrantTypes: []string{model.GrantAuthorizationCode},
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    createResponse.GetApp().GetId(),
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestApps_RequiresAdmin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AppClient.ListApps(ctx, &ssov1.ListAppsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AppClient.ListApps(suite.AdminContext(ctx, "invalid-token"), &ssov1.ListAppsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	userCtx := suite.AdminContext(ctx, login(ctx, t, st, email, password))
+
+	_, err = st.AppClient.ListApps(userCtx, &ssov1.ListAppsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestApps_InvalidInput(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+
+	tests := []struct {
+		name string
+		req  *ssov1.CreateAppRequest
+	}{
+		{
+			name: "Missing name",
+			req:  &ssov1.CreateAppRequest{},
+		},
+		{
+			name: "Unknown client type",
+			req:  &ssov1.CreateAppRequest{Name: gofakeit.UUID(), ClientType: "native"},
+		},
+		{
+			name: "Unknown grant type",
+			req:  &ssov1.CreateAppRequest{Name: gofakeit.UUID(), GrantTypes: []string{"implicit"}},
+		},
+		{
+			name: "Negative ttl",
+			req:  &ssov1.CreateAppRequest{Name: gofakeit.UUID(), AccessTokenTtlSeconds: -1},
+		},
+		{
+			name: "Relative redirect uri",
+			req:  &ssov1.CreateAppRequest{Name: gofakeit.UUID(), RedirectUris: []string{"/callback"}},
+		},
+		{
+			name: "Public client with client credentials",
+			req: &ssov1.CreateAppRequest{
+				Name:       gofakeit.UUID(),
+				ClientType: model.ClientPublic,
+				GrantTypes: []string{model.GrantClientCredentials},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AppClient.CreateApp(adminCtx, tt.req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
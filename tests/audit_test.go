@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"testing"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAudit_RecordsEvents(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+	uid, email, password := registerUser(ctx, t, st.AuthClient)
+
+	token := login(ctx, t, st, email, password)
+
+	_, err := st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{Token: token})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: uid, AppId: st.GetTestAppID(), Role: "admin"})
+	require.NoError(t, err)
+
+	// Failed logins back off, so the failure comes last
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: "wrong" + password, AppId: st.GetTestAppID()})
+	require.Error(t, err)
+
+	listResponse, err := st.AuditClient.ListAuditEvents(adminCtx, &ssov1.ListAuditEventsRequest{UserId: uid})
+	require.NoError(t, err)
+
+	events := listResponse.GetEvents()
+	require.Len(t, events, 5)
+
+	var types []string
+	for _, event := range events {
+		types = append(types, event.GetType())
+		assert.Equal(t, uid, event.GetTargetId())
+		assert.NotEmpty(t, event.GetHash())
+	}
+	assert.Equal(t, []string{
+		model.AuditLogin,
+		model.AuditRoleGranted,
+		model.AuditLogout,
+		model.AuditLogin,
+		model.AuditUserRegistered,
+	}, types)
+
+	failed := events[0]
+	assert.Equal(t, model.AuditFailure, failed.GetOutcome())
+	assert.Equal(t, "invalid_credentials", failed.GetReason())
+	assert.Equal(t, email, failed.GetEmail())
+	assert.NotEmpty(t, failed.GetPeer())
+	assert.Contains(t, failed.GetUserAgent(), "grpc-go")
+
+	// The role was granted by the admin
+	granted := events[1]
+	assert.Equal(t, model.AuditSuccess, granted.GetOutcome())
+	assert.NotZero(t, granted.GetActorId())
+	assert.NotEqual(t, uid, granted.GetActorId())
+	assert.Equal(t, st.GetTestAppID(), granted.GetAppId())
+	assert.Equal(t, "admin", granted.GetDetail())
+
+	loggedIn := events[3]
+	assert.Equal(t, model.AuditSuccess, loggedIn.GetOutcome())
+	assert.Equal(t, uid, loggedIn.GetActorId())
+	assert.Equal(t, st.GetTestAppID(), loggedIn.GetAppId())
+
+	verifyResponse, err := st.AuditClient.VerifyAuditLog(adminCtx, &ssov1.VerifyAuditLogRequest{})
+	require.NoError(t, err)
+	assert.True(t, verifyResponse.GetValid())
+	assert.GreaterOrEqual(t, verifyResponse.GetEvents(), int64(5))
+}
+
+func TestAudit_FiltersAndPages(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+	uid, email, password := registerUser(ctx, t, st.AuthClient)
+
+	for range 3 {
+		login(ctx, t, st, email, password)
+	}
+
+	req := &ssov1.ListAuditEventsRequest{UserId: uid, Type: model.AuditLogin, PageSize: 2}
+
+	firstPage, err := st.AuditClient.ListAuditEvents(adminCtx, req)
+	require.NoError(t, err)
+	require.Len(t, firstPage.GetEvents(), 2)
+	require.NotEmpty(t, firstPage.GetNextPageToken())
+
+	req.PageToken = firstPage.GetNextPageToken()
+
+	secondPage, err := st.AuditClient.ListAuditEvents(adminCtx, req)
+	require.NoError(t, err)
+	require.Len(t, secondPage.GetEvents(), 1)
+	assert.Empty(t, secondPage.GetNextPageToken())
+	assert.Less(t, secondPage.GetEvents()[0].GetId(), firstPage.GetEvents()[1].GetId())
+
+	// No event of the user happened in another app
+	otherApp, err := st.AuditClient.ListAuditEvents(adminCtx, &ssov1.ListAuditEventsRequest{UserId: uid, AppId: 1 << 40})
+	require.NoError(t, err)
+	assert.Empty(t, otherApp.GetEvents())
+}
+
+func TestAudit_RequiresAdmin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuditClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	userCtx := suite.AdminContext(ctx, login(ctx, t, st, email, password))
+
+	_, err = st.AuditClient.ListAuditEvents(userCtx, &ssov1.ListAuditEventsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AuditClient.VerifyAuditLog(userCtx, &ssov1.VerifyAuditLogRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAudit_InvalidInput(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+
+	tests := []struct {
+		name string
+		req  *ssov1.ListAuditEventsRequest
+	}{
+		{name: "Page size too large", req: &ssov1.ListAuditEventsRequest{PageSize: 501}},
+		{name: "Invalid page token", req: &ssov1.ListAuditEventsRequest{PageToken: "next"}},
+		{name: "Since after until", req: &ssov1.ListAuditEventsRequest{Since: 200, Until: 100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuditClient.ListAuditEvents(adminCtx, tt.req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
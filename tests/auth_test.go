@@ -2,16 +2,22 @@ package tests
 
 import (
 	"context"
-	"os"
+	"encoding/json"
+	"net/http"
+	"strconv"
 	"testing"
 	"time"
 
 	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	ssojwt "github.com/JSONStatham/sso/internal/utils/jwt"
 	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/JSONStatham/sso/tests/testutils"
 	"github.com/brianvoe/gofakeit"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -36,13 +42,164 @@ func TestRegisterLogin_HappyPath(t *testing.T) {
 	assert.NotEmpty(t, loginResponse.GetToken())
 
 	// Verify JWT token
-	claims := verifyJWTToken(t, loginResponse.GetToken())
+	claims := verifyJWTToken(t, st, loginResponse.GetToken())
 
 	// Verify expiration time
 	exp := time.Unix(int64(claims["exp"].(float64)), 0)
 	assert.InDelta(t, loginTime.Add(st.Cfg.TokenTTL).Unix(), exp.Unix(), 1)
 }
 
+func TestRefresh_RotatesTokens(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, loginResponse.GetRefreshToken())
+
+	refreshResponse, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: loginResponse.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, refreshResponse.GetToken())
+	assert.NotEqual(t, loginResponse.GetRefreshToken(), refreshResponse.GetRefreshToken())
+
+	claims := verifyJWTToken(t, st, refreshResponse.GetToken())
+	assert.Equal(t, float64(st.GetTestAppID()), claims["app_id"])
+
+	// The rotated token can be used exactly once
+	nextResponse, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: refreshResponse.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: refreshResponse.GetRefreshToken(),
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// The second use revokes the family, including the latest token
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: nextResponse.GetRefreshToken(),
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestRefresh_ReuseRevokesFamily(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+
+	refreshResponse, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: loginResponse.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+
+	// Presenting the already rotated token again is treated as theft
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: loginResponse.GetRefreshToken(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// ...and the whole family, including the latest token, is revoked
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: refreshResponse.GetRefreshToken(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestLogout_RevokesToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{Token: loginResponse.GetToken()})
+	require.NoError(t, err)
+
+	// A revoked token must not be accepted again
+	_, err = st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{Token: loginResponse.GetToken()})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestLogout_InvalidToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	expired, err := testutils.GenerateExpiredToken(st.SigningKey, 1, int32(st.GetTestAppID()))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		token        string
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Empty token",
+			token:        "",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Malformed token",
+			token:        "not-a-token",
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "Expired token",
+			token:        expired,
+			expectedCode: codes.Unauthenticated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{Token: tc.token})
+			require.Error(t, err)
+			assert.Equal(t, tc.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func TestJWKS_PublishesSigningKey(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	grpcResponse, err := st.AuthClient.JWKS(ctx, &ssov1.JWKSRequest{})
+	require.NoError(t, err)
+	require.Len(t, grpcResponse.GetKeys(), 1)
+	assert.Equal(t, st.SigningKey.ID, grpcResponse.GetKeys()[0].GetKid())
+	assert.Equal(t, "OKP", grpcResponse.GetKeys()[0].GetKty())
+
+	resp, err := http.Get(st.HTTPAddr + "/.well-known/jwks.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var set ssojwt.JWKS
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&set))
+	require.Len(t, set.Keys, 1)
+	assert.Equal(t, grpcResponse.GetKeys()[0].GetX(), set.Keys[0].X)
+}
+
 func Test__CannotRegisterTwice(t *testing.T) {
 	ctx, st := suite.New(t)
 	email, password := registerNewUser(ctx, t, st.AuthClient)
@@ -149,6 +306,14 @@ func Test_LoginInvalidInput(t *testing.T) {
 			appID:       st.GetTestAppID(),
 			expectedErr: "user not found",
 		},
+		{
+			// The password policy only applies to new passwords
+			name:        "Short password",
+			email:       gofakeit.Email(),
+			password:    "abc",
+			appID:       st.GetTestAppID(),
+			expectedErr: "user not found",
+		},
 	}
 
 	for _, tt := range testCases {
@@ -171,6 +336,11 @@ func Test_LoginInvalidInput(t *testing.T) {
 }
 
 func registerNewUser(ctx context.Context, t *testing.T, client ssov1.AuthClient) (email, password string) {
+	_, email, password = registerUser(ctx, t, client)
+	return email, password
+}
+
+func registerUser(ctx context.Context, t *testing.T, client ssov1.AuthClient) (uid int64, email, password string) {
 	email = gofakeit.Email()
 	password = generatePassword()
 
@@ -180,26 +350,30 @@ func registerNewUser(ctx context.Context, t *testing.T, client ssov1.AuthClient)
 	})
 	require.NoError(t, err)
 	assert.NotEmpty(t, registerResponse.GetUserId())
-	return email, password
+	return registerResponse.GetUserId(), email, password
 }
 
 func generatePassword() string {
 	return gofakeit.Password(true, true, true, true, false, passDefaultLen)
 }
 
-func verifyJWTToken(t *testing.T, token string) jwt.MapClaims {
+func verifyJWTToken(t *testing.T, st *suite.Suite, token string) jwt.MapClaims {
 	t.Helper()
 
 	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
+		return st.SigningKey.PublicKey, nil
 	})
 	require.NoError(t, err)
+	require.Equal(t, st.SigningKey.ID, parsedToken.Header["kid"])
 
 	claims, ok := parsedToken.Claims.(jwt.MapClaims)
 	require.True(t, ok)
 
 	// Verify standard claims
 	require.NotEmpty(t, claims["exp"])
+	require.Equal(t, st.Cfg.JWT.Issuer, claims["iss"])
+	require.Equal(t, strconv.FormatInt(int64(claims["uid"].(float64)), 10), claims["sub"])
+	require.Equal(t, strconv.FormatInt(int64(claims["app_id"].(float64)), 10), claims["aud"])
 
 	// Verify custom claims
 	require.NotEmpty(t, claims["uid"])
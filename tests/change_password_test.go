@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestChangePassword_KeepsCurrentSession(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	uid, email, password := registerUser(ctx, t, st.AuthClient)
+
+	current := loginPair(ctx, t, st, email, password)
+	other := loginPair(ctx, t, st, email, password)
+
+	newPassword := generatePassword()
+	changeResponse, err := st.AuthClient.ChangePassword(suite.UserContext(ctx, current.GetToken()), &ssov1.ChangePasswordRequest{
+		RefreshToken:    current.GetRefreshToken(),
+		CurrentPassword: password,
+		NewPassword:     newPassword,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, changeResponse.GetToken())
+	require.NotEmpty(t, changeResponse.GetRefreshToken())
+
+	// Access tokens issued before the change are rejected even within the
+	// same second, the returned one is not
+	_, err = st.AuthClient.IsAdmin(suite.UserContext(ctx, current.GetToken()), &ssov1.IsAdminRequest{UserId: uid})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.IsAdmin(suite.UserContext(ctx, changeResponse.GetToken()), &ssov1.IsAdminRequest{UserId: uid})
+	require.NoError(t, err)
+
+	// So are the ones of logins right after the change
+	_, err = st.AuthClient.IsAdmin(suite.UserContext(ctx, login(ctx, t, st, email, newPassword)), &ssov1.IsAdminRequest{UserId: uid})
+	require.NoError(t, err)
+
+	// The current session goes on with the returned pair
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: changeResponse.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+
+	// Every other session is ended
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: other.GetRefreshToken(),
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{Token: other.GetToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestChangePassword_WrongCurrentPassword(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	tokens := loginPair(ctx, t, st, email, password)
+	userCtx := suite.UserContext(ctx, tokens.GetToken())
+
+	_, err := st.AuthClient.ChangePassword(userCtx, &ssov1.ChangePasswordRequest{
+		RefreshToken:    tokens.GetRefreshToken(),
+		CurrentPassword: generatePassword(),
+		NewPassword:     generatePassword(),
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Wrong passwords are throttled like failed logins
+	_, err = st.AuthClient.ChangePassword(userCtx, &ssov1.ChangePasswordRequest{
+		RefreshToken:    tokens.GetRefreshToken(),
+		CurrentPassword: password,
+		NewPassword:     generatePassword(),
+	})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestChangePassword_InvalidInput(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	tokens := loginPair(ctx, t, st, email, password)
+
+	_, otherEmail, otherPassword := registerUser(ctx, t, st.AuthClient)
+	otherTokens := loginPair(ctx, t, st, otherEmail, otherPassword)
+
+	testCases := []struct {
+		name  string
+		token string
+		req   *ssov1.ChangePasswordRequest
+		code  codes.Code
+	}{
+		{
+			name:  "Short password",
+			token: tokens.GetToken(),
+			req: &ssov1.ChangePasswordRequest{
+				RefreshToken:    tokens.GetRefreshToken(),
+				CurrentPassword: password,
+				NewPassword:     "abc",
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name:  "Same password",
+			token: tokens.GetToken(),
+			req: &ssov1.ChangePasswordRequest{
+				RefreshToken:    tokens.GetRefreshToken(),
+				CurrentPassword: password,
+				NewPassword:     password,
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "Missing access token",
+			req: &ssov1.ChangePasswordRequest{
+				Token:           tokens.GetToken(),
+				RefreshToken:    tokens.GetRefreshToken(),
+				CurrentPassword: password,
+				NewPassword:     generatePassword(),
+			},
+			code: codes.Unauthenticated,
+		},
+		{
+			name:  "Invalid access token",
+			token: "not-a-token",
+			req: &ssov1.ChangePasswordRequest{
+				RefreshToken:    tokens.GetRefreshToken(),
+				CurrentPassword: password,
+				NewPassword:     generatePassword(),
+			},
+			code: codes.Unauthenticated,
+		},
+		{
+			name:  "Refresh token of another user",
+			token: tokens.GetToken(),
+			req: &ssov1.ChangePasswordRequest{
+				RefreshToken:    otherTokens.GetRefreshToken(),
+				CurrentPassword: password,
+				NewPassword:     generatePassword(),
+			},
+			code: codes.Unauthenticated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			callCtx := ctx
+			if tc.token != "" {
+				callCtx = suite.UserContext(ctx, tc.token)
+			}
+
+			_, err := st.AuthClient.ChangePassword(callCtx, tc.req)
+			require.Equal(t, tc.code, status.Code(err))
+		})
+	}
+}
+
+func loginPair(ctx context.Context, t *testing.T, st *suite.Suite, email, password string) *ssov1.LogingResponse {
+	t.Helper()
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+
+	return loginResponse
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealth_Serving(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	for _, service := range []string{"", ssov1.Auth_ServiceDesc.ServiceName, ssov1.AuditService_ServiceDesc.ServiceName} {
+		resp, err := st.HealthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err, service)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus(), service)
+	}
+
+	_, err := st.HealthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown.Service"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestHealth_NotServingWithoutStorage(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	require.NoError(t, st.App.Storage.Close())
+
+	assert.Eventually(t, func() bool {
+		resp, err := st.HealthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+		return err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHealth_NotServingWhileStopping(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := st.HealthClient.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+
+	stopped := make(chan struct{})
+	go func() {
+		st.App.GRPCSrv.Stop()
+		close(stopped)
+	}()
+
+	// Watchers learn about the shutdown while the pending calls finish
+	resp, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.GetStatus())
+
+	// Ending the watch lets the server stop gracefully
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIntrospect_ActiveToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+
+	claims := verifyJWTToken(t, st, loginResponse.GetToken())
+
+	resp, err := st.AuthClient.Introspect(st.AppContext(ctx), &ssov1.IntrospectRequest{
+		Token: loginResponse.GetToken(),
+	})
+	require.NoError(t, err)
+	assert.True(t, resp.GetActive())
+	assert.Equal(t, int64(claims["uid"].(float64)), resp.GetUid())
+	assert.Equal(t, st.GetTestAppID(), resp.GetAppId())
+	assert.InDelta(t, time.Now().Add(st.Cfg.TokenTTL).Unix(), resp.GetExp(), 1)
+
+	// Revoked tokens are no longer active
+	_, err = st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{Token: loginResponse.GetToken()})
+	require.NoError(t, err)
+
+	resp, err = st.AuthClient.Introspect(st.AppContext(ctx), &ssov1.IntrospectRequest{
+		Token: loginResponse.GetToken(),
+	})
+	require.NoError(t, err)
+	assert.False(t, resp.GetActive())
+	assert.Empty(t, resp.GetUid())
+}
+
+func TestIntrospect_InactiveToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.Introspect(st.AppContext(ctx), &ssov1.IntrospectRequest{
+		Token: "not-a-token",
+	})
+	require.NoError(t, err)
+	assert.False(t, resp.GetActive())
+}
+
+func TestIntrospect_RequiresAppCredentials(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	testCases := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{
+			name: "No credentials",
+			ctx:  ctx,
+		},
+		{
+			name: "Wrong secret",
+			ctx:  suite.AppContext(ctx, st.GetTestAppID(), "wrong-secret"),
+		},
+		{
+			name: "Unknown app",
+			ctx:  suite.AppContext(ctx, 1<<40, "secret"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := st.AuthClient.Introspect(tc.ctx, &ssov1.IntrospectRequest{Token: "token"})
+			require.Error(t, err)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		})
+	}
+}
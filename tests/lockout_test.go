@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLogin_BacksOffAfterFailure(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: generatePassword(),
+		AppId:    st.GetTestAppID(),
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Even the right password is rejected during the backoff
+	var header metadata.MD
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	}, grpc.Header(&header))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"1"}, header.Get("retry-after"))
+
+	time.Sleep(st.Cfg.Lockout.Backoff + 50*time.Millisecond)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+}
+
+func TestLogin_LocksAfterMaxAttempts(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	backoff := st.Cfg.Lockout.Backoff
+	for i := 0; i < st.Cfg.Lockout.MaxAttempts; i++ {
+		_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+			Email:    email,
+			Password: generatePassword(),
+			AppId:    st.GetTestAppID(),
+		})
+		require.Equal(t, codes.NotFound, status.Code(err))
+
+		time.Sleep(backoff + 50*time.Millisecond)
+		backoff *= 2
+	}
+
+	var header metadata.MD
+	_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	}, grpc.Header(&header))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Len(t, header.Get("retry-after"), 1)
+
+	retryAfter, err := strconv.Atoi(header.Get("retry-after")[0])
+	require.NoError(t, err)
+	assert.Greater(t, retryAfter, 5, "Account should be locked for the lock duration")
+
+	assert.Contains(t, scrapeMetrics(t, st), `sso_auth_lockouts_total{scope="email"} 1`)
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetrics_Exposed(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	login(ctx, t, st, email, password)
+
+	// Failed logins back off, so the failure comes last
+	_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: "wrong" + password, AppId: st.GetTestAppID()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Ids of apps that do not exist are not used as labels
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: 1 << 40})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	body := scrapeMetrics(t, st)
+
+	appID := strconv.FormatInt(st.GetTestAppID(), 10)
+	for _, want := range []string{
+		`sso_grpc_requests_total{code="OK",method="` + ssov1.Auth_Login_FullMethodName + `"} 1`,
+		`sso_grpc_requests_total{code="NotFound",method="` + ssov1.Auth_Login_FullMethodName + `"} 2`,
+		`sso_grpc_request_duration_seconds_count{code="OK",method="` + ssov1.Auth_Register_FullMethodName + `"} 1`,
+		`sso_auth_logins_total{app_id="` + appID + `",outcome="success",reason=""} 1`,
+		`sso_auth_logins_total{app_id="` + appID + `",outcome="failure",reason="invalid_credentials"} 1`,
+		`sso_auth_logins_total{app_id="unknown",outcome="failure",reason="invalid_app"} 1`,
+		`sso_auth_registrations_total{outcome="success",reason=""} 1`,
+		`sso_password_hash_duration_seconds_count{algorithm="argon2id",operation="hash"} 1`,
+		`sso_password_hash_duration_seconds_count{algorithm="argon2id",operation="compare"} 2`,
+		`sso_db_open_connections{driver="sqlite"}`,
+	} {
+		assert.Contains(t, body, want)
+	}
+}
+
+// scrapeMetrics returns the metrics exposed by the service once the metrics
+// server is up.
+func scrapeMetrics(t *testing.T, st *suite.Suite) string {
+	t.Helper()
+
+	var body []byte
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + st.Cfg.Metrics.Addr + "/metrics")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		body, err = io.ReadAll(resp.Body)
+
+		return err == nil && resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 50*time.Millisecond)
+
+	return string(body)
+}
@@ -0,0 +1,278 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/utils/totp"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMFA_EnrollAndLogin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	secret, recoveryCodes := enrollTOTP(ctx, t, st, email, password)
+	assert.Len(t, recoveryCodes, st.Cfg.MFA.RecoveryCodes)
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+	require.True(t, loginResponse.GetMfaRequired())
+	require.NotEmpty(t, loginResponse.GetMfaToken())
+	assert.Empty(t, loginResponse.GetToken(), "No token should be issued before the second factor")
+
+	// The code of the confirmation step has been used already
+	code, err := totp.Code(secret, time.Now().Add(totp.Period))
+	require.NoError(t, err)
+
+	verifyResponse, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: loginResponse.GetMfaToken(),
+		Code:     code,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, verifyResponse.GetRefreshToken())
+
+	claims := verifyJWTToken(t, st, verifyResponse.GetToken())
+	assert.Equal(t, float64(st.GetTestAppID()), claims["app_id"])
+
+	// The challenge is single-use
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: loginResponse.GetMfaToken(),
+		Code:     code,
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestMFA_RecoveryCode(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	_, recoveryCodes := enrollTOTP(ctx, t, st, email, password)
+
+	mfaToken := loginMFAToken(ctx, t, st, email, password)
+
+	_, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: mfaToken,
+		Code:     recoveryCodes[0],
+	})
+	require.NoError(t, err)
+
+	// Recovery codes are single-use too
+	mfaToken = loginMFAToken(ctx, t, st, email, password)
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: mfaToken,
+		Code:     recoveryCodes[0],
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestMFA_Disable(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	_, recoveryCodes := enrollTOTP(ctx, t, st, email, password)
+
+	mfaToken := loginMFAToken(ctx, t, st, email, password)
+	verifyResponse, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: mfaToken,
+		Code:     recoveryCodes[0],
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.DisableTOTP(suite.UserContext(ctx, verifyResponse.GetToken()), &ssov1.DisableTOTPRequest{
+		Code: recoveryCodes[1],
+	})
+	require.NoError(t, err)
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+	assert.False(t, loginResponse.GetMfaRequired())
+	assert.NotEmpty(t, loginResponse.GetToken())
+}
+
+func TestMFA_AppRequiresMFA(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	appID, err := st.App.Storage.CreateApp(ctx, "mfa-app-"+gofakeit.UUID())
+	require.NoError(t, err)
+	require.NoError(t, st.App.Storage.SetAppRequireMFA(ctx, appID, true))
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	mfaToken := enrollmentToken(t, err)
+	enrollCtx := suite.UserContext(ctx, mfaToken)
+
+	// The enrollment token only authorizes enrolling a second factor
+	_, err = st.AuthClient.IsAdmin(enrollCtx, &ssov1.IsAdminRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.DisableTOTP(enrollCtx, &ssov1.DisableTOTPRequest{Code: "000000"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	enrollResponse, err := st.AuthClient.EnrollTOTP(enrollCtx, &ssov1.EnrollTOTPRequest{})
+	require.NoError(t, err)
+
+	code, err := totp.Code(enrollResponse.GetSecret(), time.Now())
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ConfirmTOTP(enrollCtx, &ssov1.ConfirmTOTPRequest{Code: code})
+	require.NoError(t, err)
+
+	// The same token completes the login once the second factor is set up
+	code, err = totp.Code(enrollResponse.GetSecret(), time.Now().Add(totp.Period))
+	require.NoError(t, err)
+
+	verifyResponse, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: mfaToken,
+		Code:     code,
+	})
+	require.NoError(t, err)
+
+	claims := verifyJWTToken(t, st, verifyResponse.GetToken())
+	assert.Equal(t, float64(appID), claims["app_id"])
+
+	_, err = st.AuthClient.EnrollTOTP(enrollCtx, &ssov1.EnrollTOTPRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+	assert.True(t, loginResponse.GetMfaRequired())
+}
+
+func TestMFA_InvalidCode(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	userCtx := suite.UserContext(ctx, login(ctx, t, st, email, password))
+
+	enrollResponse, err := st.AuthClient.EnrollTOTP(userCtx, &ssov1.EnrollTOTPRequest{})
+	require.NoError(t, err)
+	assert.Contains(t, enrollResponse.GetUri(), "otpauth://totp/")
+
+	_, err = st.AuthClient.ConfirmTOTP(userCtx, &ssov1.ConfirmTOTPRequest{
+		Code: "000000",
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Without a confirmed secret login does not ask for a second factor
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+	assert.False(t, loginResponse.GetMfaRequired())
+}
+
+func TestMFA_RequiresAccessToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	token := login(ctx, t, st, email, password)
+
+	// A token in the request body does not authenticate the call
+	_, err := st.AuthClient.EnrollTOTP(ctx, &ssov1.EnrollTOTPRequest{Token: token})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.ConfirmTOTP(ctx, &ssov1.ConfirmTOTPRequest{Code: "000000"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.DisableTOTP(suite.UserContext(ctx, "not-a-token"), &ssov1.DisableTOTPRequest{Code: "000000"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// enrollTOTP enables TOTP for the user and returns the secret and the
+// recovery codes.
+func enrollTOTP(ctx context.Context, t *testing.T, st *suite.Suite, email, password string) (string, []string) {
+	t.Helper()
+
+	userCtx := suite.UserContext(ctx, login(ctx, t, st, email, password))
+
+	enrollResponse, err := st.AuthClient.EnrollTOTP(userCtx, &ssov1.EnrollTOTPRequest{})
+	require.NoError(t, err)
+	require.NotEmpty(t, enrollResponse.GetSecret())
+
+	code, err := totp.Code(enrollResponse.GetSecret(), time.Now())
+	require.NoError(t, err)
+
+	confirmResponse, err := st.AuthClient.ConfirmTOTP(userCtx, &ssov1.ConfirmTOTPRequest{
+		Code: code,
+	})
+	require.NoError(t, err)
+
+	return enrollResponse.GetSecret(), confirmResponse.GetRecoveryCodes()
+}
+
+func login(ctx context.Context, t *testing.T, st *suite.Suite, email, password string) string {
+	t.Helper()
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, loginResponse.GetToken())
+
+	return loginResponse.GetToken()
+}
+
+func loginMFAToken(ctx context.Context, t *testing.T, st *suite.Suite, email, password string) string {
+	t.Helper()
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+	require.True(t, loginResponse.GetMfaRequired())
+
+	return loginResponse.GetMfaToken()
+}
+
+// enrollmentToken returns the token of the ErrorInfo detail Login returns
+// to users who have to enroll a second factor.
+func enrollmentToken(t *testing.T, err error) string {
+	t.Helper()
+
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetReason() == "MFA_ENROLLMENT_REQUIRED" {
+			require.NotEmpty(t, info.GetMetadata()["mfa_token"])
+
+			return info.GetMetadata()["mfa_token"]
+		}
+	}
+
+	t.Fatalf("no enrollment detail in %v", err)
+
+	return ""
+}
@@ -0,0 +1,492 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/internal/utils/opaque"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURI = "http://localhost/callback"
+
+var csrfTokenRe = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func TestOAuth_AuthorizationCodeWithPKCE(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	clientID := createOAuthClient(ctx, t, st, model.ClientPublic)
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	verifier := gofakeit.UUID() + gofakeit.UUID()
+	params := authorizeParams(clientID, codeChallenge(verifier))
+
+	resp, err := http.Get(st.HTTPAddr + "/authorize?" + params.Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "DENY", resp.Header.Get("X-Frame-Options"))
+
+	code := authorizeCode(t, st, params, email, password)
+
+	// The verifier has to match the challenge
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+
+	tokens := decodeJSON(t, resp)
+	assert.Equal(t, "Bearer", tokens["token_type"])
+	assert.Equal(t, st.Cfg.TokenTTL.Seconds(), tokens["expires_in"])
+	require.NotEmpty(t, tokens["refresh_token"])
+
+	claims := verifyJWTToken(t, st, tokens["access_token"].(string))
+	assert.Equal(t, float64(clientID), claims["app_id"])
+
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"refresh_token": {tokens["refresh_token"].(string)},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	refreshed := decodeJSON(t, resp)
+	assert.NotEqual(t, tokens["refresh_token"], refreshed["refresh_token"])
+
+	// Revoking the refresh token ends its family
+	resp = postForm(t, st, "/revoke", url.Values{
+		"client_id": {strconv.FormatInt(clientID, 10)},
+		"token":     {refreshed["refresh_token"].(string)},
+	})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"refresh_token": {refreshed["refresh_token"].(string)},
+	})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_grant", decodeJSON(t, resp)["error"])
+}
+
+func TestOAuth_ReusedCodeRevokesTokens(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	clientID := createOAuthClient(ctx, t, st, model.ClientPublic)
+	otherClientID := createOAuthClient(ctx, t, st, model.ClientPublic)
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	verifier := gofakeit.UUID() + gofakeit.UUID()
+	code := authorizeCode(t, st, authorizeParams(clientID, codeChallenge(verifier)), email, password)
+
+	exchange := func(clientID int64) *http.Response {
+		return postForm(t, st, "/token", url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {strconv.FormatInt(clientID, 10)},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		})
+	}
+
+	// Another client cannot use up the code
+	resp := exchange(otherClientID)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_grant", decodeJSON(t, resp)["error"])
+
+	resp = exchange(clientID)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	tokens := decodeJSON(t, resp)
+
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"refresh_token": {tokens["refresh_token"].(string)},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	refreshed := decodeJSON(t, resp)
+
+	// Codes can only be exchanged once, presenting one again ends the
+	// session started with it
+	resp = exchange(clientID)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_grant", decodeJSON(t, resp)["error"])
+
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"refresh_token": {refreshed["refresh_token"].(string)},
+	})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_grant", decodeJSON(t, resp)["error"])
+}
+
+func TestOAuth_InvalidCodeExchange(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	clientID := createOAuthClient(ctx, t, st, model.ClientPublic)
+	otherClientID := createOAuthClient(ctx, t, st, model.ClientPublic)
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	verifier := gofakeit.UUID() + gofakeit.UUID()
+
+	testCases := []struct {
+		name     string
+		clientID int64
+		redirect string
+		verifier string
+	}{
+		{name: "Wrong verifier", clientID: clientID, redirect: redirectURI, verifier: "wrong" + verifier},
+		{name: "Missing verifier", clientID: clientID, redirect: redirectURI, verifier: ""},
+		{name: "Other redirect uri", clientID: clientID, redirect: redirectURI + "/other", verifier: verifier},
+		{name: "Other client", clientID: otherClientID, redirect: redirectURI, verifier: verifier},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code := authorizeCode(t, st, authorizeParams(clientID, codeChallenge(verifier)), email, password)
+
+			resp := postForm(t, st, "/token", url.Values{
+				"grant_type":    {"authorization_code"},
+				"client_id":     {strconv.FormatInt(tc.clientID, 10)},
+				"code":          {code},
+				"redirect_uri":  {tc.redirect},
+				"code_verifier": {tc.verifier},
+			})
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.Equal(t, "invalid_grant", decodeJSON(t, resp)["error"])
+		})
+	}
+}
+
+func TestOAuth_InvalidAuthorizationRequest(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	clientID := createOAuthClient(ctx, t, st, model.ClientPublic)
+	challenge := codeChallenge(gofakeit.UUID() + gofakeit.UUID())
+
+	client := noRedirectClient()
+
+	// Unregistered redirect URIs are never redirected to
+	params := authorizeParams(clientID, challenge)
+	params.Set("redirect_uri", "http://evil.example.com/callback")
+
+	resp, err := client.Get(st.HTTPAddr + "/authorize?" + params.Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	testCases := []struct {
+		name   string
+		modify func(url.Values)
+		error  string
+	}{
+		{name: "Missing PKCE", modify: func(v url.Values) { v.Del("code_challenge") }, error: "invalid_request"},
+		{name: "Plain PKCE", modify: func(v url.Values) { v.Set("code_challenge_method", "plain") }, error: "invalid_request"},
+		{name: "Token response", modify: func(v url.Values) { v.Set("response_type", "token") }, error: "unsupported_response_type"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := authorizeParams(clientID, challenge)
+			tc.modify(params)
+
+			resp, err := client.Get(st.HTTPAddr + "/authorize?" + params.Encode())
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusFound, resp.StatusCode)
+
+			location, err := url.Parse(resp.Header.Get("Location"))
+			require.NoError(t, err)
+			assert.Equal(t, tc.error, location.Query().Get("error"))
+			assert.Equal(t, "xyz", location.Query().Get("state"))
+		})
+	}
+}
+
+func TestOAuth_ClientCredentials(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	clientID := createOAuthClient(ctx, t, st, model.ClientConfidential)
+	secret := setOAuthClientSecret(ctx, t, st, clientID)
+
+	req, err := http.NewRequest(http.MethodPost, st.HTTPAddr+"/token", strings.NewReader(url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {"reports:read"},
+	}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(strconv.FormatInt(clientID, 10), secret)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	tokens := decodeJSON(t, resp)
+	assert.Equal(t, "reports:read", tokens["scope"])
+	assert.Empty(t, tokens["refresh_token"])
+
+	// Wrong secret
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"client_secret": {"wrong-secret"},
+	})
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "invalid_client", decodeJSON(t, resp)["error"])
+
+	// Public clients have no credentials of their own
+	publicID := createOAuthClient(ctx, t, st, model.ClientPublic)
+
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {strconv.FormatInt(publicID, 10)},
+	})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "unauthorized_client", decodeJSON(t, resp)["error"])
+}
+
+func TestOAuth_ClientTokenIntrospectAndRevoke(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	clientID := createOAuthClient(ctx, t, st, model.ClientConfidential)
+	secret := setOAuthClientSecret(ctx, t, st, clientID)
+
+	resp := postForm(t, st, "/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"client_secret": {secret},
+		"scope":         {"reports:read"},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	token := decodeJSON(t, resp)["access_token"].(string)
+
+	appCtx := suite.AppContext(ctx, clientID, secret)
+
+	// The token of the app itself is active but has no user
+	info, err := st.AuthClient.Introspect(appCtx, &ssov1.IntrospectRequest{Token: token})
+	require.NoError(t, err)
+	assert.True(t, info.GetActive())
+	assert.Zero(t, info.GetUid())
+	assert.Equal(t, clientID, info.GetAppId())
+	assert.Equal(t, []string{"reports:read"}, info.GetScopes())
+
+	// Other apps do not learn about it
+	info, err = st.AuthClient.Introspect(st.AppContext(ctx), &ssov1.IntrospectRequest{Token: token})
+	require.NoError(t, err)
+	assert.False(t, info.GetActive())
+
+	resp = postForm(t, st, "/revoke", url.Values{
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"client_secret": {secret},
+		"token":         {token},
+	})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	info, err = st.AuthClient.Introspect(appCtx, &ssov1.IntrospectRequest{Token: token})
+	require.NoError(t, err)
+	assert.False(t, info.GetActive())
+}
+
+func TestOAuth_LoginErrorsShowForm(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	clientID := createOAuthClient(ctx, t, st, model.ClientPublic)
+	email, _ := registerNewUser(ctx, t, st.AuthClient)
+
+	client, form := loginForm(t, st, authorizeParams(clientID, codeChallenge(gofakeit.UUID()+gofakeit.UUID())))
+	form.Set("email", email)
+	form.Set("password", "wrong password")
+
+	resp, err := client.PostForm(st.HTTPAddr+"/authorize", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Location"))
+}
+
+func TestOAuth_LoginFormRequiresCSRFToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	clientID := createOAuthClient(ctx, t, st, model.ClientPublic)
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	params := authorizeParams(clientID, codeChallenge(gofakeit.UUID()+gofakeit.UUID()))
+
+	client, form := loginForm(t, st, params)
+	form.Set("email", email)
+	form.Set("password", password)
+
+	_, otherForm := loginForm(t, st, params)
+
+	testCases := []struct {
+		name   string
+		client *http.Client
+		token  string
+	}{
+		// A site posting the form cannot send the cookie of the session
+		{name: "Without session", client: noRedirectClient(), token: form.Get("csrf_token")},
+		{name: "Without token", client: client, token: ""},
+		{name: "Token of another session", client: client, token: otherForm.Get("csrf_token")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			forged := url.Values{}
+			for key, value := range form {
+				forged[key] = value
+			}
+			forged.Set("csrf_token", tc.token)
+
+			resp, err := tc.client.PostForm(st.HTTPAddr+"/authorize", forged)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get("Location"))
+		})
+	}
+
+	resp, err := client.PostForm(st.HTTPAddr+"/authorize", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+}
+
+func createOAuthClient(ctx context.Context, t *testing.T, st *suite.Suite, clientType string) int64 {
+	t.Helper()
+
+	appID, err := st.App.Storage.CreateApp(ctx, "oauth-app-"+gofakeit.UUID())
+	require.NoError(t, err)
+	require.NoError(t, st.App.Storage.SetAppClientType(ctx, appID, clientType))
+	require.NoError(t, st.App.Storage.AddAppRedirectURI(ctx, appID, redirectURI))
+
+	return appID
+}
+
+func setOAuthClientSecret(ctx context.Context, t *testing.T, st *suite.Suite, appID int64) string {
+	t.Helper()
+
+	secret, secretHash, err := opaque.New()
+	require.NoError(t, err)
+	require.NoError(t, st.App.Storage.SetAppSecret(ctx, appID, secretHash))
+
+	return secret
+}
+
+func authorizeParams(clientID int64, challenge string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.FormatInt(clientID, 10)},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// authorizeCode submits the login form and returns the code the user is
+// redirected back with.
+func authorizeCode(t *testing.T, st *suite.Suite, params url.Values, email, password string) string {
+	t.Helper()
+
+	client, form := loginForm(t, st, params)
+	form.Set("email", email)
+	form.Set("password", password)
+
+	resp, err := client.PostForm(st.HTTPAddr+"/authorize", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, redirectURI, location.Scheme+"://"+location.Host+location.Path)
+	require.Equal(t, params.Get("state"), location.Query().Get("state"))
+	require.NotEmpty(t, location.Query().Get("code"))
+
+	return location.Query().Get("code")
+}
+
+// loginForm shows the login form of the authorization request and returns
+// the client holding its session with the fields of the form.
+func loginForm(t *testing.T, st *suite.Suite, params url.Values) (*http.Client, url.Values) {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+
+	client := noRedirectClient()
+	client.Jar = jar
+
+	resp, err := client.Get(st.HTTPAddr + "/authorize?" + params.Encode())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	match := csrfTokenRe.FindSubmatch(body)
+	require.NotNil(t, match, "The form should carry a csrf token")
+
+	form := url.Values{"csrf_token": {string(match[1])}}
+	for key, value := range params {
+		form[key] = value
+	}
+
+	return client, form
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func postForm(t *testing.T, st *suite.Suite, path string, form url.Values) *http.Response {
+	t.Helper()
+
+	resp, err := http.PostForm(st.HTTPAddr+path, form)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func decodeJSON(t *testing.T, resp *http.Response) map[string]any {
+	t.Helper()
+	defer resp.Body.Close()
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	return body
+}
+
+func noRedirectClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/brianvoe/gofakeit"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDC_IDTokenAndUserInfo(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	clientID := createOAuthClient(ctx, t, st, model.ClientPublic)
+	uid, email, password := registerUser(ctx, t, st.AuthClient)
+
+	verifier := gofakeit.UUID() + gofakeit.UUID()
+	params := authorizeParams(clientID, codeChallenge(verifier))
+	params.Set("scope", "openid email")
+	params.Set("nonce", "n-0S6_WzA2Mj")
+
+	code := authorizeCode(t, st, params, email, password)
+
+	resp := postForm(t, st, "/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	tokens := decodeJSON(t, resp)
+	require.NotEmpty(t, tokens["id_token"])
+
+	idToken, err := jwt.Parse(tokens["id_token"].(string), func(token *jwt.Token) (interface{}, error) {
+		return st.SigningKey.PublicKey, nil
+	}, jwt.WithIssuer(st.Cfg.JWT.Issuer), jwt.WithAudience(strconv.FormatInt(clientID, 10)))
+	require.NoError(t, err)
+
+	claims := idToken.Claims.(jwt.MapClaims)
+	assert.Equal(t, strconv.FormatInt(uid, 10), claims["sub"])
+	assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+	assert.Equal(t, email, claims["email"])
+	assert.Equal(t, false, claims["email_verified"])
+	assert.InDelta(t, time.Now().Unix(), claims["auth_time"], 5)
+
+	req, err := http.NewRequest(http.MethodGet, st.HTTPAddr+"/userinfo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens["access_token"].(string))
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	info := decodeJSON(t, resp)
+	assert.Equal(t, strconv.FormatInt(uid, 10), info["sub"])
+	assert.Equal(t, email, info["email"])
+	assert.Equal(t, false, info["email_verified"])
+
+	// Without the openid scope there is no ID token
+	params.Set("scope", "profile")
+	code = authorizeCode(t, st, params, email, password)
+
+	resp = postForm(t, st, "/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.FormatInt(clientID, 10)},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, decodeJSON(t, resp)["id_token"])
+}
+
+func TestOIDC_UserInfoRequiresToken(t *testing.T) {
+	_, st := suite.New(t)
+
+	for _, header := range []string{"", "Bearer not-a-token"} {
+		req, err := http.NewRequest(http.MethodGet, st.HTTPAddr+"/userinfo", nil)
+		require.NoError(t, err)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Bearer")
+	}
+}
+
+func TestOIDC_Discovery(t *testing.T) {
+	_, st := suite.New(t)
+
+	resp, err := http.Get(st.HTTPAddr + "/.well-known/openid-configuration")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	config := decodeJSON(t, resp)
+	issuer := st.Cfg.JWT.Issuer
+	assert.Equal(t, issuer, config["issuer"])
+	assert.Equal(t, issuer+"/authorize", config["authorization_endpoint"])
+	assert.Equal(t, issuer+"/token", config["token_endpoint"])
+	assert.Equal(t, issuer+"/userinfo", config["userinfo_endpoint"])
+	assert.Equal(t, issuer+"/.well-known/jwks.json", config["jwks_uri"])
+	assert.Equal(t, []any{st.SigningKey.Algorithm}, config["id_token_signing_alg_values_supported"])
+	assert.Equal(t, []any{"S256"}, config["code_challenge_methods_supported"])
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"testing"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/password"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPasswordPolicy_Register(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	testCases := []struct {
+		name     string
+		password string
+		rules    []string
+	}{
+		{name: "Short and guessable", password: "abc123", rules: []string{password.RuleMinLength, password.RuleStrength}},
+		{name: "Common", password: "Password123", rules: []string{password.RuleStrength}},
+		{name: "Breached", password: "correct horse battery staple", rules: []string{password.RuleBreached}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+				Email:    email,
+				Password: tc.password,
+			})
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Equal(t, tc.rules, violatedRules(t, err, "password"))
+		})
+	}
+}
+
+func TestPasswordPolicy_ResetKeepsToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, _ := registerNewUser(ctx, t, st.AuthClient)
+
+	_, err := st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+	require.NoError(t, err)
+	token := st.MailToken(email)
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:    token,
+		Password: "qwerty",
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, violatedRules(t, err, "password"), password.RuleMinLength)
+
+	// A rejected password does not use up the token
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:    token,
+		Password: generatePassword(),
+	})
+	require.NoError(t, err)
+}
+
+func TestPasswordPolicy_ChangePassword(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, pass := registerNewUser(ctx, t, st.AuthClient)
+	tokens := loginPair(ctx, t, st, email, pass)
+
+	_, err := st.AuthClient.ChangePassword(suite.UserContext(ctx, tokens.GetToken()), &ssov1.ChangePasswordRequest{
+		RefreshToken:    tokens.GetRefreshToken(),
+		CurrentPassword: pass,
+		NewPassword:     email,
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, violatedRules(t, err, "new_password"), password.RuleEmail)
+}
+
+// violatedRules returns the reasons of the BadRequest field violations of
+// the status error, which all have to be about the field.
+func violatedRules(t *testing.T, err error, field string) []string {
+	t.Helper()
+
+	var rules []string
+	for _, detail := range status.Convert(err).Details() {
+		badRequest, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+
+		for _, v := range badRequest.GetFieldViolations() {
+			assert.Equal(t, field, v.GetField())
+			assert.NotEmpty(t, v.GetDescription())
+			rules = append(rules, v.GetReason())
+		}
+	}
+	require.NotEmpty(t, rules, "Status should list the violated rules")
+
+	return rules
+}
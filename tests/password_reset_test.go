@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"testing"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPasswordReset_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+	require.NoError(t, err)
+
+	msg := st.LastMail(email)
+	assert.Equal(t, "Reset your password", msg.Header.Get("Subject"))
+	token := st.MailToken(email)
+
+	newPassword := generatePassword()
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:    token,
+		Password: newPassword,
+	})
+	require.NoError(t, err)
+
+	login(ctx, t, st, email, newPassword)
+
+	// Every session the user had is ended
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: loginResponse.GetRefreshToken(),
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{Token: loginResponse.GetToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Reset tokens are single-use
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:    token,
+		Password: generatePassword(),
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.Equal(t, codes.NotFound, status.Code(err), "The old password should be rejected")
+}
+
+func TestPasswordReset_OnlyLatestRequestCounts(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, _ := registerNewUser(ctx, t, st.AuthClient)
+
+	_, err := st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+	require.NoError(t, err)
+	first := st.MailToken(email)
+
+	_, err = st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+	require.NoError(t, err)
+	second := st.MailToken(email)
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:    second,
+		Password: generatePassword(),
+	})
+	require.NoError(t, err)
+
+	// A reset invalidates the other pending links
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:    first,
+		Password: generatePassword(),
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestPasswordReset_UnknownEmail(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// Unknown emails are not revealed
+	_, err := st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: gofakeit.Email()})
+	require.NoError(t, err)
+}
+
+func TestPasswordReset_InvalidInput(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	testCases := []struct {
+		name     string
+		token    string
+		password string
+		code     codes.Code
+	}{
+		{name: "Empty token", token: "", password: generatePassword(), code: codes.InvalidArgument},
+		{name: "Empty password", token: "token", password: "", code: codes.InvalidArgument},
+		{name: "Unknown token", token: "token", password: generatePassword(), code: codes.Unauthenticated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+				Token:    tc.token,
+				Password: tc.password,
+			})
+			require.Equal(t, tc.code, status.Code(err))
+		})
+	}
+}
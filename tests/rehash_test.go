@@ -0,0 +1,34 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLogin_RehashesOutdatedHash(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// A user created before the switch to Argon2id
+	email, password := gofakeit.Email(), generatePassword()
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	_, err = st.App.Storage.SaveUser(ctx, email, legacyHash)
+	require.NoError(t, err)
+
+	login(ctx, t, st, email, password)
+
+	user, err := st.App.Storage.User(ctx, email)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(user.Password), "$argon2id$v=19$"), "Hash should be replaced")
+
+	// The new hash verifies and does not end the session
+	login(ctx, t, st, email, password)
+	assert.Zero(t, user.TokenVersion)
+}
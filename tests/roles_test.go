@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/domain/model"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const globalAppID = 0
+
+func TestRoles_GlobalAdmin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+	uid, email, password := registerUser(ctx, t, st.AuthClient)
+
+	isAdminResponse, err := st.AuthClient.IsAdmin(adminCtx, &ssov1.IsAdminRequest{UserId: uid})
+	require.NoError(t, err)
+	assert.False(t, isAdminResponse.GetIsAdmin())
+
+	_, err = st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: uid, AppId: globalAppID, Role: "admin"})
+	require.NoError(t, err)
+
+	isAdminResponse, err = st.AuthClient.IsAdmin(adminCtx, &ssov1.IsAdminRequest{UserId: uid})
+	require.NoError(t, err)
+	assert.True(t, isAdminResponse.GetIsAdmin())
+
+	// Global roles apply in every app and end up in issued tokens
+	permissionResponse, err := st.AuthClient.CheckPermission(st.AppContext(ctx), &ssov1.CheckPermissionRequest{
+		UserId:     uid,
+		AppId:      st.GetTestAppID(),
+		Permission: "roles:manage",
+	})
+	require.NoError(t, err)
+	assert.True(t, permissionResponse.GetAllowed())
+
+	loginResponse, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+
+	claims := verifyJWTToken(t, st, loginResponse.GetToken())
+	assert.Equal(t, []interface{}{"admin"}, claims["roles"])
+
+	_, err = st.AuthClient.RevokeRole(adminCtx, &ssov1.RevokeRoleRequest{UserId: uid, AppId: globalAppID, Role: "admin"})
+	require.NoError(t, err)
+
+	isAdminResponse, err = st.AuthClient.IsAdmin(adminCtx, &ssov1.IsAdminRequest{UserId: uid})
+	require.NoError(t, err)
+	assert.False(t, isAdminResponse.GetIsAdmin())
+}
+
+func TestRoles_PerApp(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+	uid, email, password := registerUser(ctx, t, st.AuthClient)
+
+	_, err := st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: uid, AppId: st.GetTestAppID(), Role: "admin"})
+	require.NoError(t, err)
+
+	// An app scoped role does not make the user a global admin, users may
+	// ask about themselves
+	userCtx := suite.AdminContext(ctx, login(ctx, t, st, email, password))
+
+	isAdminResponse, err := st.AuthClient.IsAdmin(userCtx, &ssov1.IsAdminRequest{UserId: uid})
+	require.NoError(t, err)
+	assert.False(t, isAdminResponse.GetIsAdmin())
+
+	permissionResponse, err := st.AuthClient.CheckPermission(st.AppContext(ctx), &ssov1.CheckPermissionRequest{
+		UserId:     uid,
+		AppId:      st.GetTestAppID(),
+		Permission: "apps:manage",
+	})
+	require.NoError(t, err)
+	assert.True(t, permissionResponse.GetAllowed())
+
+	permissionResponse, err = st.AuthClient.CheckPermission(st.AppContext(ctx), &ssov1.CheckPermissionRequest{
+		UserId:     uid,
+		AppId:      st.GetTestAppID(),
+		Permission: "unknown:permission",
+	})
+	require.NoError(t, err)
+	assert.False(t, permissionResponse.GetAllowed())
+}
+
+func TestRoles_InvalidInput(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := adminContext(ctx, t, st)
+	uid, _, _ := registerUser(ctx, t, st.AuthClient)
+
+	testCases := []struct {
+		name         string
+		req          *ssov1.GrantRoleRequest
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Unknown role",
+			req:          &ssov1.GrantRoleRequest{UserId: uid, AppId: globalAppID, Role: "superuser"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "Unknown user",
+			req:          &ssov1.GrantRoleRequest{UserId: 1 << 40, AppId: globalAppID, Role: "admin"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "Unknown app",
+			req:          &ssov1.GrantRoleRequest{UserId: uid, AppId: 1 << 40, Role: "admin"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "Empty role",
+			req:          &ssov1.GrantRoleRequest{UserId: uid, AppId: globalAppID},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := st.AuthClient.GrantRole(adminCtx, tc.req)
+			require.Error(t, err)
+			assert.Equal(t, tc.expectedCode, status.Code(err))
+		})
+	}
+
+	_, err := st.AuthClient.IsAdmin(adminCtx, &ssov1.IsAdminRequest{UserId: 1 << 40})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestRoles_RequireCredentials(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	uid, email, password := registerUser(ctx, t, st.AuthClient)
+	other, _, _ := registerUser(ctx, t, st.AuthClient)
+	userCtx := suite.AdminContext(ctx, login(ctx, t, st, email, password))
+
+	// Calls without credentials are rejected before reaching the handler
+	_, err := st.AuthClient.GrantRole(ctx, &ssov1.GrantRoleRequest{UserId: uid, AppId: globalAppID, Role: "admin"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: uid})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.CheckPermission(ctx, &ssov1.CheckPermissionRequest{
+		UserId:     uid,
+		AppId:      st.GetTestAppID(),
+		Permission: "apps:manage",
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Users cannot grant themselves roles or ask about others
+	_, err = st.AuthClient.GrantRole(userCtx, &ssov1.GrantRoleRequest{UserId: uid, AppId: globalAppID, Role: "admin"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AuthClient.IsAdmin(userCtx, &ssov1.IsAdminRequest{UserId: other})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// App credentials do not authenticate a user
+	_, err = st.AuthClient.GrantRole(st.AppContext(ctx), &ssov1.GrantRoleRequest{UserId: uid, AppId: globalAppID, Role: "admin"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Apps may only check permissions in their own app
+	otherApp := createOAuthClient(ctx, t, st, model.ClientConfidential)
+
+	_, err = st.AuthClient.CheckPermission(st.AppContext(ctx), &ssov1.CheckPermissionRequest{
+		UserId:     uid,
+		AppId:      otherApp,
+		Permission: "apps:manage",
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// adminContext registers a global admin and returns a context carrying
+// their access token. The first admin cannot be created over the API, so
+// the role is granted in storage.
+func adminContext(ctx context.Context, t *testing.T, st *suite.Suite) context.Context {
+	t.Helper()
+
+	uid, email, password := registerUser(ctx, t, st.AuthClient)
+
+	require.NoError(t, st.App.Storage.GrantRole(ctx, uid, globalAppID, "admin"))
+
+	return suite.AdminContext(ctx, login(ctx, t, st, email, password))
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/internal/http/jwks"
+	"github.com/JSONStatham/sso/pkg/ssoclient"
+	"github.com/JSONStatham/sso/pkg/ssoverify"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestSDK_LoginAndVerify(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	client := newSDKClient(t, st)
+
+	email, password := gofakeit.Email(), generatePassword()
+
+	uid, err := client.Register(ctx, email, password)
+	require.NoError(t, err)
+
+	result, err := client.Login(ctx, email, password, st.GetTestAppID())
+	require.NoError(t, err)
+	require.False(t, result.MFARequired)
+	require.NotEmpty(t, result.AccessToken)
+
+	local := ssoverify.NewJWKSVerifier(ssoverify.JWKSConfig{
+		URL:    st.HTTPAddr + jwks.Path,
+		Issuer: st.Cfg.JWT.Issuer,
+		AppID:  st.GetTestAppID(),
+	})
+
+	principal, err := local.Verify(ctx, result.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, uid, principal.UserID)
+	assert.Equal(t, st.GetTestAppID(), principal.AppID)
+
+	remote := ssoverify.NewIntrospectionVerifier(client)
+
+	principal, err = remote.Verify(ctx, result.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, uid, principal.UserID)
+
+	// Only introspection notices revoked tokens
+	require.NoError(t, client.Logout(ctx, result.AccessToken))
+
+	_, err = remote.Verify(ctx, result.AccessToken)
+	require.ErrorIs(t, err, ssoverify.ErrInvalidToken)
+
+	_, err = local.Verify(ctx, result.AccessToken)
+	require.NoError(t, err)
+
+	// Wrong password
+	_, err = client.Login(ctx, email, "wrong-password", st.GetTestAppID())
+	require.ErrorIs(t, err, ssoclient.ErrInvalidCredentials)
+}
+
+func TestSDK_LoginEnrollmentRequired(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	client := newSDKClient(t, st)
+
+	appID, err := st.App.Storage.CreateApp(ctx, "mfa-app-"+gofakeit.UUID())
+	require.NoError(t, err)
+	require.NoError(t, st.App.Storage.SetAppRequireMFA(ctx, appID, true))
+
+	email, password := gofakeit.Email(), generatePassword()
+
+	_, err = client.Register(ctx, email, password)
+	require.NoError(t, err)
+
+	result, err := client.Login(ctx, email, password, appID)
+	require.NoError(t, err)
+	assert.True(t, result.MFAEnrollmentRequired)
+	assert.False(t, result.MFARequired)
+	assert.NotEmpty(t, result.MFAToken)
+	assert.Empty(t, result.AccessToken)
+
+	_, err = client.Raw().EnrollTOTP(suite.UserContext(ctx, result.MFAToken), &ssov1.EnrollTOTPRequest{})
+	require.NoError(t, err)
+}
+
+func newSDKClient(t *testing.T, st *suite.Suite) *ssoclient.Client {
+	t.Helper()
+
+	client, err := ssoclient.New(ssoclient.Config{
+		Addr:      net.JoinHostPort("localhost", strconv.Itoa(st.Cfg.GRPC.Port)),
+		AppID:     st.GetTestAppID(),
+		AppSecret: st.GetTestAppSecret(),
+		DialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(suite.TransportCredentials(t, false)),
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
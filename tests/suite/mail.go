@@ -0,0 +1,69 @@
+package suite
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var mailTokenRe = regexp.MustCompile(`token=(\S+)`)
+
+// LastMail returns the last message sent to the address by the file mailer.
+func (s *Suite) LastMail(to string) *mail.Message {
+	s.Helper()
+
+	entries, err := os.ReadDir(s.MailDir)
+	if err != nil {
+		s.Fatalf("failed to read mail dir: %v", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	slices.Sort(names)
+	slices.Reverse(names)
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(s.MailDir, name))
+		if err != nil {
+			s.Fatalf("failed to read mail: %v", err)
+		}
+
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			s.Fatalf("failed to parse mail: %v", err)
+		}
+
+		if strings.EqualFold(msg.Header.Get("To"), to) {
+			return msg
+		}
+	}
+
+	s.Fatalf("no mail sent to %s", to)
+
+	return nil
+}
+
+// MailToken returns the one-time token from the link in the last message
+// sent to the address.
+func (s *Suite) MailToken(to string) string {
+	s.Helper()
+
+	body, err := io.ReadAll(s.LastMail(to).Body)
+	if err != nil {
+		s.Fatalf("failed to read mail body: %v", err)
+	}
+
+	match := mailTokenRe.FindSubmatch(body)
+	if match == nil {
+		s.Fatalf("no token in mail to %s", to)
+	}
+
+	return string(match[1])
+}
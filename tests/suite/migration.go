@@ -2,41 +2,69 @@ package suite
 
 import (
 	"errors"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/JSONStatham/sso/internal/config"
 	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/pgx/v5"
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
-func runMigrations(t *testing.T) {
+func runMigrations(t *testing.T, cfg *config.Config) {
 	t.Helper()
 
 	_, filename, _, _ := runtime.Caller(0)
 	rootDir := filepath.Dir(filepath.Dir(filepath.Dir(filename)))
-	migrationsPath := filepath.Join(rootDir, "migrations")
+	migrationsPath := filepath.Join(rootDir, "migrations", cfg.Storage.Driver)
 
 	if _, err := os.Stat(migrationsPath); err != nil {
 		t.Errorf("migrations path error: %v", err)
 	}
 
-	m, err := migrate.New(
-		"file://"+filepath.ToSlash(migrationsPath),
-		"sqlite3://file::memory:?cache=shared",
-	)
+	databaseURL, err := migrationsDatabaseURL(cfg)
 	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.New("file://"+filepath.ToSlash(migrationsPath), databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A Postgres database outlives the test run, so it may be up to date.
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		t.Error(err)
 	}
+}
+
+func migrationsDatabaseURL(cfg *config.Config) (string, error) {
+	// The service checks the version recorded in the configured table.
+	table := url.Values{"x-migrations-table": {cfg.Storage.MigrationsTable}}
 
-	if err := m.Up(); err != nil {
-		if errors.Is(err, migrate.ErrNoChange) {
-			t.Error("no migrations to apply")
-			return
+	if cfg.Storage.Driver != config.DriverPostgres {
+		separator := "?"
+		if strings.Contains(cfg.StoragePath, "?") {
+			separator = "&"
 		}
 
-		t.Error(err)
+		return "sqlite3://" + cfg.StoragePath + separator + table.Encode(), nil
+	}
+
+	u, err := url.Parse(cfg.Storage.DSN)
+	if err != nil {
+		return "", err
 	}
+	u.Scheme = "pgx5"
+
+	q := u.Query()
+	q.Set("x-migrations-table", cfg.Storage.MigrationsTable)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
 }
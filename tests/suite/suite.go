@@ -2,9 +2,14 @@ package suite
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
 	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"testing"
@@ -13,31 +18,57 @@ import (
 	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
 	"github.com/JSONStatham/sso/internal/app"
 	"github.com/JSONStatham/sso/internal/config"
+	"github.com/JSONStatham/sso/internal/utils/jwt"
 	slogdiscard "github.com/JSONStatham/sso/internal/utils/logger/sl/handlers"
+	"github.com/JSONStatham/sso/internal/utils/opaque"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 )
 
 const (
 	grpcHost           = "localhost"
 	defaultWaitTime    = 5 * time.Second
-	defaultJWTSecret   = "test-secret-1234567890"
 	defaultTestAppName = "test-app"
+
+	// ClientCertSubject is the subject of the client certificate, which
+	// authenticates as the test app
+	ClientCertSubject = "CN=test-client"
+
+	tlsDir = "testdata/tls"
 )
 
 var (
-	testAppID int64
-	setupOnce sync.Once
+	testAppID     int64
+	testAppSecret string
+	setupOnce     sync.Once
+
+	// spans records the spans of every test. Tracers only ever delegate to
+	// the first global provider, so it is installed once.
+	spans       = tracetest.NewInMemoryExporter()
+	tracingOnce sync.Once
 )
 
 type Suite struct {
 	*testing.T
-	Cfg        *config.Config
-	Log        *slog.Logger
-	App        *app.App
-	AuthClient ssov1.AuthClient
-	GRPCClient *grpc.ClientConn
+	Cfg          *config.Config
+	Log          *slog.Logger
+	App          *app.App
+	AuthClient   ssov1.AuthClient
+	AppClient    ssov1.AppServiceClient
+	AuditClient  ssov1.AuditServiceClient
+	HealthClient healthpb.HealthClient
+	GRPCClient   *grpc.ClientConn
+	HTTPAddr     string
+	SigningKey   jwt.Key
+	MailDir      string
 }
 
 // GetTestAppID returns the ID of the test app created during setup
@@ -45,13 +76,70 @@ func (s *Suite) GetTestAppID() int64 {
 	return testAppID
 }
 
+// GetTestAppSecret returns the client secret of the test app
+func (s *Suite) GetTestAppSecret() string {
+	return testAppSecret
+}
+
+// AppContext returns a context authenticated with the test app credentials
+func (s *Suite) AppContext(ctx context.Context) context.Context {
+	return AppContext(ctx, testAppID, testAppSecret)
+}
+
+// UserContext returns a context authenticated with the access token of a
+// user
+func UserContext(ctx context.Context, accessToken string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+accessToken)
+}
+
+// AdminContext returns a context authenticated with the access token of an
+// admin
+func AdminContext(ctx context.Context, accessToken string) context.Context {
+	return UserContext(ctx, accessToken)
+}
+
+// Spans returns the spans of the trace recorded so far
+func (s *Suite) Spans(traceID trace.TraceID) tracetest.SpanStubs {
+	var res tracetest.SpanStubs
+	for _, span := range spans.GetSpans() {
+		if span.SpanContext.TraceID() == traceID {
+			res = append(res, span)
+		}
+	}
+
+	return res
+}
+
+// AppContext returns a context carrying the given app credentials
+func AppContext(ctx context.Context, appID int64, secret string) context.Context {
+	credentials := base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(appID, 10) + ":" + secret))
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Basic "+credentials)
+}
+
 func New(t *testing.T) (context.Context, *Suite) {
 	t.Helper()
 
 	setupTestEnv(t)
+
+	mailDir := t.TempDir()
+	t.Setenv("MAILER_DIR", mailDir)
+
 	cfg := config.MustLoadByPath("../config/test.yaml")
 	log := slogdiscard.NewDiscardLogger()
 
+	tracingOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	})
+
+	// The service is not ready until the schema is up to date, and the
+	// test app has to exist to be mapped to the client certificate
+	setupOnce.Do(func() {
+		runMigrations(t, cfg)
+		createTestApp(t, cfg)
+	})
+	cfg.GRPC.TLS.ClientApps = map[string]int64{ClientCertSubject: testAppID}
+
 	// Initialize application
 	app := app.New(log, cfg)
 
@@ -61,33 +149,53 @@ func New(t *testing.T) (context.Context, *Suite) {
 			t.Logf("gRPC server error: %v", err)
 		}
 	}()
+	go func() {
+		if err := app.HTTPSrv.Run(); err != nil {
+			t.Logf("HTTP server error: %v", err)
+		}
+	}()
+	go func() {
+		if err := app.MetricsSrv.Run(); err != nil {
+			t.Logf("metrics server error: %v", err)
+		}
+	}()
 
-	clientConn := setupGRPCClient(t, cfg)
+	clientConn := dial(t, cfg, TransportCredentials(t, false))
+	waitForServing(t, clientConn)
+	waitForServerReady(t, cfg.HTTP.Port)
 
-	setupOnce.Do(func() {
-		runMigrations(t)
-		createTestApp(t, app)
-	})
+	signingKey, err := jwt.LoadKey(cfg.JWT.KeyID, cfg.JWT.PrivateKeyPath)
+	require.NoError(t, err)
 
 	t.Cleanup(func() {
 		if err := clientConn.Close(); err != nil {
 			t.Logf("Failed to close gRPC client: %v", err)
 		}
+		app.Health.Stop()
 		app.GRPCSrv.Stop()
+		app.HTTPSrv.Stop()
+		app.MetricsSrv.Stop()
+		app.Sweeper.Stop()
 		app.Storage.Close()
+		app.Tracing.Stop()
 
 		// Clean environment
-		os.Unsetenv("JWT_SECRET")
 		os.Unsetenv("ENV")
 	})
 
 	return context.Background(), &Suite{
-		T:          t,
-		Cfg:        cfg,
-		Log:        log,
-		App:        app,
-		AuthClient: ssov1.NewAuthClient(clientConn),
-		GRPCClient: clientConn,
+		T:            t,
+		Cfg:          cfg,
+		Log:          log,
+		App:          app,
+		AuthClient:   ssov1.NewAuthClient(clientConn),
+		AppClient:    ssov1.NewAppServiceClient(clientConn),
+		AuditClient:  ssov1.NewAuditServiceClient(clientConn),
+		HealthClient: healthpb.NewHealthClient(clientConn),
+		GRPCClient:   clientConn,
+		HTTPAddr:     "http://" + net.JoinHostPort(grpcHost, strconv.Itoa(cfg.HTTP.Port)),
+		SigningKey:   signingKey,
+		MailDir:      mailDir,
 	}
 }
 
@@ -96,40 +204,101 @@ func setupTestEnv(t *testing.T) {
 	t.Helper()
 
 	os.Setenv("ENV", "test")
-	os.Setenv("JWT_SECRET", defaultJWTSecret)
 }
 
-func createTestApp(t *testing.T, app *app.App) {
+func createTestApp(t *testing.T, cfg *config.Config) {
+	storage, err := app.NewStorage(cfg)
+	require.NoError(t, err)
+	defer storage.Close()
+
 	// Create test app
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	id, err := app.Storage.CreateApp(ctx, defaultTestAppName)
+	// Apps outlive the run on Postgres, so the name has to be unique.
+	name := fmt.Sprintf("%s-%d", defaultTestAppName, time.Now().UnixNano())
+
+	id, err := storage.CreateApp(ctx, name)
 	require.NoError(t, err)
 	testAppID = id
+
+	secret, secretHash, err := opaque.New()
+	require.NoError(t, err)
+	require.NoError(t, storage.SetAppSecret(ctx, id, secretHash))
+	testAppSecret = secret
+}
+
+// Dial connects to the gRPC server with the given credentials. The
+// connection is closed when the test ends
+func (s *Suite) Dial(creds credentials.TransportCredentials) *grpc.ClientConn {
+	s.Helper()
+
+	conn := dial(s.T, s.Cfg, creds)
+	s.Cleanup(func() { conn.Close() })
+
+	return conn
 }
 
-func setupGRPCClient(t *testing.T, cfg *config.Config) *grpc.ClientConn {
+// TransportCredentials trust the test CA, and present the client
+// certificate if clientCert is set
+func TransportCredentials(t *testing.T, clientCert bool) credentials.TransportCredentials {
 	t.Helper()
 
-	// Wait for server to be ready
-	waitForServerReady(t, cfg.GRPC.Port)
+	ca, err := os.ReadFile(filepath.Join(tlsDir, "ca.pem"))
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(ca))
+
+	tlsConfig := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+
+	if clientCert {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(tlsDir, "client.pem"), filepath.Join(tlsDir, "client.key"))
+		require.NoError(t, err)
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsConfig)
+}
+
+func dial(t *testing.T, cfg *config.Config, creds credentials.TransportCredentials) *grpc.ClientConn {
+	t.Helper()
 
 	// Create client
 	conn, err := grpc.NewClient(
 		net.JoinHostPort(grpcHost, strconv.Itoa(cfg.GRPC.Port)),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(creds),
+		// Propagates the trace context of the test to the server
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 	)
-
-	if err != nil {
-		t.Fatalf("failed to create grpc client: %v", err)
-	}
-
 	require.NoError(t, err)
 
 	return conn
 }
 
+// waitForServing waits until the health service reports the server as
+// serving
+func waitForServing(t *testing.T, conn *grpc.ClientConn) {
+	t.Helper()
+
+	client := healthpb.NewHealthClient(conn)
+	deadline := time.Now().Add(defaultWaitTime)
+
+	for time.Now().Before(deadline) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		cancel()
+
+		if err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_SERVING {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("Server didn't become ready within %v", defaultWaitTime)
+}
+
 func registerCleanup(t *testing.T, app *app.App, conn *grpc.ClientConn) {
 	t.Helper()
 
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestTLS_ClientCertificateAuthenticatesApp(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+	token := login(ctx, t, st, email, password)
+
+	// No app credentials are sent, the client certificate identifies the app
+	client := ssov1.NewAuthClient(st.Dial(suite.TransportCredentials(t, true)))
+
+	resp, err := client.Introspect(ctx, &ssov1.IntrospectRequest{Token: token})
+	require.NoError(t, err)
+	assert.True(t, resp.GetActive())
+	assert.Equal(t, st.GetTestAppID(), resp.GetAppId())
+
+	// Without a client certificate the app has to send its credentials
+	_, err = st.AuthClient.Introspect(ctx, &ssov1.IntrospectRequest{Token: token})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestTLS_RejectsInsecureConnections(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name  string
+		creds credentials.TransportCredentials
+	}{
+		{name: "Plaintext", creds: insecure.NewCredentials()},
+		// The certificate of the server is not signed by a trusted CA
+		{name: "Untrusted server", creds: credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := healthpb.NewHealthClient(st.Dial(tt.creds))
+
+			_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing_Login(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	ctx, root := otel.Tracer("tests").Start(ctx, "TestTracing_Login")
+	_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: st.GetTestAppID()})
+	require.NoError(t, err)
+	root.End()
+
+	// The server ends its span after the response is sent
+	var spans tracetest.SpanStubs
+	require.Eventually(t, func() bool {
+		spans = st.Spans(root.SpanContext().TraceID())
+		_, ok := findSpan(spans, "auth.Auth/Login", trace.SpanKindServer)
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	// The trace context of the client is continued by the server
+	server, _ := findSpan(spans, "auth.Auth/Login", trace.SpanKindServer)
+	client, ok := findSpan(spans, "auth.Auth/Login", trace.SpanKindClient)
+	require.True(t, ok)
+	assert.Equal(t, client.SpanContext.SpanID(), server.Parent.SpanID())
+
+	login, ok := findSpan(spans, "auth.Login", trace.SpanKindInternal)
+	require.True(t, ok)
+	assert.Equal(t, server.SpanContext.SpanID(), login.Parent.SpanID())
+
+	for _, name := range []string{"password.Compare", "jwt.Sign"} {
+		span, ok := findSpan(spans, name, trace.SpanKindInternal)
+		if assert.True(t, ok, name) {
+			assert.Equal(t, login.SpanContext.SpanID(), span.Parent.SpanID(), name)
+		}
+	}
+
+	var queries []string
+	for _, span := range spans {
+		for _, attr := range span.Attributes {
+			if attr.Key == semconv.DBQueryTextKey {
+				queries = append(queries, attr.Value.AsString())
+			}
+		}
+	}
+	assert.True(t, containsQuery(queries, "FROM users"), "users query is traced")
+	assert.True(t, containsQuery(queries, "FROM apps"), "apps query is traced")
+}
+
+func findSpan(spans tracetest.SpanStubs, name string, kind trace.SpanKind) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name && span.SpanKind == kind {
+			return span, true
+		}
+	}
+
+	return tracetest.SpanStub{}, false
+}
+
+func containsQuery(queries []string, substr string) bool {
+	for _, query := range queries {
+		if strings.Contains(query, substr) {
+			return true
+		}
+	}
+
+	return false
+}
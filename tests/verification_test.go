@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"testing"
+
+	ssov1 "github.com/JSONStatham/protos/gen/go/sso"
+	"github.com/JSONStatham/sso/tests/suite"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVerification_ConfirmEmail(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	appID, err := st.App.Storage.CreateApp(ctx, "verified-app-"+gofakeit.UUID())
+	require.NoError(t, err)
+	require.NoError(t, st.App.Storage.SetAppRequireVerifiedEmail(ctx, appID, true))
+
+	email, password := registerNewUser(ctx, t, st.AuthClient)
+
+	// Registration sends the verification email
+	msg := st.LastMail(email)
+	assert.Equal(t, "Confirm your email address", msg.Header.Get("Subject"))
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "Unverified users should be refused")
+
+	// Apps that do not require verification accept unverified users
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.GetTestAppID(),
+	})
+	require.NoError(t, err)
+
+	token := st.MailToken(email)
+
+	_, err = st.AuthClient.ConfirmEmail(ctx, &ssov1.ConfirmEmailRequest{Token: token})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	// Verification tokens are single-use
+	_, err = st.AuthClient.ConfirmEmail(ctx, &ssov1.ConfirmEmailRequest{Token: token})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestVerification_Resend(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, _ := registerNewUser(ctx, t, st.AuthClient)
+	first := st.MailToken(email)
+
+	_, err := st.AuthClient.SendVerification(ctx, &ssov1.SendVerificationRequest{Email: email})
+	require.NoError(t, err)
+
+	second := st.MailToken(email)
+	assert.NotEqual(t, first, second)
+
+	_, err = st.AuthClient.ConfirmEmail(ctx, &ssov1.ConfirmEmailRequest{Token: second})
+	require.NoError(t, err)
+}
+
+func TestVerification_UnknownEmail(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// Unknown emails are not revealed
+	_, err := st.AuthClient.SendVerification(ctx, &ssov1.SendVerificationRequest{Email: gofakeit.Email()})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ConfirmEmail(ctx, &ssov1.ConfirmEmailRequest{Token: "invalid"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,6 @@
+module github.com/JSONStatham/protos
+
+go 1.24
+
+require google.golang.org/grpc v1.71.0
+require google.golang.org/protobuf v1.36.6